@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"books-api/internal/apikeys"
+	"books-api/internal/audit"
+	"books-api/internal/auth"
+	"books-api/internal/config"
+	"books-api/internal/db"
+	custommw "books-api/internal/middleware"
+)
+
+// newVerifier собирает проверку JWT из настроек; nil — аутентификация
+// не настроена и все запросы анонимные
+func newVerifier(cfg config.Config) *auth.Verifier {
+	v := &auth.Verifier{Issuer: cfg.JWTIssuer, Audience: cfg.JWTAudience, Leeway: auth.DefaultLeeway}
+	if cfg.JWTSecret != "" {
+		v.Secret = []byte(cfg.JWTSecret)
+	}
+	switch {
+	case cfg.JWKSFile != "":
+		v.Keys = auth.NewJWKSFile(cfg.JWKSFile, cfg.JWKSTTL)
+	case cfg.JWKSURL != "":
+		v.Keys = auth.NewJWKSURL(cfg.JWKSURL, cfg.JWKSTTL, nil)
+	}
+	if v.Secret == nil && v.Keys == nil {
+		return nil
+	}
+	return v
+}
+
+// newActorResolver выбирает актора для журнала: ключ API или subject из
+// токена. Заголовкам X-User и X-Actor верим только за доверенным прокси
+// (AUTH_IDENTITY=header), иначе любой клиент подписал бы изменение чужим именем.
+func newActorResolver(cfg config.Config) audit.ActorResolver {
+	trustHeaders := cfg.AuthIdentity == "header"
+	return func(r *http.Request) string {
+		if k, ok := apikeys.FromContext(r.Context()); ok {
+			return k.Subject()
+		}
+		if p, ok := auth.PrincipalFrom(r.Context()); ok {
+			return p.Subject
+		}
+		if !trustHeaders {
+			return ""
+		}
+		if user := audit.HeaderActor("X-User")(r); user != "" {
+			return user
+		}
+		return audit.HeaderActor("X-Actor")(r)
+	}
+}
+
+// newPolicy включает проверку прав по AUTH_IDENTITY. Анонимные запросы
+// получают роль читателя, отказы пишутся в журнал изменений. Ключ API
+// проверяется раньше пользователя и даёт ровно свои права.
+func newPolicy(cfg config.Config, database db.TxDB) *custommw.Policy {
+	p := &custommw.Policy{AnonymousRoles: []string{custommw.RoleReader}}
+	switch cfg.AuthIdentity {
+	case "token":
+		p.Extract = custommw.TokenIdentity
+	case "header":
+		p.Extract = custommw.HeaderIdentity("X-User", "X-Roles")
+	default:
+		return nil
+	}
+	p.Extract = custommw.FirstIdentity(apikeys.Identity, p.Extract)
+	p.OnDeny = func(r *http.Request, id custommw.Identity, perm custommw.Permission) {
+		src := audit.FromRequest(r)
+		if id.Subject != "" {
+			src.Actor = id.Subject
+		}
+		denial := map[string]any{"method": r.Method, "path": r.URL.Path, "permission": perm, "roles": id.Roles}
+		if err := audit.Record(r.Context(), database, src, "deny", "route", nil, nil, denial); err != nil {
+			log.Printf("ошибка записи отказа в журнал: %v", err)
+		}
+	}
+	return p
+}
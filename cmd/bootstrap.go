@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"books-api/internal/config"
+	"books-api/internal/db"
+)
+
+// env — то, что нужно любой подкоманде: конфиг и подключение к БД
+type env struct {
+	cfg  config.Config
+	pool *pgxpool.Pool
+	db   db.TxDB
+}
+
+func bootstrap() (*env, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := db.NewDB(cfg.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+	return &env{cfg: cfg, pool: pool, db: &db.PgxPoolTxDB{Pool: pool}}, nil
+}
+
+func (e *env) Close() {
+	e.pool.Close()
+}
+
+// signalContext отменяется по SIGINT/SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+
+	"books-api/internal/audit"
+	"books-api/internal/books"
+	"books-api/internal/collections"
+	"books-api/internal/outbox"
+	"books-api/internal/render"
+)
+
+// runImport импортирует книги из CSV или NDJSON тем же кодом, что и
+// POST /api/v1/books/import
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "-", "CSV или NDJSON с книгами, - для stdin")
+	format := flags.String("format", "", "csv или ndjson (по умолчанию по расширению файла)")
+	mode := flags.String("mode", books.ImportAtomic, "atomic или best_effort")
+	dryRun := flags.Bool("dry-run", false, "только проверить данные, ничего не сохранять")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = books.DetectFormat(*file, "")
+	}
+	if *format == "" {
+		return errors.New("cannot detect format, use -format csv|ndjson")
+	}
+	in, err := openInput(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+	books.SetProducer(outbox.NewWriter())
+
+	report, err := books.Import(ctx, e.db, bufio.NewReader(in), books.ImportOptions{
+		Format: *format,
+		Mode:   *mode,
+		DryRun: *dryRun,
+		Source: audit.System("import"),
+	})
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range report.Errors {
+		log.Printf("строка %d: %s", rowErr.Row, rowErr.Message)
+	}
+	log.Printf("импорт (%s, dry-run=%t): всего %d, импортировано %d, ошибок %d",
+		report.Mode, report.DryRun, report.Total, report.Imported, report.Failed)
+	if report.Mode == books.ImportAtomic && report.Failed > 0 {
+		return errors.New("import aborted")
+	}
+	return nil
+}
+
+// runExport выгружает каталог тем же кодом, что и GET /api/v1/books/export
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	file := flags.String("out", "-", "файл для выгрузки, - для stdout")
+	format := flags.String("format", render.FormatNDJSON, "ndjson, csv или json")
+	withCollections := flags.Bool("collections", false, "выгрузить подборки с книгами вместо книг")
+	author := flags.String("author", "", "фильтр по автору (подстрока)")
+	title := flags.String("title", "", "фильтр по названию (подстрока)")
+	from := flags.String("published-from", "", "дата издания от, YYYY-MM-DD")
+	to := flags.String("published-to", "", "дата издания до, YYYY-MM-DD")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filter, err := books.ParseBookFilter(url.Values{
+		"author":         {*author},
+		"title":          {*title},
+		"published_from": {*from},
+		"published_to":   {*to},
+	})
+	if err != nil {
+		return err
+	}
+	out, err := openOutput(*file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+
+	bw := bufio.NewWriter(out)
+	var n int
+	if *withCollections {
+		n, err = collections.Export(ctx, e.db, bw, *format, collections.Viewer{Admin: true})
+	} else {
+		n, err = books.Export(ctx, e.db, bw, *format, filter)
+	}
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("выгружено записей: %d", n)
+	return nil
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func openOutput(name string) (io.WriteCloser, error) {
+	if name == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(name)
+}
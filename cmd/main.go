@@ -1,67 +1,53 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"net/http"
 	"os"
+)
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
 
-	"books-api/internal/books"
-	"books-api/internal/collections"
-	"books-api/internal/db"
-	"books-api/internal/kafka"
-	custommw "books-api/internal/middleware"
-)
+var commands = []command{
+	{"serve", "запустить HTTP API (по умолчанию)", runServe},
+	{"migrate", "применить SQL-миграции", runMigrate},
+	{"seed", "загрузить тестовые книги и подборки", runSeed},
+	{"import", "импортировать книги из файла", runImport},
+	{"export", "выгрузить каталог книг", runExport},
+	{"purge", "окончательно удалить книги из корзины", runPurge},
+	{"outbox-relay", "публиковать события из outbox в Kafka", runOutboxRelay},
+	{"smart-refresh", "пересчитывать умные подборки по событиям книг", runSmartRefresh},
+}
 
 func main() {
-	dsn := os.Getenv("DATABASE_DSN")
-	if dsn == "" {
-		log.Fatal("DATABASE_DSN is not set")
+	name, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
 	}
-	pool, err := db.NewDB(dsn)
-	if err != nil {
-		log.Fatalf("DB error: %v", err)
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
 	}
-	defer pool.Close()
-
-	brokers := []string{"localhost:9092"}
-	writer := kafka.NewProducer(brokers, "books-events")
-	defer writer.Close()
-
-	dbAdapter := &db.PgxPoolTxDB{Pool: pool}
-	books.SetBookDB(dbAdapter)
-	books.SetProducer(writer)
-	collections.SetCollectionDB(dbAdapter)
-	collections.SetProducer(writer)
-
-	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(custommw.Logger)
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(args); err != nil {
+				log.Fatalf("%s: %v", name, err)
 			}
-			next.ServeHTTP(w, r)
-		})
-	})
-
-	r.Route("/api/v1", func(r chi.Router) {
-		books.RegisterRoutes(r)
-		collections.RegisterRoutes(r)
-	})
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
 
-	log.Println("Server started on :8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatalf("server error: %v", err)
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: books-api <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.usage)
 	}
 }
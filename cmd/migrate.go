@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+
+	"books-api/internal/db"
+	"books-api/migrations"
+)
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := flags.String("dir", "", "каталог с миграциями (по умолчанию встроенные в бинарник)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+
+	var source fs.FS = migrations.FS
+	if *dir != "" {
+		source = os.DirFS(*dir)
+	}
+	applied, err := db.Migrate(ctx, e.db, source)
+	for _, name := range applied {
+		log.Printf("применена миграция %s", name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		log.Println("схема актуальна")
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"books-api/internal/audit"
+	"books-api/internal/books"
+	"books-api/internal/outbox"
+)
+
+// runPurge удаляет книги, пролежавшие в корзине дольше срока хранения;
+// рассчитан на запуск из cron
+func runPurge(args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	olderThan := flags.Duration("older-than", 0, "срок хранения в корзине (по умолчанию BOOKS_TRASH_RETENTION, 720h)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+
+	retention := e.cfg.TrashRetention
+	if *olderThan > 0 {
+		retention = *olderThan
+	}
+	books.SetProducer(outbox.NewWriter())
+	n, err := books.Purge(ctx, e.db, retention, audit.System("purge"))
+	if err != nil {
+		return err
+	}
+	log.Printf("удалено книг из корзины: %d (старше %s)", n, retention)
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+
+	"books-api/internal/kafka"
+	"books-api/internal/outbox"
+)
+
+// runOutboxRelay запускает только публикацию событий из outbox — для
+// развёртываний, где API работает с OUTBOX_RELAY_EMBEDDED=false
+func runOutboxRelay(args []string) error {
+	flags := flag.NewFlagSet("outbox-relay", flag.ExitOnError)
+	once := flags.Bool("once", false, "отправить накопившиеся события и выйти")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+
+	writer := kafka.NewProducer(e.cfg.KafkaBrokers, e.cfg.KafkaTopic)
+	defer writer.Close()
+	relay := outbox.NewRelay(e.db, writer, e.cfg.OutboxInterval)
+
+	if *once {
+		total := 0
+		for {
+			n, err := relay.RelayOnce(ctx)
+			if err != nil {
+				return err
+			}
+			total += n
+			if n < relay.BatchSize {
+				break
+			}
+		}
+		log.Printf("отправлено событий: %d", total)
+		return nil
+	}
+	log.Printf("outbox relay запущен, брокеры %v, топик %s", e.cfg.KafkaBrokers, e.cfg.KafkaTopic)
+	if err := relay.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/audit"
+	"books-api/internal/books"
+	"books-api/internal/db"
+)
+
+//go:embed fixtures/seed.json
+var defaultFixtures []byte
+
+type fixtures struct {
+	Books []struct {
+		Title  string `json:"title"`
+		Author string `json:"author"`
+	} `json:"books"`
+	Collections []struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Books       []string `json:"books"`
+	} `json:"collections"`
+}
+
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	file := flags.String("file", "", "JSON с фикстурами (по умолчанию встроенные)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	data := defaultFixtures
+	if *file != "" {
+		var err error
+		if data, err = os.ReadFile(*file); err != nil {
+			return err
+		}
+	}
+	var fx fixtures
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+	return seed(ctx, e.db, fx)
+}
+
+// seed идемпотентен: существующие книги (по названию и автору) и подборки
+// (по имени) не дублируются
+func seed(ctx context.Context, database db.TxDB, fx fixtures) error {
+	tx, err := database.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	bookIDs := make(map[string]int)
+	var inserted []int
+	for _, b := range fx.Books {
+		var id int
+		row := tx.QueryRow(ctx, "SELECT id FROM books WHERE title=$1 AND author=$2", b.Title, b.Author)
+		if err := row.Scan(&id); err != nil {
+			if err != pgx.ErrNoRows {
+				return err
+			}
+			row = tx.QueryRow(ctx, "INSERT INTO books (title, author) VALUES ($1, $2) RETURNING id", b.Title, b.Author)
+			if err := row.Scan(&id); err != nil {
+				return err
+			}
+			inserted = append(inserted, id)
+		}
+		bookIDs[b.Title] = id
+	}
+	for _, c := range fx.Collections {
+		var id int
+		row := tx.QueryRow(ctx, "SELECT id FROM collections WHERE name=$1", c.Name)
+		if err := row.Scan(&id); err != nil {
+			if err != pgx.ErrNoRows {
+				return err
+			}
+			row = tx.QueryRow(ctx, "INSERT INTO collections (name, description) VALUES ($1, $2) RETURNING id", c.Name, c.Description)
+			if err := row.Scan(&id); err != nil {
+				return err
+			}
+		}
+		for _, title := range c.Books {
+			bookID, ok := bookIDs[title]
+			if !ok {
+				log.Printf("seed: книга %q из подборки %q не найдена в фикстурах", title, c.Name)
+				continue
+			}
+			if _, err := tx.Exec(ctx, `INSERT INTO collection_books (collection_id, book_id, position)
+				SELECT $1, $2, COALESCE(max(position), 0) + 1 FROM collection_books WHERE collection_id = $1
+				ON CONFLICT (collection_id, book_id) DO NOTHING`, id, bookID); err != nil {
+				return err
+			}
+		}
+	}
+	if err := books.LinkLegacyAuthors(ctx, tx, inserted); err != nil {
+		return err
+	}
+	if err := books.RecordInitialRevisions(ctx, tx, audit.System("seed"), inserted); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("seed: книг %d, подборок %d", len(fx.Books), len(fx.Collections))
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"books-api/internal/apikeys"
+	"books-api/internal/audit"
+	"books-api/internal/auth"
+	"books-api/internal/authors"
+	"books-api/internal/books"
+	"books-api/internal/carts"
+	"books-api/internal/collections"
+	"books-api/internal/genres"
+	"books-api/internal/inventory"
+	"books-api/internal/kafka"
+	custommw "books-api/internal/middleware"
+	"books-api/internal/orders"
+	"books-api/internal/outbox"
+	"books-api/internal/payment"
+	"books-api/internal/pricing"
+	"books-api/internal/render"
+	"books-api/internal/reviews"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "", "адрес HTTP-сервера (по умолчанию HTTP_ADDR или :8080)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	if *addr == "" {
+		*addr = e.cfg.HTTPAddr
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	events := outbox.NewWriter()
+	apikeys.SetAPIKeyDB(e.db)
+	audit.SetAuditDB(e.db)
+	audit.SetActorResolver(newActorResolver(e.cfg))
+	authors.SetAuthorDB(e.db)
+	authors.SetProducer(events)
+	books.SetBookDB(e.db)
+	books.SetProducer(events)
+	books.SetTrashRetention(e.cfg.TrashRetention)
+	genres.SetGenreDB(e.db)
+	genres.SetProducer(events)
+	collections.SetCollectionDB(e.db)
+	collections.SetProducer(events)
+	inventory.SetInventoryDB(e.db)
+	inventory.SetProducer(events)
+	pricing.SetPricingDB(e.db)
+	pricing.SetProducer(events)
+	carts.SetCartDB(e.db)
+	orders.SetOrderDB(e.db)
+	orders.SetProducer(events)
+	// настоящего провайдера оплаты пока нет, заказы оплачиваются заглушкой
+	orders.SetPaymentGateway(payment.NewFake())
+	reviews.SetReviewDB(e.db)
+	reviews.SetProducer(events)
+
+	if e.cfg.OutboxEmbedded {
+		writer := kafka.NewProducer(e.cfg.KafkaBrokers, e.cfg.KafkaTopic)
+		defer writer.Close()
+		go func() {
+			if err := outbox.NewRelay(e.db, writer, e.cfg.OutboxInterval).Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("outbox relay остановлен: %v", err)
+			}
+		}()
+	}
+
+	verifier := newVerifier(e.cfg)
+	if verifier == nil {
+		log.Printf("JWT не настроен, все запросы анонимные")
+	}
+	if e.cfg.AuthIdentity == "token" && verifier == nil {
+		return errors.New("AUTH_IDENTITY=token requires JWT_SECRET, JWT_JWKS_FILE or JWT_JWKS_URL")
+	}
+	if policy := newPolicy(e.cfg, e.db); policy != nil {
+		custommw.SetPolicy(policy)
+	} else {
+		log.Printf("проверка прав выключена (AUTH_IDENTITY=none)")
+	}
+	srv := &http.Server{Addr: *addr, Handler: newRouter(verifier)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("ошибка остановки сервера: %v", err)
+		}
+	}()
+
+	log.Printf("Server started on %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func newRouter(verifier *auth.Verifier) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Use(custommw.Logger)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(render.Acceptable)
+		if verifier != nil {
+			r.Use(auth.Authenticate(verifier))
+		}
+		r.Use(apikeys.Authenticate)
+		books.RegisterRoutes(r)
+		reviews.RegisterRoutes(r)
+		inventory.RegisterRoutes(r)
+		pricing.RegisterRoutes(r)
+		carts.RegisterRoutes(r)
+		orders.RegisterRoutes(r)
+		authors.RegisterRoutes(r)
+		genres.RegisterRoutes(r)
+		collections.RegisterRoutes(r)
+		audit.RegisterRoutes(r)
+		apikeys.RegisterRoutes(r)
+	})
+	return r
+}
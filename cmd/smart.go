@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"books-api/internal/collections"
+	"books-api/internal/kafka"
+)
+
+// runSmartRefresh пересчитывает материализованные умные подборки: один раз
+// или постоянно, по событиям книг из Kafka
+func runSmartRefresh(args []string) error {
+	flags := flag.NewFlagSet("smart-refresh", flag.ExitOnError)
+	once := flags.Bool("once", false, "пересчитать все подборки и выйти")
+	group := flags.String("group", "books-smart-collections", "группа потребителей Kafka")
+	interval := flags.Duration("interval", 5*time.Second, "как долго копить события перед пересчётом")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	e, err := bootstrap()
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+	ctx, stop := signalContext()
+	defer stop()
+
+	if *once {
+		n, err := collections.RefreshSmart(ctx, e.db)
+		if err != nil {
+			return err
+		}
+		log.Printf("пересчитано умных подборок: %d", n)
+		return nil
+	}
+	reader := kafka.NewConsumer(e.cfg.KafkaBrokers, e.cfg.KafkaTopic, *group)
+	defer reader.Close()
+	log.Printf("пересчёт умных подборок запущен, топик %s, группа %s", e.cfg.KafkaTopic, *group)
+	if err := collections.NewRefresher(e.db, reader, *interval).Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
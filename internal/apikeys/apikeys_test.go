@@ -0,0 +1,212 @@
+package apikeys
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// keyRow отдаёт строку api_keys: для проверки ключа (id, name, hash,
+// scopes) или полную для обработчиков
+type keyRow struct {
+	hash   []byte
+	scopes []string
+	err    error
+}
+
+func (r *keyRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = 7
+	*dest[1].(*string) = "importer"
+	if len(dest) == 4 {
+		*dest[2].(*[]byte) = r.hash
+		*dest[3].(*[]string) = r.scopes
+		return nil
+	}
+	*dest[2].(*string) = "0123456789ab"
+	*dest[3].(*[]string) = r.scopes
+	*dest[5].(*time.Time) = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return nil
+}
+
+type keyDB struct {
+	row     keyRow
+	execs   []string
+	queries int
+}
+
+func (m *keyDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return nil, pgx.ErrNoRows
+}
+func (m *keyDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.queries++
+	return &m.row
+}
+func (m *keyDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.execs = append(m.execs, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *keyDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *keyDB) Rollback(ctx context.Context) error { return nil }
+func (m *keyDB) Commit(ctx context.Context) error   { return nil }
+
+func TestGenerateParse(t *testing.T) {
+	key, prefix, hash, err := generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, secret, err := parse(key)
+	if err != nil {
+		t.Fatalf("parse %q: %v", key, err)
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if p != prefix || !bytes.Equal(sum[:], hash) {
+		t.Fatalf("prefix %q/%q or hash mismatch", p, prefix)
+	}
+	for _, bad := range []string{"", "bk_short", strings.Replace(key, "bk_", "xx_", 1), key + "0"} {
+		if _, _, err := parse(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func serveWithKey(t *testing.T, database *keyDB, set func(*http.Request)) (*httptest.ResponseRecorder, middleware.Identity) {
+	t.Helper()
+	SetAPIKeyDB(database)
+	var id middleware.Identity
+	h := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ = Identity(r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books", nil)
+	set(req)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w, id
+}
+
+func TestAuthenticate(t *testing.T) {
+	key, _, hash, _ := generate()
+	database := &keyDB{row: keyRow{hash: hash, scopes: []string{"read", "books:write"}}}
+	for name, set := range map[string]func(*http.Request){
+		"header":        func(r *http.Request) { r.Header.Set("X-API-Key", key) },
+		"authorization": func(r *http.Request) { r.Header.Set("Authorization", "ApiKey "+key) },
+	} {
+		w, id := serveWithKey(t, database, set)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", name, w.Code)
+		}
+		if id.Subject != "apikey:7" || len(id.Permissions) != 2 || id.Permissions[1] != middleware.PermBooksWrite {
+			t.Fatalf("%s: unexpected identity %+v", name, id)
+		}
+	}
+	if len(database.execs) == 0 || !strings.Contains(database.execs[0], "last_used_at") {
+		t.Fatalf("expected last_used_at update, got %v", database.execs)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	key, _, _, _ := generate()
+	other, _, hash, _ := generate()
+	cases := map[string]struct {
+		database *keyDB
+		key      string
+	}{
+		"malformed":    {&keyDB{}, "nope"},
+		"wrong secret": {&keyDB{row: keyRow{hash: hash}}, key[:len(key)-1] + "x"},
+		"unknown":      {&keyDB{row: keyRow{err: pgx.ErrNoRows}}, other},
+	}
+	for name, c := range cases {
+		w, _ := serveWithKey(t, c.database, func(r *http.Request) { r.Header.Set("X-API-Key", c.key) })
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("%s: unexpected content type %q", name, ct)
+		}
+	}
+}
+
+func TestAuthenticateWithoutKey(t *testing.T) {
+	w, id := serveWithKey(t, &keyDB{}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc") })
+	if w.Code != http.StatusOK || id.Subject != "" {
+		t.Fatalf("expected pass-through, got %d %+v", w.Code, id)
+	}
+}
+
+func TestKeyScopesGrantPermissions(t *testing.T) {
+	p := &middleware.Policy{Roles: middleware.DefaultRoles}
+	id := middleware.Identity{Subject: "apikey:7", Permissions: []middleware.Permission{middleware.PermBooksWrite}}
+	if !p.Allowed(id, middleware.PermBooksWrite) || p.Allowed(id, middleware.PermBooksDelete) {
+		t.Fatal("key scopes must map onto permissions exactly")
+	}
+}
+
+func TestCreateKey(t *testing.T) {
+	SetAPIKeyDB(&keyDB{row: keyRow{scopes: []string{"read"}}})
+	body := `{"name":"importer","scopes":["read"]}`
+	w := httptest.NewRecorder()
+	CreateKey(w, httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var k APIKey
+	if err := json.NewDecoder(w.Body).Decode(&k); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(k.Key, keyPrefix) || k.ID != 7 {
+		t.Fatalf("expected secret in response, got %+v", k)
+	}
+}
+
+func TestCreateKeyValidation(t *testing.T) {
+	SetAPIKeyDB(&keyDB{})
+	for _, body := range []string{
+		`{"scopes":["read"]}`,
+		`{"name":"  ","scopes":["read"]}`,
+		`{"name":"x"}`,
+		`{"name":"x","scopes":["everything"]}`,
+		`{"name":"x","scopes":["read"],"expires_at":"2000-01-01T00:00:00Z"}`,
+	} {
+		w := httptest.NewRecorder()
+		CreateKey(w, httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestKeyRoutesClosedWithoutPolicy(t *testing.T) {
+	database := &keyDB{row: keyRow{scopes: []string{"admin"}}}
+	SetAPIKeyDB(database)
+	middleware.SetPolicy(nil)
+	serve := testutil.Server(RegisterRoutes)
+	w := serve(http.MethodPost, "/api-keys", `{"name":"root","scopes":["apikeys:manage"]}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a policy, got %d: %s", w.Code, w.Body.String())
+	}
+	if database.queries != 0 || len(database.execs) != 0 {
+		t.Fatal("no key must be stored without a policy")
+	}
+
+	testutil.AsUser(t, "root", middleware.RoleAdmin)
+	if w := serve(http.MethodPost, "/api-keys", `{"name":"importer","scopes":["read"]}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for an admin, got %d: %s", w.Code, w.Body.String())
+	}
+}
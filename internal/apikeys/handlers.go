@@ -0,0 +1,296 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+// APIKey — ключ для сервисов. Key заполняется только в ответе на создание
+// и ротацию: в базе хранится лишь хэш секрета.
+type APIKey struct {
+	XMLName    xml.Name   `json:"-" xml:"api_key"`
+	ID         int        `json:"id" xml:"id"`
+	Name       string     `json:"name" xml:"name"`
+	Prefix     string     `json:"prefix" xml:"prefix"`
+	Scopes     []string   `json:"scopes" xml:"scopes>scope"`
+	CreatedBy  *string    `json:"created_by,omitempty" xml:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" xml:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" xml:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" xml:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" xml:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty" xml:"key,omitempty"`
+}
+
+// auditEntity — имя сущности ключа в журнале изменений
+const auditEntity = "api_key"
+
+const keyColumns = "id, name, prefix, scopes, created_by, created_at, expires_at, last_used_at, revoked_at"
+
+func scanKey(row db.Row) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedBy, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// keyState — что о ключе попадает в журнал: без хэша и секрета
+type keyState struct {
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func stateOf(k APIKey) keyState {
+	return keyState{Name: k.Name, Prefix: k.Prefix, Scopes: k.Scopes, ExpiresAt: k.ExpiresAt, RevokedAt: k.RevokedAt}
+}
+
+type createRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (c *createRequest) validate(now time.Time) error {
+	if c.Name = strings.TrimSpace(c.Name); c.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(c.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, s := range c.Scopes {
+		if !middleware.KnownPermission(middleware.Permission(s)) {
+			return fmt.Errorf("unknown scope %q", s)
+		}
+	}
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+// @Summary Создать ключ API
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param key body createRequest true "Имя, права и срок действия"
+// @Success 201 {object} APIKey "Секрет в поле key показывается один раз"
+// @Router /api/v1/api-keys [post]
+func CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := req.validate(time.Now()); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	key, prefix, hash, err := generate()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ctx := r.Context()
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	src := audit.FromRequest(r)
+	var createdBy *string
+	if src.Actor != "" {
+		createdBy = &src.Actor
+	}
+	k, err := scanKey(tx.QueryRow(ctx, `INSERT INTO api_keys (name, prefix, hash, scopes, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+keyColumns,
+		req.Name, prefix, hash, req.Scopes, createdBy, req.ExpiresAt))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, src, "create", auditEntity, &k.ID, nil, stateOf(k)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	k.Key = key
+	w.Header().Set("Cache-Control", "no-store")
+	render.Render(w, r, http.StatusCreated, k)
+}
+
+// @Summary Список ключей API
+// @Tags api-keys
+// @Produce json
+// @Success 200 {array} APIKey
+// @Router /api/v1/api-keys [get]
+func ListKeys(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbi.Query(r.Context(), "SELECT "+keyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, keys)
+}
+
+// lockKey читает действующий ключ под блокировкой; nil — ключа нет или он отозван
+func lockKey(ctx context.Context, tx db.TxDB, id int) (*APIKey, error) {
+	k, err := scanKey(tx.QueryRow(ctx, "SELECT "+keyColumns+" FROM api_keys WHERE id=$1 AND revoked_at IS NULL FOR UPDATE", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func keyID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid id", 400)
+		return 0, false
+	}
+	return id, true
+}
+
+// @Summary Перевыпустить ключ API
+// @Description Старый секрет сразу перестаёт действовать, имя и права сохраняются
+// @Tags api-keys
+// @Produce json
+// @Param id path int true "ID ключа"
+// @Success 200 {object} APIKey
+// @Router /api/v1/api-keys/{id}/rotate [post]
+func RotateKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := keyID(w, r)
+	if !ok {
+		return
+	}
+	key, prefix, hash, err := generate()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	ctx := r.Context()
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	before, err := lockKey(ctx, tx, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if before == nil {
+		http.Error(w, "api key not found", 404)
+		return
+	}
+	k, err := scanKey(tx.QueryRow(ctx, `UPDATE api_keys SET prefix=$1, hash=$2, last_used_at=NULL
+		WHERE id=$3 RETURNING `+keyColumns, prefix, hash, id))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "rotate", auditEntity, &id, stateOf(*before), stateOf(k)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	k.Key = key
+	w.Header().Set("Cache-Control", "no-store")
+	render.Render(w, r, http.StatusOK, k)
+}
+
+// @Summary Отозвать ключ API
+// @Tags api-keys
+// @Param id path int true "ID ключа"
+// @Success 204
+// @Router /api/v1/api-keys/{id} [delete]
+func RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, ok := keyID(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	before, err := lockKey(ctx, tx, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if before == nil {
+		http.Error(w, "api key not found", 404)
+		return
+	}
+	after := *before
+	if err := tx.QueryRow(ctx, "UPDATE api_keys SET revoked_at=now() WHERE id=$1 RETURNING revoked_at", id).Scan(&after.RevokedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "revoke", auditEntity, &id, stateOf(*before), stateOf(after)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
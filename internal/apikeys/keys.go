@@ -0,0 +1,150 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+var dbi db.TxDB
+
+func SetAPIKeyDB(database db.TxDB) {
+	dbi = database
+}
+
+// Формат ключа: bk_<prefix 12 hex>_<secret 64 hex>
+const (
+	keyPrefix    = "bk_"
+	prefixLength = 12
+	secretLength = 64
+)
+
+var ErrInvalidKey = errors.New("invalid api key")
+
+// lastUsedGranularity — чаще last_used_at не обновляется, чтобы каждый
+// запрос импортёра не превращался в запись в БД
+const lastUsedGranularity = time.Minute
+
+// generate создаёт новый ключ и возвращает его целиком, открытую часть и хэш
+func generate() (key, prefix string, hash []byte, err error) {
+	buf := make([]byte, prefixLength/2+secretLength/2)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", nil, err
+	}
+	prefix = hex.EncodeToString(buf[:prefixLength/2])
+	secret := hex.EncodeToString(buf[prefixLength/2:])
+	sum := sha256.Sum256([]byte(secret))
+	return keyPrefix + prefix + "_" + secret, prefix, sum[:], nil
+}
+
+// parse разбирает ключ на открытую часть и секрет
+func parse(key string) (prefix, secret string, err error) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok || len(rest) != prefixLength+1+secretLength || rest[prefixLength] != '_' {
+		return "", "", ErrInvalidKey
+	}
+	return rest[:prefixLength], rest[prefixLength+1:], nil
+}
+
+// Principal — ключ API, которым аутентифицирован запрос
+type Principal struct {
+	ID     int
+	Name   string
+	Scopes []middleware.Permission
+}
+
+type principalKey struct{}
+
+// FromContext достаёт ключ, положенный Authenticate
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Subject — под каким именем ключ попадает в журнал и проверки прав.
+// Имя ключа не уникально, поэтому в subject идёт ID.
+func (p *Principal) Subject() string {
+	return "apikey:" + strconv.Itoa(p.ID)
+}
+
+// Verify находит действующий ключ и сверяет секрет
+func Verify(ctx context.Context, database db.TxDB, key string) (*Principal, error) {
+	prefix, secret, err := parse(key)
+	if err != nil {
+		return nil, err
+	}
+	var p Principal
+	var hash []byte
+	var scopes []string
+	row := database.QueryRow(ctx, `SELECT id, name, hash, scopes FROM api_keys
+		WHERE prefix=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`, prefix)
+	if err := row.Scan(&p.ID, &p.Name, &hash, &scopes); err != nil {
+		return nil, ErrInvalidKey
+	}
+	sum := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(sum[:], hash) != 1 {
+		return nil, ErrInvalidKey
+	}
+	for _, s := range scopes {
+		p.Scopes = append(p.Scopes, middleware.Permission(s))
+	}
+	if _, err := database.Exec(ctx, `UPDATE api_keys SET last_used_at=now()
+		WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < now() - make_interval(secs => $2))`,
+		p.ID, lastUsedGranularity.Seconds()); err != nil {
+		log.Printf("ошибка обновления last_used_at ключа %d: %v", p.ID, err)
+	}
+	return &p, nil
+}
+
+// keyFromRequest читает ключ из X-API-Key или Authorization: ApiKey ...
+func keyFromRequest(r *http.Request) (string, bool) {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key, true
+	}
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "ApiKey") {
+		return "", false
+	}
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// Authenticate проверяет ключ API и кладёт Principal в контекст. Запросы
+// без ключа проходят дальше как есть, неверный ключ — 401.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyFromRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := Verify(r.Context(), dbi, key)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "ApiKey")
+			render.WriteProblem(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// Identity отдаёт права ключа для политики доступа
+func Identity(r *http.Request) (middleware.Identity, bool) {
+	p, ok := FromContext(r.Context())
+	if !ok {
+		return middleware.Identity{}, false
+	}
+	return middleware.Identity{Subject: p.Subject(), Permissions: p.Scopes}, true
+}
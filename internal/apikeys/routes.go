@@ -0,0 +1,35 @@
+package apikeys
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+// RegisterRoutes регистрирует роуты управления ключами API
+func RegisterRoutes(r chi.Router) {
+	r.Route("/api-keys", func(r chi.Router) {
+		r.Use(requirePolicy)
+		r.Use(middleware.Require(middleware.PermAPIKeysManage))
+		r.Get("/", ListKeys)
+		r.Post("/", CreateKey)
+		r.Post("/{id}/rotate", RotateKey)
+		r.Delete("/{id}", RevokeKey)
+	})
+}
+
+// requirePolicy закрывает управление ключами, пока проверка прав выключена:
+// без неё Require пропускает всех, и анонимный запрос выпустил бы ключ
+// администратора, который заработает после включения AUTH_IDENTITY
+func requirePolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !middleware.Enforced() {
+			render.WriteProblem(w, r, http.StatusServiceUnavailable, "API key management requires AUTH_IDENTITY")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"books-api/internal/db"
+)
+
+// Entry — запись журнала изменений. Before и After содержат только
+// изменившиеся поля; у создания нет Before, у удаления — After.
+type Entry struct {
+	XMLName   xml.Name        `json:"-" xml:"entry"`
+	ID        int64           `json:"id" xml:"id"`
+	At        time.Time       `json:"at" xml:"at"`
+	Actor     string          `json:"actor" xml:"actor"`
+	RequestID string          `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	IP        string          `json:"ip,omitempty" xml:"ip,omitempty"`
+	Action    string          `json:"action" xml:"action"`
+	Entity    string          `json:"entity" xml:"entity"`
+	EntityID  *int            `json:"entity_id,omitempty" xml:"entity_id,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty" xml:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty" xml:"after,omitempty"`
+}
+
+// Source — кто и откуда выполнил изменение
+type Source struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+// ActorResolver определяет, от чьего имени выполняется запрос
+type ActorResolver func(r *http.Request) string
+
+// Anonymous — актор запросов, для которых резолвер никого не нашёл
+const Anonymous = "anonymous"
+
+var resolveActor ActorResolver = HeaderActor("X-Actor")
+
+// SetActorResolver подменяет способ определения актора, например на
+// пользователя из токена
+func SetActorResolver(fn ActorResolver) {
+	resolveActor = fn
+}
+
+// HeaderActor берёт актора из заголовка запроса
+func HeaderActor(header string) ActorResolver {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(header))
+	}
+}
+
+// FromRequest собирает Source из запроса. IP берётся из RemoteAddr,
+// который middleware.RealIP уже заменил на адрес клиента.
+func FromRequest(r *http.Request) Source {
+	src := Source{Actor: resolveActor(r), RequestID: middleware.GetReqID(r.Context()), IP: r.RemoteAddr}
+	if host, _, err := net.SplitHostPort(src.IP); err == nil {
+		src.IP = host
+	}
+	if src.Actor == "" {
+		src.Actor = Anonymous
+	}
+	return src
+}
+
+// System — Source для изменений из подкоманд CLI и фоновых задач
+func System(name string) Source {
+	return Source{Actor: "system:" + name}
+}
+
+// Record пишет запись в журнал в транзакции изменения, чтобы журнал не
+// разошёлся с данными. before и after сериализуются в JSON, одинаковые
+// поля отбрасываются.
+func Record(ctx context.Context, tx db.TxDB, src Source, action, entity string, entityID *int, before, after any) error {
+	b, a, err := Diff(before, after)
+	if err != nil {
+		return err
+	}
+	if src.Actor == "" {
+		src.Actor = Anonymous
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO audit_log (actor, request_id, ip, action, entity, entity_id, before, after)
+		VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7, $8)`,
+		src.Actor, src.RequestID, src.IP, action, entity, entityID, b, a)
+	return err
+}
+
+// Diff возвращает JSON-объекты только с отличающимися полями. nil на входе
+// даёт nil на выходе; значения, которые не сериализуются в объект,
+// сравниваются целиком.
+func Diff(before, after any) (json.RawMessage, json.RawMessage, error) {
+	b, err := marshal(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := marshal(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b == nil || a == nil {
+		return b, a, nil
+	}
+	var bm, am map[string]any
+	if json.Unmarshal(b, &bm) != nil || json.Unmarshal(a, &am) != nil {
+		return b, a, nil
+	}
+	for k, v := range bm {
+		if av, ok := am[k]; ok && reflect.DeepEqual(v, av) {
+			delete(bm, k)
+			delete(am, k)
+		}
+	}
+	if b, err = json.Marshal(bm); err != nil {
+		return nil, nil, err
+	}
+	a, err = json.Marshal(am)
+	return b, a, err
+}
+
+func marshal(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
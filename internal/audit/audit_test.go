@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+)
+
+type mockRows struct{ idx int }
+
+func (r *mockRows) Next() bool { r.idx++; return r.idx == 1 }
+func (r *mockRows) Scan(dest ...any) error {
+	*dest[0].(*int64) = 1
+	*dest[2].(*string) = "alice"
+	*dest[5].(*string) = "update"
+	*dest[6].(*string) = "book"
+	*dest[8].(*[]byte) = []byte(`{"title":"Old"}`)
+	*dest[9].(*[]byte) = []byte(`{"title":"New"}`)
+	return nil
+}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
+
+// mockDB запоминает последний запрос и его аргументы
+type mockDB struct {
+	sql  string
+	args []any
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.sql, m.args = sql, args
+	return &mockRows{}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row { return nil }
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.sql, m.args = sql, args
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *mockDB) Rollback(ctx context.Context) error { return nil }
+func (m *mockDB) Commit(ctx context.Context) error   { return nil }
+
+func TestDiff(t *testing.T) {
+	type book struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+		ISBN  string `json:"isbn,omitempty"`
+	}
+	before, after, err := Diff(book{ID: 1, Title: "Old"}, book{ID: 1, Title: "New", ISBN: "9780306406157"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(before) != `{"title":"Old"}` || string(after) != `{"isbn":"9780306406157","title":"New"}` {
+		t.Fatalf("unexpected diff: %s %s", before, after)
+	}
+	var missing *book
+	before, after, err = Diff(missing, book{ID: 2, Title: "Created"})
+	if err != nil || before != nil || string(after) != `{"id":2,"title":"Created"}` {
+		t.Fatalf("unexpected create diff: %s %s %v", before, after, err)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("X-Actor", "alice")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "req-42"))
+	src := FromRequest(req)
+	if src != (Source{Actor: "alice", RequestID: "req-42", IP: "203.0.113.7"}) {
+		t.Fatalf("unexpected source: %+v", src)
+	}
+
+	SetActorResolver(func(r *http.Request) string { return "" })
+	defer SetActorResolver(HeaderActor("X-Actor"))
+	if src := FromRequest(req); src.Actor != Anonymous {
+		t.Fatalf("expected anonymous actor, got %q", src.Actor)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	database := &mockDB{}
+	id := 7
+	src := Source{Actor: "alice", RequestID: "req-1", IP: "10.0.0.1"}
+	if err := Record(context.Background(), database, src, "delete", "book", &id, map[string]any{"id": 7}, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(database.sql, "INSERT INTO audit_log") || len(database.args) != 8 {
+		t.Fatalf("unexpected insert: %s %v", database.sql, database.args)
+	}
+	if database.args[0] != "alice" || database.args[3] != "delete" || database.args[5] != &id || database.args[7].(json.RawMessage) != nil {
+		t.Fatalf("unexpected args: %v", database.args)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(url.Values{"actor": {"alice"}, "since": {"2024-05-01"}, "limit": {"5000"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Actor != "alice" || !f.Since.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) || f.Limit != maxLimit {
+		t.Fatalf("unexpected filter: %+v", f)
+	}
+	if _, err := ParseFilter(url.Values{"since": {"yesterday"}}); err == nil {
+		t.Fatal("expected error for invalid since")
+	}
+}
+
+func TestListAudit(t *testing.T) {
+	database := &mockDB{}
+	SetAuditDB(database)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?actor=alice&since=2024-05-01T10:00:00Z", nil)
+	w := httptest.NewRecorder()
+	ListAudit(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(database.sql, "WHERE actor = $1 AND at >= $2 ORDER BY id DESC LIMIT $3") {
+		t.Fatalf("unexpected query: %s", database.sql)
+	}
+	var entries []Entry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "alice" || string(entries[0].After) != `{"title":"New"}` {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
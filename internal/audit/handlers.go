@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+var dbi db.TxDB
+
+func SetAuditDB(database db.TxDB) {
+	dbi = database
+}
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Filter — условия выборки журнала
+type Filter struct {
+	Actor    string
+	Since    time.Time
+	Entity   string
+	EntityID *int
+	Limit    int
+}
+
+// ParseFilter читает actor, since (RFC 3339 или YYYY-MM-DD), entity,
+// entity_id и limit
+func ParseFilter(q url.Values) (Filter, error) {
+	f := Filter{Actor: strings.TrimSpace(q.Get("actor")), Entity: q.Get("entity"), Limit: defaultLimit}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				return f, errors.New("since must be RFC 3339 or YYYY-MM-DD")
+			}
+		}
+		f.Since = t
+	}
+	if v := q.Get("entity_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return f, errors.New("entity_id must be an integer")
+		}
+		f.EntityID = &id
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, errors.New("limit must be a positive integer")
+		}
+		f.Limit = min(n, maxLimit)
+	}
+	return f, nil
+}
+
+// List возвращает записи журнала от новых к старым
+func List(ctx context.Context, database db.TxDB, f Filter) ([]Entry, error) {
+	var conds []string
+	var args []any
+	add := func(cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if !f.Since.IsZero() {
+		add("at >= $%d", f.Since)
+	}
+	if f.Entity != "" {
+		add("entity = $%d", f.Entity)
+	}
+	if f.EntityID != nil {
+		add("entity_id = $%d", *f.EntityID)
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultLimit
+	}
+	sql := "SELECT id, at, actor, COALESCE(request_id, ''), COALESCE(ip, ''), action, entity, entity_id, before, after FROM audit_log"
+	if len(conds) > 0 {
+		sql += " WHERE " + strings.Join(conds, " AND ")
+	}
+	args = append(args, f.Limit)
+	sql += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+	rows, err := database.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var before, after []byte
+		if err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.RequestID, &e.IP, &e.Action, &e.Entity, &e.EntityID, &before, &after); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// @Summary Журнал изменений
+// @Tags audit
+// @Produce json
+// @Param actor query string false "Кто менял"
+// @Param since query string false "С момента, RFC 3339 или YYYY-MM-DD"
+// @Param entity query string false "book или collection"
+// @Param entity_id query int false "ID сущности"
+// @Param limit query int false "Не больше записей, по умолчанию 100"
+// @Success 200 {array} Entry
+// @Router /api/v1/audit [get]
+func ListAudit(w http.ResponseWriter, r *http.Request) {
+	f, err := ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	entries, err := List(r.Context(), dbi, f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, entries)
+}
@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты журнала изменений
+func RegisterRoutes(r chi.Router) {
+	// @Summary Журнал изменений
+	// @Tags audit
+	// @Produce json
+	// @Param actor query string false "Кто менял"
+	// @Param since query string false "С момента"
+	// @Success 200 {array} Entry
+	// @Router /audit [get]
+	r.With(middleware.Require(middleware.PermAuditRead)).Get("/audit", ListAudit)
+}
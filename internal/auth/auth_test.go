@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign собирает токен; key — []byte для HS256, *rsa.PrivateKey или *ecdsa.PrivateKey
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func jwksJSON(t *testing.T, rsaKeys map[string]*rsa.PublicKey, ecKeys map[string]*ecdsa.PublicKey) []byte {
+	t.Helper()
+	var keys []map[string]string
+	for kid, k := range rsaKeys {
+		keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": b64.EncodeToString(k.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())})
+	}
+	for kid, k := range ecKeys {
+		x, y := make([]byte, 32), make([]byte, 32)
+		k.X.FillBytes(x)
+		k.Y.FillBytes(y)
+		keys = append(keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+			"x": b64.EncodeToString(x), "y": b64.EncodeToString(y)})
+	}
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+var now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func claims(extra map[string]any) map[string]any {
+	c := map[string]any{"sub": "alice", "iss": "https://idp.example.com", "aud": "books-api",
+		"exp": now.Add(time.Hour).Unix(), "roles": []string{"editor"}, "scope": "books:read books:write"}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerifyHS256(t *testing.T) {
+	secret := []byte("s3cret")
+	v := &Verifier{Secret: secret, Issuer: "https://idp.example.com", Audience: "books-api", Now: func() time.Time { return now }}
+	c, err := v.Verify(context.Background(), sign(t, HS256, "", secret, claims(nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Subject != "alice" {
+		t.Fatalf("unexpected claims: %+v", c)
+	}
+	if _, err := v.Verify(context.Background(), sign(t, HS256, "", []byte("other"), claims(nil))); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	secret := []byte("s3cret")
+	v := &Verifier{Secret: secret, Issuer: "https://idp.example.com", Audience: "books-api", Leeway: time.Minute, Now: func() time.Time { return now }}
+	cases := []struct {
+		name  string
+		extra map[string]any
+		want  error
+	}{
+		{"expired", map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}, ErrExpired},
+		{"within leeway", map[string]any{"exp": now.Add(-30 * time.Second).Unix()}, nil},
+		{"no exp", map[string]any{"exp": nil}, ErrExpired},
+		{"not yet valid", map[string]any{"nbf": now.Add(5 * time.Minute).Unix()}, ErrNotYetValid},
+		{"audience array", map[string]any{"aud": []string{"other", "books-api"}}, nil},
+		{"wrong audience", map[string]any{"aud": "other"}, ErrInvalidAudience},
+		{"wrong issuer", map[string]any{"iss": "https://evil.example.com"}, ErrInvalidIssuer},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := claims(tc.extra)
+			if c["exp"] == nil {
+				delete(c, "exp")
+			}
+			_, err := v.Verify(context.Background(), sign(t, HS256, "", secret, c))
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsUnconfiguredAlg(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// без Secret HS256 не принимается, даже если подписать им что-то известное
+	v := &Verifier{Keys: staticKeys{"k1": &rsaKey.PublicKey}, Now: func() time.Time { return now }}
+	if _, err := v.Verify(context.Background(), sign(t, HS256, "k1", []byte("guess"), claims(nil))); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Fatalf("expected unsupported alg, got %v", err)
+	}
+	none := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"x"}`)) + "."
+	if _, err := v.Verify(context.Background(), none); !errors.Is(err, ErrUnsupportedAlg) {
+		t.Fatalf("expected unsupported alg for none, got %v", err)
+	}
+	if _, err := v.Verify(context.Background(), "not.a-token"); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected malformed, got %v", err)
+	}
+}
+
+type staticKeys map[string]crypto.PublicKey
+
+func (s staticKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if k, ok := s[kid]; ok {
+		return k, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func TestVerifyJWKSFile(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data := jwksJSON(t, map[string]*rsa.PublicKey{"rsa-1": &rsaKey.PublicKey}, map[string]*ecdsa.PublicKey{"ec-1": &ecKey.PublicKey})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v := &Verifier{Keys: NewJWKSFile(path, time.Hour), Now: func() time.Time { return now }}
+	for _, token := range []string{
+		sign(t, RS256, "rsa-1", rsaKey, claims(nil)),
+		sign(t, ES256, "ec-1", ecKey, claims(nil)),
+	} {
+		if _, err := v.Verify(context.Background(), token); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// ключ RSA под видом ES256 не подходит
+	if _, err := v.Verify(context.Background(), sign(t, ES256, "rsa-1", ecKey, claims(nil))); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+}
+
+func TestJWKSURLCaching(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hits atomic.Int32
+	keys := map[string]*ecdsa.PublicKey{"ec-1": &ecKey.PublicKey}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write(jwksJSON(t, nil, keys))
+	}))
+	defer srv.Close()
+
+	clock := now
+	jwks := NewJWKSURL(srv.URL, time.Hour, srv.Client())
+	jwks.now = func() time.Time { return clock }
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := jwks.Key(ctx, "ec-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected keys to be cached, got %d fetches", hits.Load())
+	}
+
+	// незнакомый kid сразу после загрузки не вызывает повторного запроса
+	keys["ec-2"] = &rotated.PublicKey
+	if _, err := jwks.Key(ctx, "ec-2"); !errors.Is(err, ErrUnknownKey) || hits.Load() != 1 {
+		t.Fatalf("expected unknown key without refetch, got %v after %d fetches", err, hits.Load())
+	}
+	clock = clock.Add(2 * time.Minute)
+	if _, err := jwks.Key(ctx, "ec-2"); err != nil || hits.Load() != 2 {
+		t.Fatalf("expected refetch for rotated key, got %v after %d fetches", err, hits.Load())
+	}
+	clock = clock.Add(2 * time.Hour)
+	if _, err := jwks.Key(ctx, "ec-1"); err != nil || hits.Load() != 3 {
+		t.Fatalf("expected refetch after ttl, got %v after %d fetches", err, hits.Load())
+	}
+}
+
+func TestAuthenticateMiddleware(t *testing.T) {
+	secret := []byte("s3cret")
+	v := &Verifier{Secret: secret, Now: func() time.Time { return now }}
+	var got *Principal
+	handler := Authenticate(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PrincipalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, HS256, "", secret, claims(nil)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || got == nil || got.Subject != "alice" || !got.HasRole("Editor") || len(got.Scopes) != 2 {
+		t.Fatalf("unexpected result: %d %+v", w.Code, got)
+	}
+
+	got = nil
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNoContent || got != nil {
+		t.Fatalf("anonymous request must pass without principal: %d %+v", w.Code, got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, HS256, "", []byte("other"), claims(nil)))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+		t.Fatalf("expected 401 with challenge, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	handler := RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req = req.WithContext(WithPrincipal(req.Context(), &Principal{Subject: "alice"}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+}
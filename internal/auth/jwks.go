@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+const (
+	// DefaultJWKSTTL — как долго ключи считаются свежими
+	DefaultJWKSTTL = time.Hour
+	// minJWKSRefresh ограничивает перечитывание при незнакомом kid, чтобы
+	// поток токенов с выдуманным kid не превратился в поток запросов к IdP
+	minJWKSRefresh = time.Minute
+)
+
+// JWKS — набор открытых ключей из файла или по URL с кэшированием.
+// Ключи перечитываются по истечении TTL и при встрече незнакомого kid.
+type JWKS struct {
+	fetch func(ctx context.Context) ([]byte, error)
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewJWKSFile читает ключи из локального файла
+func NewJWKSFile(path string, ttl time.Duration) *JWKS {
+	return newJWKS(func(context.Context) ([]byte, error) { return os.ReadFile(path) }, ttl)
+}
+
+// NewJWKSURL загружает ключи по URL, например /.well-known/jwks.json у IdP
+func NewJWKSURL(url string, ttl time.Duration, client *http.Client) *JWKS {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("jwks %s: status %d", url, resp.StatusCode)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}, ttl)
+}
+
+func newJWKS(fetch func(ctx context.Context) ([]byte, error), ttl time.Duration) *JWKS {
+	if ttl <= 0 {
+		ttl = DefaultJWKSTTL
+	}
+	return &JWKS{fetch: fetch, ttl: ttl, now: time.Now}
+}
+
+// Key отдаёт ключ по kid. Без kid подходит единственный ключ набора.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := j.now()
+	if j.keys == nil || now.Sub(j.fetched) >= j.ttl {
+		if err := j.refresh(ctx, now); err != nil && j.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	if now.Sub(j.fetched) >= minJWKSRefresh {
+		if err := j.refresh(ctx, now); err != nil {
+			return nil, err
+		}
+		if key, ok := j.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// refresh перечитывает ключи; при ошибке остаются прежние, если они были
+func (j *JWKS) refresh(ctx context.Context, now time.Time) error {
+	data, err := j.fetch(ctx)
+	if err == nil {
+		var keys map[string]crypto.PublicKey
+		if keys, err = ParseJWKS(data); err == nil {
+			j.keys, j.fetched = keys, now
+			return nil
+		}
+	}
+	if j.keys != nil {
+		log.Printf("ошибка обновления JWKS, используются прежние ключи: %v", err)
+		j.fetched = now
+	}
+	return err
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS разбирает JWK Set. Ключи RSA и EC P-256 с use=sig (или без use)
+// попадают в результат, остальные пропускаются.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsa()
+		case "EC":
+			key, err = k.ecdsa()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) rsa() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func (k jwk) ecdsa() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid P-256 point")
+	}
+	// несжатая точка 0x04||X||Y; ecdh проверяет, что она лежит на кривой
+	if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Поддерживаемые алгоритмы подписи
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("token is expired")
+	ErrNotYetValid      = errors.New("token is not valid yet")
+	ErrInvalidAudience  = errors.New("invalid audience")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+)
+
+// Audience — claim aud, который бывает и строкой, и массивом
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Claims — стандартные claims и те, из которых собирается Principal
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	IssuedAt  *int64   `json:"iat"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email"`
+	Roles     []string `json:"roles"`
+	Scope     string   `json:"scope"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// KeySource отдаёт открытый ключ для RS256/ES256 по kid
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// DefaultLeeway — запас на расхождение часов с IdP
+const DefaultLeeway = 30 * time.Second
+
+// Verifier проверяет подпись и claims токена. HS256 принимается, только если
+// задан Secret, RS256/ES256 — только если задан Keys: так токен, подписанный
+// открытым ключом как HMAC-секретом, не пройдёт.
+type Verifier struct {
+	Secret   []byte
+	Keys     KeySource
+	Issuer   string
+	Audience string
+	// Leeway — допустимое расхождение часов при проверке exp и nbf
+	Leeway time.Duration
+	Now    func() time.Time
+}
+
+// Verify разбирает и проверяет токен
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verifySignature(ctx, h, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	return &c, v.validate(&c)
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
+
+func (v *Verifier) verifySignature(ctx context.Context, h header, signed string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch h.Alg {
+	case HS256:
+		if len(v.Secret) == 0 {
+			return ErrUnsupportedAlg
+		}
+		mac := hmac.New(sha256.New, v.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case RS256, ES256:
+		if v.Keys == nil {
+			return ErrUnsupportedAlg
+		}
+		key, err := v.Keys.Key(ctx, h.Kid)
+		if err != nil {
+			return err
+		}
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if h.Alg != RS256 || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+				return ErrInvalidSignature
+			}
+			return nil
+		case *ecdsa.PublicKey:
+			// подпись JWS ES256 — r||s по 32 байта, а не ASN.1
+			if h.Alg != ES256 || len(sig) != 64 {
+				return ErrInvalidSignature
+			}
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if !ecdsa.Verify(k, digest[:], r, s) {
+				return ErrInvalidSignature
+			}
+			return nil
+		}
+		return ErrInvalidSignature
+	}
+	return ErrUnsupportedAlg
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if c.ExpiresAt == nil || now.After(time.Unix(*c.ExpiresAt, 0).Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if c.NotBefore != nil && now.Add(v.Leeway).Before(time.Unix(*c.NotBefore, 0)) {
+		return ErrNotYetValid
+	}
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
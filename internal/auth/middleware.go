@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Authenticate проверяет Bearer-токен из Authorization и кладёт Principal в
+// контекст. Запрос без токена проходит анонимно — решать, пускать ли его,
+// должны проверки прав; неверный токен отклоняется с 401.
+func Authenticate(v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, err := v.Verify(r.Context(), token)
+			if err != nil {
+				Unauthorized(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principalFromClaims(claims))))
+		})
+	}
+}
+
+// RequireAuth отклоняет с 401 запросы без Principal
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := PrincipalFrom(r.Context()); !ok {
+			Unauthorized(w, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Unauthorized отвечает 401 с WWW-Authenticate по RFC 6750
+func Unauthorized(w http.ResponseWriter, err error) {
+	challenge := "Bearer"
+	msg := "authentication required"
+	if err != nil {
+		msg = err.Error()
+		challenge += ` error="invalid_token", error_description="` + strings.ReplaceAll(msg, `"`, `'`) + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Principal — пользователь или сервис, от имени которого выполняется запрос
+type Principal struct {
+	Subject string
+	Issuer  string
+	Name    string
+	Email   string
+	Roles   []string
+	Scopes  []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal кладёт principal в контекст
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom достаёт principal, положенный middleware
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// HasRole проверяет роль без учёта регистра
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func principalFromClaims(c *Claims) *Principal {
+	p := &Principal{Subject: c.Subject, Issuer: c.Issuer, Name: c.Name, Email: c.Email, Roles: c.Roles}
+	if c.Scope != "" {
+		p.Scopes = strings.Fields(c.Scope)
+	}
+	return p
+}
@@ -0,0 +1,301 @@
+package authors
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+
+func SetAuthorDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+type Author struct {
+	XMLName xml.Name       `json:"-" xml:"author"`
+	ID      int            `json:"id" xml:"id"`
+	Name    string         `json:"name" xml:"name"`
+	Bio     string         `json:"bio" xml:"bio"`
+	Books   []AuthoredBook `json:"books,omitempty" xml:"books>book,omitempty"`
+}
+
+// AuthoredBook — книга на странице автора
+type AuthoredBook struct {
+	ID    int    `json:"id" xml:"id"`
+	Title string `json:"title" xml:"title"`
+	Role  string `json:"role" xml:"role"`
+}
+
+func (a Author) CSVHeader() []string {
+	return []string{"id", "name", "bio"}
+}
+
+func (a Author) CSVRecord() []string {
+	return []string{strconv.Itoa(a.ID), a.Name, a.Bio}
+}
+
+func sendEvent(ctx context.Context, tx db.TxDB, msg string) error {
+	if producer == nil {
+		return nil
+	}
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// @Summary Получить список авторов
+// @Tags authors
+// @Produce json
+// @Param name query string false "Имя (подстрока)"
+// @Success 200 {array} Author
+// @Router /api/v1/authors [get]
+func ListAuthors(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	rows, err := dbi.Query(r.Context(), "SELECT id, name, COALESCE(bio, '') FROM authors WHERE $1 = '' OR name ILIKE '%' || $1 || '%' ORDER BY name", name)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	authors := []Author{}
+	for rows.Next() {
+		var a Author
+		if err := rows.Scan(&a.ID, &a.Name, &a.Bio); err != nil {
+			continue
+		}
+		authors = append(authors, a)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, authors)
+}
+
+// @Summary Создать автора
+// @Tags authors
+// @Accept json
+// @Produce json
+// @Param author body Author true "Автор"
+// @Success 201 {object} Author
+// @Failure 409 {string} string "автор с таким именем уже есть"
+// @Router /api/v1/authors [post]
+func CreateAuthor(w http.ResponseWriter, r *http.Request) {
+	var a Author
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if a.Name = strings.TrimSpace(a.Name); a.Name == "" {
+		http.Error(w, "name is required", 400)
+		return
+	}
+	ctx := r.Context()
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	row := tx.QueryRow(ctx, "INSERT INTO authors (name, bio) VALUES ($1, $2) RETURNING id", a.Name, a.Bio)
+	if err := row.Scan(&a.ID); err != nil {
+		if pgErrorCode(err) == "23505" {
+			http.Error(w, "author with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := sendEvent(ctx, tx, "created author: "+a.Name); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, a)
+}
+
+// @Summary Получить автора с его книгами
+// @Tags authors
+// @Produce json
+// @Param id path int true "ID автора"
+// @Success 200 {object} Author
+// @Failure 404 {string} string "не найдено"
+// @Router /api/v1/authors/{id} [get]
+func GetAuthor(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var a Author
+	row := dbi.QueryRow(r.Context(), "SELECT id, name, COALESCE(bio, '') FROM authors WHERE id=$1", id)
+	if err := row.Scan(&a.ID, &a.Name, &a.Bio); err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	rows, err := dbi.Query(r.Context(), "SELECT b.id, b.title, ba.role FROM book_authors ba JOIN books b ON b.id = ba.book_id WHERE ba.author_id=$1 AND b.deleted_at IS NULL ORDER BY b.title, b.id", id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b AuthoredBook
+		if err := rows.Scan(&b.ID, &b.Title, &b.Role); err != nil {
+			continue
+		}
+		a.Books = append(a.Books, b)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, a)
+}
+
+// @Summary Обновить автора
+// @Tags authors
+// @Accept json
+// @Produce json
+// @Param id path int true "ID автора"
+// @Param author body Author true "Автор"
+// @Description Строка author у книг автора пересобирается, каждой книге
+// @Description пишутся ревизия и запись журнала
+// @Success 200 {object} Author
+// @Router /api/v1/authors/{id} [put]
+func UpdateAuthor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	authorID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	var a Author
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if a.Name = strings.TrimSpace(a.Name); a.Name == "" {
+		http.Error(w, "name is required", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	// состояние книг до переименования — для ревизий и журнала
+	before, err := books.LockAuthorBooks(ctx, tx, authorID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	row := tx.QueryRow(ctx, "UPDATE authors SET name=$1, bio=$2 WHERE id=$3 RETURNING id", a.Name, a.Bio, authorID)
+	if err := row.Scan(&a.ID); err != nil {
+		if pgErrorCode(err) == "23505" {
+			http.Error(w, "author with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := books.RecordAuthorRename(ctx, tx, audit.FromRequest(r), before); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := sendEvent(ctx, tx, "updated author: "+id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, a)
+}
+
+// @Summary Удалить автора
+// @Tags authors
+// @Param id path int true "ID автора"
+// @Success 204 {string} string "Автор удалён"
+// @Failure 409 {string} string "у автора есть книги"
+// @Router /api/v1/authors/{id} [delete]
+func DeleteAuthor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	row := tx.QueryRow(ctx, "DELETE FROM authors WHERE id=$1 RETURNING id", id)
+	var deletedID int
+	if err := row.Scan(&deletedID); err != nil {
+		if pgErrorCode(err) == "23503" {
+			http.Error(w, "author is linked to books", http.StatusConflict)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := sendEvent(ctx, tx, "deleted author: "+id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,194 @@
+package authors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+)
+
+type mockRows struct{ idx int }
+
+func (r *mockRows) Next() bool { r.idx++; return r.idx == 1 }
+func (r *mockRows) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	*dest[1].(*string) = "Лев Толстой"
+	*dest[2].(*string) = "author"
+	return nil
+}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
+
+type mockRow struct{ err error }
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int) = 1
+	if len(dest) > 1 {
+		*dest[1].(*string) = "Лев Толстой"
+	}
+	return nil
+}
+
+type mockDB struct {
+	rowErr error
+	sql    []string
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &mockRows{}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.sql = append(m.sql, sql)
+	return &mockRow{err: m.rowErr}
+}
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.sql = append(m.sql, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+
+func (m *mockDB) ran(prefix string) bool {
+	for _, sql := range m.sql {
+		if strings.HasPrefix(strings.TrimSpace(sql), prefix) {
+			return true
+		}
+	}
+	return false
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *mockDB) Rollback(ctx context.Context) error { return nil }
+func (m *mockDB) Commit(ctx context.Context) error   { return nil }
+
+type mockProducer struct{}
+
+func (m *mockProducer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
+	return nil
+}
+func (m *mockProducer) Close() error { return nil }
+
+func withID(req *http.Request, id string) *http.Request {
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+}
+
+func TestListAuthors(t *testing.T) {
+	SetAuthorDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/authors?name=толст", nil)
+	w := httptest.NewRecorder()
+	ListAuthors(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var authors []Author
+	if err := json.NewDecoder(w.Body).Decode(&authors); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(authors) != 1 || authors[0].Name != "Лев Толстой" {
+		t.Fatalf("unexpected authors: %+v", authors)
+	}
+}
+
+func TestCreateAuthor(t *testing.T) {
+	SetAuthorDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	body, _ := json.Marshal(Author{Name: "Лев Толстой"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/authors", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	CreateAuthor(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+}
+
+func TestCreateAuthorValidation(t *testing.T) {
+	SetAuthorDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/authors", bytes.NewReader([]byte(`{"name":"  "}`)))
+	w := httptest.NewRecorder()
+	CreateAuthor(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestCreateAuthorDuplicate(t *testing.T) {
+	SetAuthorDB(&mockDB{rowErr: &pgconn.PgError{Code: "23505"}})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/authors", bytes.NewReader([]byte(`{"name":"Лев Толстой"}`)))
+	w := httptest.NewRecorder()
+	CreateAuthor(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+}
+
+func TestGetAuthor(t *testing.T) {
+	SetAuthorDB(&mockDB{})
+	req := withID(httptest.NewRequest(http.MethodGet, "/api/v1/authors/1", nil), "1")
+	w := httptest.NewRecorder()
+	GetAuthor(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var a Author
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if a.ID != 1 || len(a.Books) != 1 || a.Books[0].Role != "author" {
+		t.Fatalf("unexpected author: %+v", a)
+	}
+}
+
+func TestUpdateAuthor(t *testing.T) {
+	database := &mockDB{}
+	SetAuthorDB(database)
+	SetProducer(&mockProducer{})
+	body, _ := json.Marshal(Author{Name: "Л. Н. Толстой"})
+	req := withID(httptest.NewRequest(http.MethodPut, "/api/v1/authors/1", bytes.NewReader(body)), "1")
+	w := httptest.NewRecorder()
+	UpdateAuthor(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	// книга автора получает новую строку author, ревизию и запись журнала
+	for _, prefix := range []string{"UPDATE books b SET author", "INSERT INTO book_revisions", "INSERT INTO audit_log"} {
+		if !database.ran(prefix) {
+			t.Errorf("%s was not executed: %v", prefix, database.sql)
+		}
+	}
+}
+
+func TestDeleteAuthor(t *testing.T) {
+	SetAuthorDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	req := withID(httptest.NewRequest(http.MethodDelete, "/api/v1/authors/1", nil), "1")
+	w := httptest.NewRecorder()
+	DeleteAuthor(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+}
+
+func TestDeleteAuthorWithBooks(t *testing.T) {
+	SetAuthorDB(&mockDB{rowErr: &pgconn.PgError{Code: "23503"}})
+	req := withID(httptest.NewRequest(http.MethodDelete, "/api/v1/authors/1", nil), "1")
+	w := httptest.NewRecorder()
+	DeleteAuthor(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+}
@@ -0,0 +1,18 @@
+package authors
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты для авторов
+func RegisterRoutes(r chi.Router) {
+	r.Route("/authors", func(r chi.Router) {
+		r.With(middleware.Require(middleware.PermRead)).Get("/", ListAuthors)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/", CreateAuthor)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}", GetAuthor)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Put("/{id}", UpdateAuthor)
+		r.With(middleware.Require(middleware.PermBooksDelete)).Delete("/{id}", DeleteAuthor)
+	})
+}
@@ -0,0 +1,237 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+)
+
+// Роли участника в создании книги
+const (
+	RoleAuthor      = "author"
+	RoleEditor      = "editor"
+	RoleTranslator  = "translator"
+	RoleIllustrator = "illustrator"
+)
+
+var errUnknownAuthor = errors.New("unknown author")
+
+var validRoles = map[string]bool{RoleAuthor: true, RoleEditor: true, RoleTranslator: true, RoleIllustrator: true}
+
+// BookAuthor — автор книги с ролью и порядком в списке
+type BookAuthor struct {
+	ID       int    `json:"id" xml:"id"`
+	Name     string `json:"name" xml:"name"`
+	Role     string `json:"role" xml:"role"`
+	Position int    `json:"position" xml:"position"`
+}
+
+// authorsColumn собирает авторов книги в JSON-массив прямо в SELECT,
+// чтобы список, карточка и выгрузка получали их без лишних запросов
+const authorsColumn = `COALESCE((SELECT json_agg(json_build_object('id', a.id, 'name', a.name, 'role', ba.role, 'position', ba.position) ORDER BY ba.position, a.id)
+	FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id), '[]'::json)`
+
+// legacyAuthorSeparators — разделители имён в старом поле author. Запятая,
+// "and" и "и" встречаются внутри одного имени ("Tolkien, J.R.R.", "Ильф и
+// Петров" как соавторский псевдоним), поэтому делим только по ; и &.
+var legacyAuthorSeparators = regexp.MustCompile(`\s*[;&]\s*`)
+
+// authorNamesSeparator склеивает имена в строку author так, чтобы
+// splitAuthorNames разобрал её обратно
+const authorNamesSeparator = "; "
+
+// splitAuthorNames разбивает строку "A; B & C" на имена
+func splitAuthorNames(s string) []string {
+	var names []string
+	for _, name := range legacyAuthorSeparators.Split(s, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func decodeAuthors(raw []byte, b *Book) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, &b.Authors)
+}
+
+// normalizeAuthors согласует authors и устаревшее поле author: если
+// передан массив, строка author собирается из него, иначе массив
+// получается разбиением строки
+func normalizeAuthors(b *Book) error {
+	if len(b.Authors) == 0 {
+		for i, name := range splitAuthorNames(b.Author) {
+			b.Authors = append(b.Authors, BookAuthor{Name: name, Role: RoleAuthor, Position: i})
+		}
+		return nil
+	}
+	var names, all []string
+	for i := range b.Authors {
+		a := &b.Authors[i]
+		a.Name = strings.TrimSpace(a.Name)
+		if a.ID == 0 && a.Name == "" {
+			return errors.New("author must have id or name")
+		}
+		if a.Role == "" {
+			a.Role = RoleAuthor
+		}
+		if !validRoles[a.Role] {
+			return fmt.Errorf("unknown author role %q", a.Role)
+		}
+		a.Position = i
+		if a.Role == RoleAuthor {
+			names = append(names, a.Name)
+		}
+		all = append(all, a.Name)
+	}
+	if len(names) == 0 {
+		names = all
+	}
+	b.Author = strings.Join(names, authorNamesSeparator)
+	return nil
+}
+
+// saveBookAuthors заменяет авторов книги. Авторы без id создаются по имени
+// (или находятся, если такое имя уже есть), у авторов с id подтягивается имя.
+func saveBookAuthors(ctx context.Context, tx db.TxDB, b *Book) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM book_authors WHERE book_id=$1", b.ID); err != nil {
+		return err
+	}
+	for i := range b.Authors {
+		a := &b.Authors[i]
+		if a.ID == 0 {
+			row := tx.QueryRow(ctx, "INSERT INTO authors (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name=EXCLUDED.name RETURNING id", a.Name)
+			if err := row.Scan(&a.ID); err != nil {
+				return err
+			}
+		} else {
+			var name string
+			if err := tx.QueryRow(ctx, "SELECT name FROM authors WHERE id=$1", a.ID).Scan(&name); err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return fmt.Errorf("%w: author %d not found", errUnknownAuthor, a.ID)
+				}
+				return err
+			}
+			if name != "" {
+				a.Name = name
+			}
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO book_authors (book_id, author_id, role, position) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+			b.ID, a.ID, a.Role, a.Position); err != nil {
+			return err
+		}
+	}
+	if err := normalizeAuthors(b); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE books SET author=$1 WHERE id=$2", b.Author, b.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// linkLegacyAuthorsSQL создаёт записи book_authors для книг из $1, у которых
+// их ещё нет, разбивая строку author (то же, что делает миграция 005)
+const linkLegacyAuthorsSQL = `WITH parts AS (
+    SELECT b.id AS book_id, btrim(p.name) AS name, p.ord
+    FROM books b
+    CROSS JOIN LATERAL regexp_split_to_table(b.author, '\s*[;&]\s*') WITH ORDINALITY AS p(name, ord)
+    WHERE b.id = ANY($1)
+      AND btrim(p.name) <> ''
+      AND NOT EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = b.id)
+), ins AS (
+    INSERT INTO authors (name)
+    SELECT DISTINCT name FROM parts
+    ON CONFLICT (name) DO NOTHING
+    RETURNING id, name
+)
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT parts.book_id, COALESCE(ins.id, a.id), 'author', parts.ord - 1
+FROM parts
+LEFT JOIN ins ON ins.name = parts.name
+LEFT JOIN authors a ON a.name = parts.name
+ON CONFLICT DO NOTHING`
+
+// LinkLegacyAuthors привязывает авторов к книгам ids, вставленным в обход
+// CreateBook (массовый импорт, seed). Чужие книги без авторов не трогает.
+func LinkLegacyAuthors(ctx context.Context, tx db.TxDB, ids []int) error {
+	_, err := tx.Exec(ctx, linkLegacyAuthorsSQL, ids)
+	return err
+}
+
+// refreshAuthorNamesSQL пересобирает денормализованную строку author у книг
+// из их book_authors: сначала по авторам, а если их нет — по всем участникам
+const refreshAuthorNamesSQL = `UPDATE books b SET author = COALESCE(
+	(SELECT string_agg(a.name, '; ' ORDER BY ba.position) FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = b.id AND ba.role = 'author'),
+	(SELECT string_agg(a.name, '; ' ORDER BY ba.position) FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = b.id),
+	b.author)
+WHERE b.id = ANY($1)`
+
+// LockAuthorBooks блокирует книги автора до конца транзакции и возвращает
+// их состояние до переименования автора — before для RecordAuthorRename
+func LockAuthorBooks(ctx context.Context, tx db.TxDB, authorID int) ([]Book, error) {
+	rows, err := tx.Query(ctx, "SELECT "+bookColumns+" FROM books WHERE id IN (SELECT book_id FROM book_authors WHERE author_id=$1) ORDER BY id FOR UPDATE", authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Book
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// RecordAuthorRename пересобирает строку author у книг before после
+// переименования их автора и, как при правке книги, пишет каждой ревизию,
+// запись журнала и событие updated book
+func RecordAuthorRename(ctx context.Context, tx db.TxDB, src audit.Source, before []Book) error {
+	if len(before) == 0 {
+		return nil
+	}
+	ids := make([]int, len(before))
+	for i, b := range before {
+		ids[i] = b.ID
+	}
+	if _, err := tx.Exec(ctx, refreshAuthorNamesSQL, ids); err != nil {
+		return err
+	}
+	for _, b := range before {
+		after, err := scanBook(tx.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id=$1", b.ID))
+		if err != nil {
+			return err
+		}
+		if _, err := saveRevision(ctx, tx, src, "update", b.ID); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, src, "update", auditEntity, &after.ID, b, after); err != nil {
+			return err
+		}
+		if producer != nil {
+			if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("updated book: " + strconv.Itoa(b.ID))}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
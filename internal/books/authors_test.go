@@ -0,0 +1,50 @@
+package books
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitAuthorNames(t *testing.T) {
+	cases := map[string][]string{
+		"Лев Толстой":                     {"Лев Толстой"},
+		"Neil Gaiman & Terry Pratchett":   {"Neil Gaiman", "Terry Pratchett"},
+		"A; B & C":                        {"A", "B", "C"},
+		"Tolkien, J.R.R.":                 {"Tolkien, J.R.R."},
+		"Neil Gaiman and Terry Pratchett": {"Neil Gaiman and Terry Pratchett"},
+		"Ильф и Петров":                   {"Ильф и Петров"},
+		" ; ":                             nil,
+	}
+	for in, want := range cases {
+		if got := splitAuthorNames(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitAuthorNames(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizeAuthorsFromLegacyString(t *testing.T) {
+	b := Book{Author: "Neil Gaiman; Terry Pratchett"}
+	if err := normalizeAuthors(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(b.Authors) != 2 || b.Authors[1].Name != "Terry Pratchett" || b.Authors[1].Position != 1 || b.Authors[1].Role != RoleAuthor {
+		t.Fatalf("unexpected authors: %+v", b.Authors)
+	}
+}
+
+func TestNormalizeAuthorsFromArray(t *testing.T) {
+	b := Book{Author: "ignored", Authors: []BookAuthor{
+		{Name: "Нора Галь", Role: RoleTranslator},
+		{Name: "Антуан де Сент-Экзюпери"},
+	}}
+	if err := normalizeAuthors(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Author != "Антуан де Сент-Экзюпери" {
+		t.Fatalf("legacy author string should list only authors, got %q", b.Author)
+	}
+	b.Authors = []BookAuthor{{Name: "X", Role: "ghostwriter"}}
+	if err := normalizeAuthors(&b); err == nil {
+		t.Fatal("expected error for unknown role")
+	}
+}
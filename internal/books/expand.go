@@ -0,0 +1,67 @@
+package books
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"books-api/internal/db"
+)
+
+// Связи, которые можно встроить в ответ через ?expand=
+const (
+	ExpandAuthors = "authors"
+	ExpandGenres  = "genres"
+)
+
+// Expand — набор связей, которые нужно встроить в ответ
+type Expand map[string]bool
+
+// ParseExpand разбирает список через запятую; неизвестное имя — ошибка
+func ParseExpand(v string, allowed ...string) (Expand, error) {
+	e := Expand{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, a := range allowed {
+			known = known || a == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown expand %q, supported: %s", name, strings.Join(allowed, ", "))
+		}
+		e[name] = true
+	}
+	return e, nil
+}
+
+// expandFromQuery читает ?expand= для ответов с книгами. Без параметра
+// авторы и жанры встраиваются, как и раньше; ?expand= с пустым значением
+// отдаёт книги без связей и избавляет запрос от подзапросов.
+func expandFromQuery(q url.Values) (Expand, error) {
+	if !q.Has("expand") {
+		return Expand{ExpandAuthors: true, ExpandGenres: true}, nil
+	}
+	return ParseExpand(q.Get("expand"), ExpandAuthors, ExpandGenres)
+}
+
+// Columns — колонки книги для ScanBook; связи, которых нет в e, приходят
+// как NULL. Колонки не квалифицированы и ссылаются на таблицу books, так
+// что её можно JOIN-ить без алиаса.
+func (e Expand) Columns() string {
+	authors, genres := "NULL::json", "NULL::json"
+	if e[ExpandAuthors] {
+		authors = authorsColumn
+	}
+	if e[ExpandGenres] {
+		genres = genresColumn
+	}
+	return "id, title, author, published_at::text, isbn, language, deleted_at, rating_avg::float8, rating_count, " + authors + ", " + genres
+}
+
+// ScanBook читает строку, выбранную по Expand.Columns
+func ScanBook(row db.Row) (Book, error) {
+	return scanBook(row)
+}
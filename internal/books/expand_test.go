@@ -0,0 +1,71 @@
+package books
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/db"
+)
+
+// queryDB запоминает последний SELECT
+type queryDB struct {
+	mockDB
+	sql string
+}
+
+func (m *queryDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.sql = sql
+	return m.mockDB.Query(ctx, sql, args...)
+}
+func (m *queryDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.sql = sql
+	return m.mockDB.QueryRow(ctx, sql, args...)
+}
+
+func TestParseExpand(t *testing.T) {
+	e, err := ParseExpand(" Authors, ,genres", ExpandAuthors, ExpandGenres)
+	if err != nil || !e[ExpandAuthors] || !e[ExpandGenres] || len(e) != 2 {
+		t.Fatalf("unexpected expand %v %v", e, err)
+	}
+	if _, err := ParseExpand("reviews", ExpandAuthors, ExpandGenres); err == nil {
+		t.Fatal("expected error for unknown expand")
+	}
+}
+
+func TestExpandColumns(t *testing.T) {
+	if cols := (Expand{ExpandAuthors: true, ExpandGenres: true}).Columns(); cols != bookColumns {
+		t.Fatalf("full expand must match bookColumns: %q", cols)
+	}
+	cols := Expand{ExpandGenres: true}.Columns()
+	if strings.Contains(cols, "book_authors") || !strings.Contains(cols, "book_genres") {
+		t.Fatalf("unexpected columns %q", cols)
+	}
+}
+
+func TestGetBookExpand(t *testing.T) {
+	database := &queryDB{}
+	SetBookDB(database)
+	get := func(target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		ctx := chi.NewRouteContext()
+		ctx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+		w := httptest.NewRecorder()
+		GetBook(w, req)
+		return w.Code
+	}
+	if code := get("/api/v1/books/1"); code != http.StatusOK || !strings.Contains(database.sql, "book_authors") {
+		t.Fatalf("authors must be embedded by default: %d %q", code, database.sql)
+	}
+	if code := get("/api/v1/books/1?expand="); code != http.StatusOK || strings.Contains(database.sql, "book_authors") || strings.Contains(database.sql, "book_genres") {
+		t.Fatalf("empty expand must skip relations: %d %q", code, database.sql)
+	}
+	if code := get("/api/v1/books/1?expand=reviews"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", code)
+	}
+}
@@ -0,0 +1,104 @@
+package books
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+// CSVHeader — колонки экспорта; их же понимает импорт, так что выгрузку
+// можно загрузить обратно без потерь
+func (b Book) CSVHeader() []string {
+	return []string{"id", "title", "author", "published_at", "isbn", "language"}
+}
+
+func (b Book) CSVRecord() []string {
+	return []string{strconv.Itoa(b.ID), b.Title, b.Author, deref(b.PublishedAt), deref(b.ISBN), deref(b.Language)}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Export пишет книги в w прямо из курсора БД, не собирая их в слайс
+func Export(ctx context.Context, database db.TxDB, w io.Writer, format string, f BookFilter) (int, error) {
+	stream, err := render.NewStream(w, format)
+	if err != nil {
+		return 0, err
+	}
+	where, args := f.where(nil)
+	rows, err := database.Query(ctx, "SELECT "+bookColumns+" FROM books"+where+" ORDER BY id", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := stream.Write(b); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, stream.Close()
+}
+
+// @Summary Выгрузка каталога книг
+// @Tags books
+// @Produce json,plain
+// @Param format query string false "ndjson, csv или json; по умолчанию по Accept, иначе ndjson"
+// @Param author query string false "Автор (подстрока)"
+// @Param title query string false "Название (подстрока)"
+// @Param published_from query string false "Дата издания от, YYYY-MM-DD"
+// @Param published_to query string false "Дата издания до, YYYY-MM-DD"
+// @Success 200 {array} Book
+// @Router /api/v1/books/export [get]
+func ExportBooks(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		// без ?format смотрим на Accept, по умолчанию ndjson
+		var ok bool
+		offers := []string{render.FormatNDJSON, render.FormatCSV, render.FormatJSON}
+		if format, ok = render.Negotiate(r, offers...); !ok {
+			render.NotAcceptable(w, offers...)
+			return
+		}
+	}
+	if format != render.FormatNDJSON && format != render.FormatCSV && format != render.FormatJSON {
+		http.Error(w, "format must be ndjson, csv or json", 400)
+		return
+	}
+	f, err := ParseBookFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	w.Header().Set("Content-Type", render.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"books.%s\"", format))
+	n, err := Export(r.Context(), dbi, w, format, f)
+	if err != nil && n == 0 {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// после первой записи статус уже не поменять: обрываем соединение, чтобы
+	// клиент не принял обрезанную выгрузку за полную
+	if err != nil {
+		log.Printf("ошибка выгрузки книг после %d записей: %v", n, err)
+		panic(http.ErrAbortHandler)
+	}
+}
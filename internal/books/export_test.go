@@ -0,0 +1,112 @@
+package books
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+func TestExportBooksCSV(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books/export?format=csv&author=Auth", nil)
+	w := httptest.NewRecorder()
+	ExportBooks(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if w.Body.String() != "id,title,author,published_at,isbn,language\n1,Test Book,Author,,,\n" {
+		t.Fatalf("unexpected body: %q", w.Body.String())
+	}
+}
+
+func TestExportCSVRoundTrip(t *testing.T) {
+	published, isbn, lang := "2020-01-02", "9780306406157", "ru"
+	b := Book{ID: 1, Title: "War and Peace", Author: "Tolstoy", PublishedAt: &published, ISBN: &isbn, Language: &lang}
+	var buf bytes.Buffer
+	out := csv.NewWriter(&buf)
+	out.Write(b.CSVHeader())
+	out.Write(b.CSVRecord())
+	out.Flush()
+	in, err := newCSVReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	row, err := in.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := row.book
+	if got.Title != b.Title || deref(got.PublishedAt) != published || deref(got.ISBN) != isbn || deref(got.Language) != lang {
+		t.Fatalf("export does not import back: %+v", got)
+	}
+}
+
+func TestExportBooksBadParams(t *testing.T) {
+	SetBookDB(&mockDB{})
+	for _, q := range []string{"format=xml", "published_from=yesterday"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/books/export?"+q, nil)
+		w := httptest.NewRecorder()
+		ExportBooks(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestBookFilterWhere(t *testing.T) {
+	f, err := ParseBookFilter(url.Values{"author": {"tolstoy"}, "published_to": {"1900-01-01"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	where, args := f.where(nil)
+	if where != " WHERE deleted_at IS NULL AND author ILIKE '%' || $1 || '%' AND published_at <= $2::date" {
+		t.Fatalf("unexpected where: %q", where)
+	}
+	if len(args) != 2 || args[0] != "tolstoy" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+}
+
+func TestBookFilterLikeIsLiteral(t *testing.T) {
+	_, args := BookFilter{Author: "100%", Title: "a_b"}.where(nil)
+	if len(args) != 2 || args[0] != `100\%` || args[1] != `a\_b` {
+		t.Fatalf("expected escaped LIKE values, got %v", args)
+	}
+}
+
+// brokenRows — курсор, оборвавшийся после первой книги
+type brokenRows struct{ mockRows }
+
+func (r *brokenRows) Err() error { return errors.New("connection reset") }
+
+type brokenDB struct{ mockDB }
+
+func (m *brokenDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &brokenRows{}, nil
+}
+
+func TestExportStopsOnCursorError(t *testing.T) {
+	var out strings.Builder
+	if n, err := Export(context.Background(), &brokenDB{}, &out, render.FormatNDJSON, BookFilter{}); err == nil || n != 1 {
+		t.Fatalf("expected cursor error after 1 book, got n=%d err=%v", n, err)
+	}
+	SetBookDB(&brokenDB{})
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Fatalf("expected the response to be aborted, got %v", r)
+		}
+	}()
+	ExportBooks(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/books/export?format=ndjson", nil))
+}
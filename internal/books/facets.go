@@ -0,0 +1,191 @@
+package books
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"books-api/internal/db"
+)
+
+// FacetBucket — одно значение фасета и число книг с ним
+type FacetBucket struct {
+	Value string `json:"value" xml:"value,attr"`
+	Label string `json:"label,omitempty" xml:"label,attr,omitempty"`
+	Count int    `json:"count" xml:"count,attr"`
+}
+
+// Facets — бакеты по имени фасета
+type Facets map[string][]FacetBucket
+
+// MarshalXML нужен, потому что encoding/xml не умеет map
+func (f Facets) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, name := range names {
+		facet := xml.StartElement{Name: xml.Name{Local: "facet"}, Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: name}}}
+		if err := e.EncodeElement(struct {
+			Buckets []FacetBucket `xml:"bucket"`
+		}{f[name]}, facet); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// BookList — ответ списка книг, когда запрошены фасеты
+type BookList struct {
+	XMLName xml.Name `json:"-" xml:"books"`
+	Books   []Book   `json:"books" xml:"book"`
+	Facets  Facets   `json:"facets" xml:"facets"`
+}
+
+// Фасеты списка книг
+const (
+	facetAuthor        = "author"
+	facetGenre         = "genre"
+	facetPublishedYear = "published_year"
+	facetLanguage      = "language"
+)
+
+// facetBucketLimit — сколько самых частых значений отдаётся на фасет
+const facetBucketLimit = 50
+
+// facetSQL — выборка бакетов фасета из CTE matched; %s — условие WHERE.
+// Колонки: count, value, label, facet.
+var facetSQL = map[string]string{
+	facetAuthor: `SELECT count(DISTINCT m.id), a.id::text, a.name, 'author'::text
+		FROM matched m JOIN book_authors ba ON ba.book_id = m.id JOIN authors a ON a.id = ba.author_id%s
+		GROUP BY a.id, a.name ORDER BY 1 DESC, a.name`,
+	facetGenre: `SELECT count(*), g.slug, g.name, 'genre'::text
+		FROM matched m JOIN book_genres bg ON bg.book_id = m.id JOIN genres g ON g.id = bg.genre_id%s
+		GROUP BY g.slug, g.name ORDER BY 1 DESC, g.name`,
+	facetPublishedYear: `SELECT count(*), extract(year FROM m.published_at)::int::text, ''::text, 'published_year'::text
+		FROM matched m%s
+		GROUP BY 2 ORDER BY 2 DESC`,
+	facetLanguage: `SELECT count(*), m.language, ''::text, 'language'::text
+		FROM matched m%s
+		GROUP BY m.language ORDER BY 1 DESC, m.language`,
+}
+
+// facetNotNull — колонка, без значения которой книга в фасет не попадает
+var facetNotNull = map[string]string{
+	facetPublishedYear: "m.published_at IS NOT NULL",
+	facetLanguage:      "m.language IS NOT NULL",
+}
+
+// facetOrder — порядок фасетов в запросе, чтобы SQL не зависел от обхода map
+var facetOrder = []string{facetAuthor, facetGenre, facetPublishedYear, facetLanguage}
+
+var knownFacets = map[string]bool{facetAuthor: true, facetGenre: true, facetPublishedYear: true, facetLanguage: true}
+
+// parseFacets разбирает ?facets=author,genre,...
+func parseFacets(s string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" || seen[name] {
+			continue
+		}
+		if !knownFacets[name] {
+			return nil, fmt.Errorf("unknown facet %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// facetsQuery собирает все фасеты в один запрос. CTE matched применяет
+// фильтры, не относящиеся ни к одному фасету, а условия фасетов считает
+// флагами f_<фасет>: каждый фасет учитывает флаги всех остальных, но не свой,
+// иначе в сайдбаре осталось бы одно выбранное значение.
+func facetsQuery(f BookFilter, names []string) (string, []any) {
+	conds, args := f.conds(nil)
+	var common []string
+	byFacet := map[string][]string{}
+	for _, c := range conds {
+		if c.facet == "" {
+			common = append(common, c.sql)
+		} else {
+			byFacet[c.facet] = append(byFacet[c.facet], c.sql)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("WITH matched AS (SELECT id, published_at, language")
+	for _, name := range facetOrder {
+		if c, ok := byFacet[name]; ok {
+			fmt.Fprintf(&sb, ", (%s) AS f_%s", strings.Join(c, " AND "), name)
+		}
+	}
+	sb.WriteString(" FROM books")
+	if len(common) > 0 {
+		sb.WriteString(" WHERE " + strings.Join(common, " AND "))
+	}
+	sb.WriteString(")\n")
+	requested := map[string]bool{}
+	for _, name := range names {
+		requested[name] = true
+	}
+	first := true
+	for _, name := range facetOrder {
+		if !requested[name] {
+			continue
+		}
+		var where []string
+		if c, ok := facetNotNull[name]; ok {
+			where = append(where, c)
+		}
+		for _, other := range facetOrder {
+			if _, ok := byFacet[other]; ok && other != name {
+				where = append(where, "m.f_"+other)
+			}
+		}
+		cond := ""
+		if len(where) > 0 {
+			cond = "\n\t\tWHERE " + strings.Join(where, " AND ")
+		}
+		if !first {
+			sb.WriteString("\nUNION ALL\n")
+		}
+		first = false
+		fmt.Fprintf(&sb, "(%s LIMIT %d)", fmt.Sprintf(facetSQL[name], cond), facetBucketLimit)
+	}
+	return sb.String(), args
+}
+
+// computeFacets считает бакеты запрошенных фасетов одним запросом
+func computeFacets(ctx context.Context, database db.TxDB, f BookFilter, names []string) (Facets, error) {
+	facets := make(Facets, len(names))
+	for _, name := range names {
+		facets[name] = []FacetBucket{}
+	}
+	sql, args := facetsQuery(f, names)
+	rows, err := database.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b FacetBucket
+		var facet string
+		if err := rows.Scan(&b.Count, &b.Value, &b.Label, &facet); err != nil {
+			return nil, err
+		}
+		if _, ok := facets[facet]; ok {
+			facets[facet] = append(facets[facet], b)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return facets, nil
+}
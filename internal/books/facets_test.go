@@ -0,0 +1,145 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"books-api/internal/db"
+)
+
+// facetRows отдаёт бакеты в порядке колонок facetsQuery
+type facetRows struct {
+	idx     int
+	buckets [][]any
+}
+
+func (r *facetRows) Next() bool { r.idx++; return r.idx <= len(r.buckets) }
+func (r *facetRows) Scan(dest ...any) error {
+	b := r.buckets[r.idx-1]
+	*dest[0].(*int) = b[0].(int)
+	*dest[1].(*string) = b[1].(string)
+	*dest[2].(*string) = b[2].(string)
+	*dest[3].(*string) = b[3].(string)
+	return nil
+}
+func (r *facetRows) Close()     {}
+func (r *facetRows) Err() error { return nil }
+
+// facetDB запоминает запросы фасетов и отвечает на них facetRows
+type facetDB struct {
+	mockDB
+	facetQueries []string
+}
+
+func (m *facetDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	if !strings.HasPrefix(sql, "WITH matched") {
+		return m.mockDB.Query(ctx, sql, args...)
+	}
+	m.facetQueries = append(m.facetQueries, sql)
+	return &facetRows{buckets: [][]any{
+		{3, "7", "Толстой", "author"},
+		{2, "fantasy", "Фэнтези", "genre"},
+		{2, "1869", "", "published_year"},
+		{1, "ru", "", "language"},
+	}}, nil
+}
+
+func TestListBooksWithFacets(t *testing.T) {
+	database := &facetDB{}
+	SetBookDB(database)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books?genre=fantasy&include_subgenres=true&facets=author,genre,published_year,language", nil)
+	w := httptest.NewRecorder()
+	ListBooks(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var list BookList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(list.Books) != 1 || len(list.Facets) != 4 {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+	if g := list.Facets["genre"]; len(g) != 1 || g[0].Value != "fantasy" || g[0].Count != 2 {
+		t.Fatalf("unexpected genre facet: %+v", g)
+	}
+	if len(database.facetQueries) != 1 {
+		t.Fatalf("expected facets in one query, got %d", len(database.facetQueries))
+	}
+}
+
+func TestFacetsQueryExcludesOwnFilter(t *testing.T) {
+	f := BookFilter{Title: "war", Genre: "fantasy", Language: "ru"}
+	sql, args := facetsQuery(f, []string{facetGenre, facetLanguage})
+	if len(args) != 3 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	if !strings.Contains(sql, "FROM books WHERE deleted_at IS NULL AND title ILIKE") {
+		t.Fatalf("title filter must apply to every facet: %s", sql)
+	}
+	branches := strings.Split(sql, "UNION ALL")
+	if len(branches) != 2 {
+		t.Fatalf("expected 2 branches: %s", sql)
+	}
+	if strings.Contains(branches[0], "m.f_genre") || !strings.Contains(branches[0], "m.f_language") {
+		t.Fatalf("genre facet must ignore only the genre filter: %s", branches[0])
+	}
+	if strings.Contains(branches[1], "m.f_language") || !strings.Contains(branches[1], "m.f_genre") {
+		t.Fatalf("language facet must ignore only the language filter: %s", branches[1])
+	}
+}
+
+func TestListBooksUnknownFacet(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books?facets=color", nil)
+	w := httptest.NewRecorder()
+	ListBooks(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestBookFilterGenre(t *testing.T) {
+	f, err := ParseBookFilter(url.Values{"genre": {"fantasy"}, "include_subgenres": {"true"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	where, args := f.where(nil)
+	if !strings.Contains(where, "WITH RECURSIVE") || !strings.Contains(where, "slug = $1") || len(args) != 1 {
+		t.Fatalf("unexpected where: %q %v", where, args)
+	}
+	f.IncludeSubgenres = false
+	if where, _ = f.where(nil); strings.Contains(where, "RECURSIVE") {
+		t.Fatalf("subgenres must not be included: %q", where)
+	}
+}
+
+func TestBookFilterYearAndLanguage(t *testing.T) {
+	f, err := ParseBookFilter(url.Values{"published_year": {"1869"}, "language": {"RU"}, "author_id": {"7"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.PublishedYear != 1869 || f.Language != "ru" || f.AuthorID != 7 {
+		t.Fatalf("unexpected filter: %+v", f)
+	}
+	if _, err := ParseBookFilter(url.Values{"published_year": {"soon"}}); err == nil {
+		t.Fatal("expected error for invalid year")
+	}
+}
+
+func TestFacetsXML(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/xml")
+	SetBookDB(&facetDB{})
+	req.URL.RawQuery = "facets=genre"
+	ListBooks(w, req)
+	if !strings.Contains(w.Body.String(), `<facet name="genre"><bucket value="fantasy" label="Фэнтези" count="2"></bucket></facet>`) {
+		t.Fatalf("unexpected xml: %s", w.Body.String())
+	}
+}
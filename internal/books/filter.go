@@ -0,0 +1,126 @@
+package books
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"books-api/internal/db"
+)
+
+// BookFilter — фильтры выборки книг из query-параметров
+type BookFilter struct {
+	Author        string
+	Title         string
+	PublishedFrom string
+	PublishedTo   string
+	// Genre — slug жанра; с IncludeSubgenres учитываются и все поджанры
+	Genre            string
+	IncludeSubgenres bool
+	AuthorID         int
+	PublishedYear    int
+	Language         string
+	// Deleted — выбирать книги из корзины вместо обычных
+	Deleted bool
+}
+
+// ParseBookFilter читает author, author_id, title, published_from,
+// published_to, published_year, language, genre и include_subgenres
+func ParseBookFilter(q url.Values) (BookFilter, error) {
+	f := BookFilter{
+		Author:        strings.TrimSpace(q.Get("author")),
+		Title:         strings.TrimSpace(q.Get("title")),
+		PublishedFrom: q.Get("published_from"),
+		PublishedTo:   q.Get("published_to"),
+		Genre:         strings.TrimSpace(q.Get("genre")),
+		Language:      strings.ToLower(strings.TrimSpace(q.Get("language"))),
+	}
+	if v := q.Get("include_subgenres"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.New("include_subgenres must be a boolean")
+		}
+		f.IncludeSubgenres = b
+	}
+	for name, dst := range map[string]*int{"author_id": &f.AuthorID, "published_year": &f.PublishedYear} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("%s must be a positive integer", name)
+		}
+		*dst = n
+	}
+	for name, v := range map[string]string{"published_from": f.PublishedFrom, "published_to": f.PublishedTo} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			return f, fmt.Errorf("%s must be YYYY-MM-DD", name)
+		}
+	}
+	return f, nil
+}
+
+// filterCond — условие фильтра; facet — фасет, которому оно принадлежит
+// и который его не учитывает
+type filterCond struct {
+	facet string
+	sql   string
+}
+
+// conds строит условия фильтра, дописывая параметры к args.
+// Автор и название ищутся по подстроке без учёта регистра, % и _ в них —
+// обычные символы.
+func (f BookFilter) conds(args []any) ([]filterCond, []any) {
+	conds := []filterCond{{sql: "deleted_at IS NULL"}}
+	if f.Deleted {
+		conds[0].sql = "deleted_at IS NOT NULL"
+	}
+	add := func(facet, cond string, v any) {
+		args = append(args, v)
+		conds = append(conds, filterCond{facet: facet, sql: fmt.Sprintf(cond, len(args))})
+	}
+	if f.Author != "" {
+		add(facetAuthor, "author ILIKE '%%' || $%d || '%%'", db.EscapeLike(f.Author))
+	}
+	if f.AuthorID != 0 {
+		add(facetAuthor, "id IN (SELECT book_id FROM book_authors WHERE author_id = $%d)", f.AuthorID)
+	}
+	if f.Title != "" {
+		add("", "title ILIKE '%%' || $%d || '%%'", db.EscapeLike(f.Title))
+	}
+	if f.PublishedFrom != "" {
+		add("", "published_at >= $%d::date", f.PublishedFrom)
+	}
+	if f.PublishedTo != "" {
+		add("", "published_at <= $%d::date", f.PublishedTo)
+	}
+	if f.PublishedYear != 0 {
+		add(facetPublishedYear, "extract(year FROM published_at) = $%d", f.PublishedYear)
+	}
+	if f.Language != "" {
+		add(facetLanguage, "language = $%d", f.Language)
+	}
+	if f.Genre != "" && f.IncludeSubgenres {
+		add(facetGenre, "id IN (SELECT book_id FROM book_genres WHERE genre_id IN ("+genreSubtreeSQL+"))", f.Genre)
+	} else if f.Genre != "" {
+		add(facetGenre, "id IN (SELECT bg.book_id FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE g.slug = $%d)", f.Genre)
+	}
+	return conds, args
+}
+
+// where строит условие WHERE, дописывая параметры к args
+func (f BookFilter) where(args []any) (string, []any) {
+	conds, args := f.conds(args)
+	parts := make([]string, len(conds))
+	for i, c := range conds {
+		parts[i] = c.sql
+	}
+	return " WHERE " + strings.Join(parts, " AND "), args
+}
@@ -0,0 +1,77 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+)
+
+var errUnknownGenre = errors.New("unknown genre")
+
+// BookGenre — жанр книги; при записи достаточно id или slug
+type BookGenre struct {
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Slug string `json:"slug" xml:"slug"`
+}
+
+const genresColumn = `COALESCE((SELECT json_agg(json_build_object('id', g.id, 'name', g.name, 'slug', g.slug) ORDER BY g.name)
+	FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = books.id), '[]'::json)`
+
+// genreSubtreeSQL — id жанра по slug и всех его потомков
+const genreSubtreeSQL = `WITH RECURSIVE subtree AS (
+		SELECT id FROM genres WHERE slug = $%d
+		UNION
+		SELECT g.id FROM genres g JOIN subtree s ON g.parent_id = s.id
+	) SELECT id FROM subtree`
+
+func decodeGenres(raw []byte, b *Book) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, &b.Genres)
+}
+
+// saveBookGenres заменяет жанры книги. nil означает «не менять», чтобы
+// клиенты, не знающие о жанрах, не стирали их при PUT.
+func saveBookGenres(ctx context.Context, tx db.TxDB, b *Book) error {
+	if b.Genres == nil {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM book_genres WHERE book_id=$1", b.ID); err != nil {
+		return err
+	}
+	for i := range b.Genres {
+		g := &b.Genres[i]
+		if g.ID == 0 && g.Slug == "" {
+			return fmt.Errorf("%w: genre must have id or slug", errUnknownGenre)
+		}
+		// id важнее slug: иначе запрос мог бы вернуть два разных жанра
+		var row db.Row
+		if g.ID != 0 {
+			row = tx.QueryRow(ctx, "SELECT id, name, slug FROM genres WHERE id=$1", g.ID)
+		} else {
+			row = tx.QueryRow(ctx, "SELECT id, name, slug FROM genres WHERE slug=$1", g.Slug)
+		}
+		if err := row.Scan(&g.ID, &g.Name, &g.Slug); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				key := g.Slug
+				if g.ID != 0 {
+					key = strconv.Itoa(g.ID)
+				}
+				return fmt.Errorf("%w: %s", errUnknownGenre, key)
+			}
+			return err
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO book_genres (book_id, genre_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", b.ID, g.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
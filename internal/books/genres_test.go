@@ -0,0 +1,27 @@
+package books
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSaveBookGenresLooksUpByIDOrSlug(t *testing.T) {
+	cases := []struct {
+		genre BookGenre
+		want  string
+	}{
+		{BookGenre{ID: 3, Slug: "fantasy"}, "WHERE id=$1"},
+		{BookGenre{Slug: "fantasy"}, "WHERE slug=$1"},
+	}
+	for _, tc := range cases {
+		m := &queryDB{}
+		b := Book{ID: 1, Genres: []BookGenre{tc.genre}}
+		if err := saveBookGenres(context.Background(), m, &b); err != nil {
+			t.Fatalf("%+v: unexpected error: %v", tc.genre, err)
+		}
+		if !strings.HasSuffix(m.sql, tc.want) {
+			t.Fatalf("%+v: expected lookup %q, got %q", tc.genre, tc.want, m.sql)
+		}
+	}
+}
@@ -3,18 +3,27 @@ package books
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/segmentio/kafka-go"
 
 	"books-api/internal/db"
+	"books-api/internal/render"
 )
 
 type Producer interface {
-	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
 	Close() error
 }
 
@@ -30,35 +39,233 @@ func SetProducer(w Producer) {
 }
 
 type Book struct {
-	ID     int    `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
+	XMLName xml.Name `json:"-" xml:"book"`
+	ID      int      `json:"id" xml:"id"`
+	Title   string   `json:"title" xml:"title"`
+	// Author — устаревшая строка с именами, собирается из Authors.
+	// При записи можно передать либо её, либо массив authors.
+	Author      string  `json:"author" xml:"author"`
+	PublishedAt *string `json:"published_at,omitempty" xml:"published_at,omitempty"`
+	ISBN        *string `json:"isbn,omitempty" xml:"isbn,omitempty"`
+	// Language — код языка ISO 639-1/639-3 в нижнем регистре
+	Language *string `json:"language,omitempty" xml:"language,omitempty"`
+	// DeletedAt заполнен только у книг в корзине
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+	// RatingAvg и RatingCount — средняя оценка и число отзывов читателей
+	RatingAvg   *float64 `json:"rating_avg,omitempty" xml:"rating_avg,omitempty"`
+	RatingCount int      `json:"rating_count" xml:"rating_count"`
+	// Authors — авторы книги с ролями
+	Authors []BookAuthor `json:"authors,omitempty" xml:"authors>author,omitempty"`
+	Genres  []BookGenre  `json:"genres,omitempty" xml:"genres>genre,omitempty"`
+}
+
+// bookColumns — порядок колонок, который ожидает scanBook
+const bookColumns = "id, title, author, published_at::text, isbn, language, deleted_at, rating_avg::float8, rating_count, " + authorsColumn + ", " + genresColumn
+
+func scanBook(row db.Row) (Book, error) {
+	var b Book
+	var authors, genres []byte
+	if err := row.Scan(&b.ID, &b.Title, &b.Author, &b.PublishedAt, &b.ISBN, &b.Language, &b.DeletedAt, &b.RatingAvg, &b.RatingCount, &authors, &genres); err != nil {
+		return b, err
+	}
+	if err := decodeAuthors(authors, &b); err != nil {
+		return b, err
+	}
+	return b, decodeGenres(genres, &b)
+}
+
+// auditEntity — имя сущности книги в журнале изменений
+const auditEntity = "book"
+
+// lockBook читает книгу в транзакции с блокировкой строки, чтобы состояние
+// «до» в журнале не разошлось с тем, что на самом деле изменилось
+func lockBook(ctx context.Context, tx db.TxDB, id string, deleted bool) (Book, error) {
+	cond := "deleted_at IS NULL"
+	if deleted {
+		cond = "deleted_at IS NOT NULL"
+	}
+	return scanBook(tx.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id=$1 AND "+cond+" FOR UPDATE", id))
+}
+
+// saveBookRelations записывает авторов и жанры книги в рамках транзакции
+// и отвечает 400/500 сам; false — ответ уже отправлен
+func saveBookRelations(ctx context.Context, w http.ResponseWriter, tx db.TxDB, b *Book) bool {
+	err := saveBookAuthors(ctx, tx, b)
+	if err == nil {
+		err = saveBookGenres(ctx, tx, b)
+	}
+	if errors.Is(err, errUnknownAuthor) || errors.Is(err, errUnknownGenre) {
+		http.Error(w, err.Error(), 400)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	return true
+}
+
+var languageCode = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// validateBook проверяет поля книги перед записью в БД
+func validateBook(b *Book) error {
+	b.Title = strings.TrimSpace(b.Title)
+	b.Author = strings.TrimSpace(b.Author)
+	if b.Title == "" {
+		return errors.New("title is required")
+	}
+	if err := normalizeAuthors(b); err != nil {
+		return err
+	}
+	if len(b.Authors) == 0 {
+		return errors.New("author is required")
+	}
+	if b.PublishedAt != nil {
+		if *b.PublishedAt == "" {
+			b.PublishedAt = nil
+		} else if _, err := time.Parse(time.DateOnly, *b.PublishedAt); err != nil {
+			return errors.New("published_at must be YYYY-MM-DD")
+		}
+	}
+	if b.ISBN != nil {
+		if *b.ISBN == "" {
+			b.ISBN = nil
+		} else {
+			isbn, err := NormalizeISBN(*b.ISBN)
+			if err != nil {
+				return err
+			}
+			b.ISBN = &isbn
+		}
+	}
+	if b.Language != nil {
+		lang := strings.ToLower(strings.TrimSpace(*b.Language))
+		if lang == "" {
+			b.Language = nil
+		} else if !languageCode.MatchString(lang) {
+			return errors.New("language must be an ISO 639 code")
+		} else {
+			b.Language = &lang
+		}
+	}
+	return nil
+}
+
+// maxListLimit ограничивает страницу списка книг
+const maxListLimit = 1000
+
+// page — страница списка книг. Без limit (nil) отдаются все книги, как до
+// появления постраничного вывода.
+type page struct {
+	limit  *int
+	offset int
+}
+
+func parsePage(q url.Values) (page, error) {
+	var p page
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, errors.New("limit must be a positive integer")
+		}
+		n = min(n, maxListLimit)
+		p.limit = &n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, errors.New("offset must be a non-negative integer")
+		}
+		p.offset = n
+	}
+	return p, nil
+}
+
+// totalRow дочитывает последнюю колонку count(*) OVER () после колонок книги
+type totalRow struct {
+	row   db.Row
+	total *int
+}
+
+func (t totalRow) Scan(dest ...any) error {
+	return t.row.Scan(append(dest, t.total)...)
 }
 
 // @Summary Получить список книг
 // @Tags books
 // @Produce json
+// @Param author query string false "Автор (подстрока)"
+// @Param title query string false "Название (подстрока)"
+// @Param author_id query int false "ID автора"
+// @Param genre query string false "Slug жанра"
+// @Param include_subgenres query bool false "Учитывать поджанры"
+// @Param published_year query int false "Год издания"
+// @Param language query string false "Код языка"
+// @Param facets query string false "Фасеты через запятую: author, genre, published_year, language"
+// @Param expand query string false "Связи через запятую: authors, genres; по умолчанию обе"
+// @Param limit query int false "Книг на странице; без limit и offset отдаются все"
+// @Param offset query int false "Сколько книг пропустить"
 // @Success 200 {array} books.Book
+// @Success 200 {object} books.BookList "если запрошены фасеты"
+// @Header 200 {int} X-Total-Count "Число книг по фильтру"
 // @Router /api/v1/books [get]
 func ListBooks(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbi.Query(r.Context(), "SELECT id, title, author FROM books")
+	f, err := ParseBookFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	facetNames, err := parseFacets(r.URL.Query().Get("facets"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	expand, err := expandFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	p, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	where, args := f.where(nil)
+	args = append(args, p.limit, p.offset)
+	rows, err := dbi.Query(r.Context(), "SELECT "+expand.Columns()+", count(*) OVER () FROM books"+where+
+		fmt.Sprintf(" ORDER BY id LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	defer rows.Close()
-	var books []Book
+	books := []Book{}
+	total := 0
 	for rows.Next() {
-		var b Book
-		if err := rows.Scan(&b.ID, &b.Title, &b.Author); err != nil {
+		b, err := scanBook(totalRow{rows, &total})
+		if err != nil {
 			continue
 		}
 		books = append(books, b)
 	}
-	if err := json.NewEncoder(w).Encode(books); err != nil {
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// за последней страницей строк нет, и число неизвестно — заголовок не ставим
+	if len(books) > 0 || p.offset == 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+	if len(facetNames) == 0 {
+		render.Render(w, r, http.StatusOK, books)
+		return
+	}
+	facets, err := computeFacets(r.Context(), dbi, f, facetNames)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	render.Render(w, r, http.StatusOK, BookList{Books: books, Facets: facets})
 }
 
 // @Summary Создать книгу
@@ -67,6 +274,7 @@ func ListBooks(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param book body Book true "Книга"
 // @Success 201 {object} Book
+// @Failure 409 {object} ISBNConflict
 // @Router /api/v1/books [post]
 func CreateBook(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -86,46 +294,87 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	row := tx.QueryRow(ctx, "INSERT INTO books (title, author) VALUES ($1, $2) RETURNING id", b.Title, b.Author)
+	if err := validateBook(&b); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	row := tx.QueryRow(ctx, "INSERT INTO books (title, author, published_at, isbn, language) VALUES ($1, $2, $3::date, $4, $5) RETURNING id", b.Title, b.Author, b.PublishedAt, b.ISBN, b.Language)
 	if err := row.Scan(&b.ID); err != nil {
+		if isISBNConflict(err) {
+			writeISBNConflict(w, r, *b.ISBN)
+			return
+		}
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	if !saveBookRelations(ctx, w, tx, &b) {
+		return
+	}
+	if !recordChange(ctx, w, r, tx, "create", Book{}, b) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(ctx, kafka.Message{Value: []byte("created book: " + b.Title)}); err != nil {
-			log.Printf("ошибка отправки в Kafka: %v", err)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("created book: " + b.Title)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
 	}
 	if err := tx.Commit(ctx); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(b); err != nil {
-		http.Error(w, err.Error(), 500)
-		return
-	}
+	render.Render(w, r, http.StatusCreated, b)
 }
 
 // @Summary Получить книгу по id
 // @Tags books
 // @Produce json
 // @Param id path int true "ID книги"
+// @Param expand query string false "Связи через запятую: authors, genres; по умолчанию обе"
 // @Success 200 {object} Book
 // @Failure 404 {string} string "не найдено"
 // @Router /api/v1/books/{id} [get]
 func GetBook(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	var b Book
-	row := dbi.QueryRow(r.Context(), "SELECT id, title, author FROM books WHERE id=$1", id)
-	if err := row.Scan(&b.ID, &b.Title, &b.Author); err != nil {
+	expand, err := expandFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	b, err := scanBook(dbi.QueryRow(r.Context(), "SELECT "+expand.Columns()+" FROM books WHERE id=$1 AND deleted_at IS NULL", id))
+	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	if err := json.NewEncoder(w).Encode(b); err != nil {
-		http.Error(w, err.Error(), 500)
+	render.Render(w, r, http.StatusOK, b)
+}
+
+// @Summary Найти книгу по ISBN
+// @Tags books
+// @Produce json
+// @Param isbn path string true "ISBN-10 или ISBN-13, дефисы допускаются"
+// @Param expand query string false "Связи через запятую: authors, genres; по умолчанию обе"
+// @Success 200 {object} Book
+// @Failure 400 {string} string "некорректный ISBN"
+// @Failure 404 {string} string "не найдено"
+// @Router /api/v1/books/by-isbn/{isbn} [get]
+func GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+	isbn, err := NormalizeISBN(chi.URLParam(r, "isbn"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
+	expand, err := expandFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	b, err := scanBook(dbi.QueryRow(r.Context(), "SELECT "+expand.Columns()+" FROM books WHERE isbn=$1 AND deleted_at IS NULL", isbn))
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	render.Render(w, r, http.StatusOK, b)
 }
 
 // @Summary Обновить книгу
@@ -135,47 +384,110 @@ func GetBook(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "ID книги"
 // @Param book body Book true "Книга"
 // @Success 200 {object} Book
+// @Failure 409 {object} ISBNConflict
 // @Router /api/v1/books/{id} [put]
 func UpdateBook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	id := chi.URLParam(r, "id")
 	var b Book
 	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	row := dbi.QueryRow(r.Context(), "UPDATE books SET title=$1, author=$2 WHERE id=$3 RETURNING id, title, author", b.Title, b.Author, id)
-	if err := row.Scan(&b.ID, &b.Title, &b.Author); err != nil {
+	if err := validateBook(&b); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	before, err := lockBook(ctx, tx, id, false)
+	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	row := tx.QueryRow(ctx, "UPDATE books SET title=$1, author=$2, published_at=$3::date, isbn=$4, language=$5 WHERE id=$6 AND deleted_at IS NULL RETURNING id", b.Title, b.Author, b.PublishedAt, b.ISBN, b.Language, id)
+	if err := row.Scan(&b.ID); err != nil {
+		if isISBNConflict(err) {
+			writeISBNConflict(w, r, *b.ISBN)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if !saveBookRelations(ctx, w, tx, &b) {
+		return
+	}
+	// жанры могли остаться прежними, поэтому отдаём состояние из БД
+	if b, err = scanBook(tx.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id=$1", b.ID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordChange(ctx, w, r, tx, "update", before, b) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(r.Context(), kafka.Message{Value: []byte("updated book: " + id)}); err != nil {
-			log.Printf("ошибка отправки в Kafka: %v", err)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("updated book: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
 	}
-	if err := json.NewEncoder(w).Encode(b); err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	render.Render(w, r, http.StatusOK, b)
 }
 
-// @Summary Удалить книгу
+// @Summary Удалить книгу (в корзину)
 // @Tags books
 // @Param id path int true "ID книги"
-// @Success 204 {string} string "Книга удалена"
+// @Success 204 {string} string "Книга перемещена в корзину"
 // @Router /api/v1/books/{id} [delete]
 func DeleteBook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	id := chi.URLParam(r, "id")
-	row := dbi.QueryRow(r.Context(), "DELETE FROM books WHERE id=$1 RETURNING id", id)
-	var deletedID int
-	if err := row.Scan(&deletedID); err != nil {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	before, err := lockBook(ctx, tx, id, false)
+	if err != nil {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	// строка остаётся вместе со связями collection_books, окончательно
+	// книгу удаляет PurgeTrash
+	after := before
+	if err := tx.QueryRow(ctx, "UPDATE books SET deleted_at=now() WHERE id=$1 RETURNING id, deleted_at", before.ID).Scan(&after.ID, &after.DeletedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordChange(ctx, w, r, tx, "delete", before, after) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(r.Context(), kafka.Message{Value: []byte("deleted book: " + id)}); err != nil {
-			log.Printf("ошибка отправки в Kafka: %v", err)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("deleted book: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
 	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
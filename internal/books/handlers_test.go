@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -14,6 +16,7 @@ import (
 	"github.com/segmentio/kafka-go"
 
 	"books-api/internal/db"
+	"books-api/internal/testutil"
 )
 
 type mockRows struct{ idx int }
@@ -25,7 +28,8 @@ func (r *mockRows) Scan(dest ...any) error {
 	*dest[2].(*string) = "Author"
 	return nil
 }
-func (r *mockRows) Close() {}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
 
 type mockRow struct{}
 
@@ -53,7 +57,7 @@ func (m *mockDB) Commit(ctx context.Context) error   { return nil }
 
 type mockProducer struct{}
 
-func (m *mockProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+func (m *mockProducer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
 	return nil
 }
 func (m *mockProducer) Close() error { return nil }
@@ -76,6 +80,72 @@ func TestListBooks(t *testing.T) {
 	}
 }
 
+// pageRows — одна книга и count(*) OVER () в последней колонке
+type pageRows struct {
+	mockRows
+	total int
+}
+
+func (r *pageRows) Scan(dest ...any) error {
+	*dest[len(dest)-1].(*int) = r.total
+	return r.mockRows.Scan(dest...)
+}
+
+// pageDB запоминает аргументы запроса списка; при offset за концом списка
+// строк нет
+type pageDB struct {
+	mockDB
+	args []any
+}
+
+func (m *pageDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.args = args
+	if args[len(args)-1].(int) >= 3 {
+		return &pageRows{mockRows: mockRows{idx: 1}}, nil
+	}
+	return &pageRows{total: 3}, nil
+}
+
+func TestListBooksPaging(t *testing.T) {
+	cases := []struct {
+		query, total string
+		limit        any
+		offset       int
+	}{
+		{"", "3", (*int)(nil), 0},
+		{"?limit=1&offset=1", "3", 1, 1},
+		{"?limit=5000", "3", maxListLimit, 0},
+		{"?limit=1&offset=3", "", 1, 3},
+	}
+	for _, tc := range cases {
+		m := &pageDB{}
+		SetBookDB(m)
+		w := httptest.NewRecorder()
+		ListBooks(w, httptest.NewRequest(http.MethodGet, "/api/v1/books"+tc.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tc.query, w.Code)
+		}
+		if got := w.Header().Get("X-Total-Count"); got != tc.total {
+			t.Fatalf("%s: expected X-Total-Count %q, got %q", tc.query, tc.total, got)
+		}
+		limit := m.args[len(m.args)-2]
+		if l, ok := limit.(*int); ok && l != nil {
+			limit = *l
+		}
+		if limit != tc.limit || m.args[len(m.args)-1] != tc.offset {
+			t.Fatalf("%s: unexpected page args %v", tc.query, m.args)
+		}
+	}
+	for _, q := range []string{"?limit=0", "?offset=-1", "?limit=x"} {
+		SetBookDB(&pageDB{})
+		w := httptest.NewRecorder()
+		ListBooks(w, httptest.NewRequest(http.MethodGet, "/api/v1/books"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
 func TestCreateBook(t *testing.T) {
 	SetBookDB(&mockDB{})
 	SetProducer(&mockProducer{})
@@ -96,6 +166,28 @@ func TestCreateBook(t *testing.T) {
 	}
 }
 
+// commitDB — mockDB, который считает фиксации транзакций
+type commitDB struct {
+	mockDB
+	commits int
+}
+
+func (m *commitDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *commitDB) Commit(ctx context.Context) error { m.commits++; return nil }
+
+func TestCreateBookEventFailure(t *testing.T) {
+	database := &commitDB{}
+	SetBookDB(database)
+	SetProducer(&testutil.Producer{Err: errors.New("outbox insert failed")})
+	w := httptest.NewRecorder()
+	CreateBook(w, httptest.NewRequest(http.MethodPost, "/api/v1/books", strings.NewReader(`{"title":"Test","author":"A"}`)))
+	if w.Code != http.StatusInternalServerError || database.commits != 0 {
+		t.Fatalf("failed event write must abort the request, got %d with %d commits", w.Code, database.commits)
+	}
+}
+
 func TestGetBook(t *testing.T) {
 	SetBookDB(&mockDB{})
 	SetProducer(&mockProducer{})
@@ -153,3 +245,53 @@ func TestDeleteBook(t *testing.T) {
 		t.Fatalf("expected 204, got %d", w.Code)
 	}
 }
+
+func TestListBooksCSV(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books", nil)
+	req.Header.Set("Accept", "text/csv")
+	w := httptest.NewRecorder()
+	ListBooks(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if w.Body.String() != "id,title,author,published_at,isbn,language\n1,Test Book,Author,,,\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestGetBookNotAcceptable(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books/1", nil)
+	req.Header.Set("Accept", "image/png")
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+	w := httptest.NewRecorder()
+	GetBook(w, req)
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+}
+
+func TestCreateBookWithAuthors(t *testing.T) {
+	SetBookDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	body := []byte(`{"title":"Good Omens","authors":[{"name":"Neil Gaiman"},{"name":"Terry Pratchett"}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	CreateBook(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp Book
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if resp.Author != "Neil Gaiman; Terry Pratchett" || len(resp.Authors) != 2 || resp.Authors[0].ID != 1 {
+		t.Fatalf("unexpected book: %+v", resp)
+	}
+}
@@ -0,0 +1,41 @@
+package books
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/audit"
+	"books-api/internal/render"
+)
+
+// @Summary История изменений книги
+// @Description Записи журнала с актором и IP, поэтому нужно право audit:read
+// @Tags books
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param since query string false "С момента, RFC 3339 или YYYY-MM-DD"
+// @Param limit query int false "Не больше записей, по умолчанию 100"
+// @Success 200 {array} audit.Entry
+// @Router /api/v1/books/{id}/history [get]
+func BookHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "id must be an integer", 400)
+		return
+	}
+	f, err := audit.ParseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	// история остаётся доступной и для книг в корзине, и после очистки
+	f.Entity, f.EntityID = auditEntity, &id
+	entries, err := audit.List(r.Context(), dbi, f)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, entries)
+}
@@ -0,0 +1,86 @@
+package books
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+)
+
+// auditDB запоминает записи журнала и запросы к нему
+type auditDB struct {
+	mockDB
+	entries [][]any
+	queries []string
+}
+
+func (m *auditDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.HasPrefix(sql, "INSERT INTO audit_log") {
+		m.entries = append(m.entries, args)
+	}
+	return m.mockDB.Exec(ctx, sql, args...)
+}
+func (m *auditDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.queries = append(m.queries, sql)
+	return &emptyRows{}, nil
+}
+func (m *auditDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+type emptyRows struct{}
+
+func (r *emptyRows) Next() bool             { return false }
+func (r *emptyRows) Scan(dest ...any) error { return nil }
+func (r *emptyRows) Close()                 {}
+func (r *emptyRows) Err() error             { return nil }
+
+func withBookID(req *http.Request) *http.Request {
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", "1")
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+}
+
+func TestDeleteBookRecordsAudit(t *testing.T) {
+	database := &auditDB{}
+	SetBookDB(database)
+	SetProducer(&mockProducer{})
+	req := withBookID(httptest.NewRequest(http.MethodDelete, "/api/v1/books/1", nil))
+	req.Header.Set("X-Actor", "alice")
+	w := httptest.NewRecorder()
+	DeleteBook(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if len(database.entries) != 1 {
+		t.Fatalf("expected one audit entry, got %d", len(database.entries))
+	}
+	args := database.entries[0]
+	if args[0] != "alice" || args[3] != "delete" || args[4] != "book" {
+		t.Fatalf("unexpected audit entry: %v", args)
+	}
+}
+
+func TestBookHistory(t *testing.T) {
+	database := &auditDB{}
+	SetBookDB(database)
+	req := withBookID(httptest.NewRequest(http.MethodGet, "/api/v1/books/1/history", nil))
+	w := httptest.NewRecorder()
+	BookHistory(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+	if len(database.queries) != 1 || !strings.Contains(database.queries[0], "WHERE entity = $1 AND entity_id = $2") {
+		t.Fatalf("unexpected queries: %v", database.queries)
+	}
+}
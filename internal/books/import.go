@@ -0,0 +1,463 @@
+package books
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+
+	// ImportAtomic — всё или ничего: любая ошибка отменяет весь импорт
+	ImportAtomic = "atomic"
+	// ImportBestEffort — некорректные строки пропускаются, остальные сохраняются
+	ImportBestEffort = "best_effort"
+
+	defaultImportBatch = 500
+)
+
+type ImportOptions struct {
+	Format    string
+	Mode      string
+	DryRun    bool
+	BatchSize int
+	// Source — кто импортирует, для журнала изменений
+	Source audit.Source
+}
+
+// ErrInvalidImport — входные данные нельзя разобрать целиком (формат,
+// заголовок CSV, битый JSON), в отличие от ошибок отдельных строк
+var ErrInvalidImport = errors.New("invalid import")
+
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"error"`
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+type ImportReport struct {
+	Mode     string     `json:"mode"`
+	DryRun   bool       `json:"dry_run"`
+	Total    int        `json:"total"`
+	Imported int        `json:"imported"`
+	Failed   int        `json:"failed"`
+	Errors   []RowError `json:"errors,omitempty"`
+}
+
+func (r *ImportReport) fail(row int, err error) {
+	r.Failed++
+	r.Errors = append(r.Errors, RowError{Row: row, Message: err.Error()})
+}
+
+type importRow struct {
+	num  int
+	book Book
+}
+
+// rowReader отдаёт строки по одной, не загружая файл в память.
+// Ошибка разбора строки возвращается как *RowError, io.EOF — конец данных.
+type rowReader interface {
+	Next() (importRow, error)
+}
+
+type ndjsonReader struct {
+	dec *json.Decoder
+	num int
+}
+
+func (r *ndjsonReader) Next() (importRow, error) {
+	var b Book
+	r.num++
+	if err := r.dec.Decode(&b); err != nil {
+		if errors.Is(err, io.EOF) {
+			return importRow{}, io.EOF
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// после синтаксической ошибки декодер не может продолжить
+			return importRow{}, fmt.Errorf("%w: row %d: %v", ErrInvalidImport, r.num, err)
+		}
+		return importRow{num: r.num}, &RowError{Row: r.num, Message: err.Error()}
+	}
+	return importRow{num: r.num, book: b}, nil
+}
+
+type csvReader struct {
+	r   *csv.Reader
+	col map[string]int
+	num int
+}
+
+func newCSVReader(in io.Reader) (*csvReader, error) {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: empty csv", ErrInvalidImport)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"title", "author"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("%w: csv header must contain %q column", ErrInvalidImport, required)
+		}
+	}
+	return &csvReader{r: r, col: col}, nil
+}
+
+func (r *csvReader) field(rec []string, name string) string {
+	i, ok := r.col[name]
+	if !ok || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
+
+func (r *csvReader) Next() (importRow, error) {
+	rec, err := r.r.Read()
+	r.num++
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return importRow{}, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importRow{num: r.num}, &RowError{Row: r.num, Message: err.Error()}
+		}
+		return importRow{}, err
+	}
+	b := Book{
+		Title:  r.field(rec, "title"),
+		Author: r.field(rec, "author"),
+	}
+	if v := r.field(rec, "published_at"); v != "" {
+		b.PublishedAt = &v
+	}
+	if v := r.field(rec, "isbn"); v != "" {
+		b.ISBN = &v
+	}
+	if v := r.field(rec, "language"); v != "" {
+		b.Language = &v
+	}
+	return importRow{num: r.num, book: b}, nil
+}
+
+func newRowReader(in io.Reader, format string) (rowReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(in)
+	case FormatNDJSON:
+		return &ndjsonReader{dec: json.NewDecoder(in)}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidImport, format)
+	}
+}
+
+// Import построчно читает книги из in, проверяет их как CreateBook и
+// вставляет пачками в одной транзакции. При DryRun транзакция
+// откатывается, но отчёт содержит всё, что произошло бы на самом деле.
+// По итогам отправляется одно событие вместо события на каждую книгу.
+func Import(ctx context.Context, database db.TxDB, in io.Reader, opts ImportOptions) (ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportAtomic
+	}
+	if opts.Mode != ImportAtomic && opts.Mode != ImportBestEffort {
+		return ImportReport{}, fmt.Errorf("%w: unsupported mode %q", ErrInvalidImport, opts.Mode)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultImportBatch
+	}
+	report := ImportReport{Mode: opts.Mode, DryRun: opts.DryRun}
+	rows, err := newRowReader(in, opts.Format)
+	if err != nil {
+		return report, err
+	}
+
+	tx, err := database.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return report, err
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+
+	batch := make([]importRow, 0, opts.BatchSize)
+	var ids []int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		// в атомарном режиме после первой ошибки писать бессмысленно,
+		// но строки продолжаем проверять ради полного отчёта
+		if opts.Mode == ImportAtomic && report.Failed > 0 {
+			batch = batch[:0]
+			return nil
+		}
+		inserted, err := insertBatch(ctx, tx, batch, &report)
+		ids = append(ids, inserted...)
+		report.Imported += len(inserted)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		row, err := rows.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		report.Total++
+		if err != nil {
+			var rowErr *RowError
+			if !errors.As(err, &rowErr) {
+				return report, err
+			}
+			report.Failed++
+			report.Errors = append(report.Errors, *rowErr)
+			continue
+		}
+		if err := validateBook(&row.book); err != nil {
+			report.fail(row.num, err)
+			continue
+		}
+		batch = append(batch, row)
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+
+	if opts.Mode == ImportAtomic && report.Failed > 0 {
+		report.Imported = 0
+		return report, nil
+	}
+	if report.Imported > 0 {
+		if err := LinkLegacyAuthors(ctx, tx, ids); err != nil {
+			return report, err
+		}
+		if err := RecordInitialRevisions(ctx, tx, opts.Source, ids); err != nil {
+			return report, err
+		}
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+	if report.Imported > 0 {
+		after := map[string]any{"imported": report.Imported, "failed": report.Failed, "mode": report.Mode}
+		if err := audit.Record(ctx, tx, opts.Source, "import", auditEntity, nil, nil, after); err != nil {
+			return report, err
+		}
+	}
+	if producer != nil && report.Imported > 0 {
+		msg := fmt.Sprintf("imported books: %d", report.Imported)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)}); err != nil {
+			return report, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// insertBatch вставляет пачку одним INSERT и возвращает ID вставленных книг.
+// Если пачка не прошла (например, нарушено ограничение БД), она
+// откатывается до savepoint и строки вставляются по одной, чтобы найти
+// виноватые.
+func insertBatch(ctx context.Context, tx db.TxDB, batch []importRow, report *ImportReport) ([]int, error) {
+	if _, err := tx.Exec(ctx, "SAVEPOINT import_batch"); err != nil {
+		return nil, err
+	}
+	if ids, err := insertRows(ctx, tx, batch); err == nil {
+		_, err := tx.Exec(ctx, "RELEASE SAVEPOINT import_batch")
+		return ids, err
+	}
+	if _, err := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT import_batch"); err != nil {
+		return nil, err
+	}
+	var inserted []int
+	for _, row := range batch {
+		if _, err := tx.Exec(ctx, "SAVEPOINT import_row"); err != nil {
+			return inserted, err
+		}
+		ids, err := insertRows(ctx, tx, []importRow{row})
+		if err != nil {
+			if isISBNConflict(err) {
+				err = fmt.Errorf("book with isbn %s already exists", *row.book.ISBN)
+			}
+			report.fail(row.num, err)
+			if _, err := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+				return inserted, err
+			}
+			if report.Mode == ImportAtomic {
+				return inserted, nil
+			}
+			continue
+		}
+		if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT import_row"); err != nil {
+			return inserted, err
+		}
+		inserted = append(inserted, ids...)
+	}
+	return inserted, nil
+}
+
+// insertRows вставляет строки одним INSERT ... RETURNING id
+func insertRows(ctx context.Context, tx db.TxDB, batch []importRow) ([]int, error) {
+	rows, err := tx.Query(ctx, batchInsertSQL(len(batch)), batchArgs(batch)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int, 0, len(batch))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func batchInsertSQL(n int) string {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO books (title, author, published_at, isbn, language) VALUES ")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "($%d, $%d, $%d::date, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5)
+	}
+	sb.WriteString(" RETURNING id")
+	return sb.String()
+}
+
+func batchArgs(batch []importRow) []any {
+	args := make([]any, 0, len(batch)*5)
+	for _, row := range batch {
+		args = append(args, row.book.Title, row.book.Author, row.book.PublishedAt, row.book.ISBN, row.book.Language)
+	}
+	return args
+}
+
+// DetectFormat определяет формат импорта по имени файла или Content-Type
+func DetectFormat(filename, contentType string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// @Summary Массовый импорт книг
+// @Tags books
+// @Accept mpfd
+// @Produce json
+// @Param file formData file true "CSV (title,author,published_at,isbn,language) или NDJSON"
+// @Param format query string false "csv или ndjson, по умолчанию по имени файла"
+// @Param mode query string false "atomic (по умолчанию) или best_effort"
+// @Param dry_run query bool false "только проверить, ничего не сохранять"
+// @Success 200 {object} ImportReport
+// @Failure 422 {object} ImportReport
+// @Router /api/v1/books/import [post]
+func ImportBooks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := ImportOptions{Format: q.Get("format"), Mode: q.Get("mode"), Source: audit.FromRequest(r)}
+	if v := q.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "dry_run must be a boolean", 400)
+			return
+		}
+		opts.DryRun = dryRun
+	}
+
+	var in io.Reader = r.Body
+	filename := ""
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				http.Error(w, "multipart field \"file\" is required", 400)
+				return
+			}
+			if part.FormName() == "file" {
+				in, filename, contentType = part, part.FileName(), part.Header.Get("Content-Type")
+				break
+			}
+		}
+	}
+	if opts.Format == "" {
+		opts.Format = DetectFormat(filename, contentType)
+	}
+	if opts.Format == "" {
+		http.Error(w, "cannot detect import format, use ?format=csv|ndjson", 400)
+		return
+	}
+
+	report, err := Import(r.Context(), dbi, in, opts)
+	if errors.Is(err, ErrInvalidImport) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	status := http.StatusOK
+	if report.Mode == ImportAtomic && report.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	render.Render(w, r, status, report)
+}
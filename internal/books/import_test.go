@@ -0,0 +1,160 @@
+package books
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+// importDB отклоняет любой INSERT, в аргументах которого есть "Duplicate",
+// выдаёт вставленным книгам ID по порядку и запоминает, каким книгам
+// достались авторы и первые ревизии
+type importDB struct {
+	mockDB
+	committed bool
+	inserts   int
+	linked    []int
+	revised   []int
+}
+
+func (m *importDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	if !strings.HasPrefix(sql, "INSERT INTO books") {
+		return m.mockDB.Query(ctx, sql, args...)
+	}
+	for _, a := range args {
+		if s, ok := a.(string); ok && s == "Duplicate" {
+			return nil, errors.New("duplicate key value")
+		}
+	}
+	rows := &idRows{}
+	for i := 0; i < len(args)/5; i++ {
+		m.inserts++
+		rows.ids = append(rows.ids, 100+m.inserts)
+	}
+	return rows, nil
+}
+func (m *importDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	switch {
+	case sql == linkLegacyAuthorsSQL:
+		m.linked = args[0].([]int)
+	case strings.HasPrefix(sql, "INSERT INTO book_revisions"):
+		m.revised = args[2].([]int)
+	}
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *importDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *importDB) Commit(ctx context.Context) error { m.committed = true; return nil }
+
+const importCSV = `title,author,published_at
+Book A,Author A,2020-01-02
+,No Title,
+Book C,Author C,not-a-date
+Duplicate,Author D,
+Book E,Author E,
+`
+
+func TestImportBestEffort(t *testing.T) {
+	m := &importDB{}
+	p := &testutil.Producer{}
+	SetProducer(p)
+	report, err := Import(context.Background(), m, strings.NewReader(importCSV), ImportOptions{Format: FormatCSV, Mode: ImportBestEffort})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Total != 5 || report.Imported != 2 || report.Failed != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	rows := []int{report.Errors[0].Row, report.Errors[1].Row, report.Errors[2].Row}
+	if rows[0] != 2 || rows[1] != 3 || rows[2] != 4 {
+		t.Fatalf("unexpected error rows: %v", rows)
+	}
+	if !m.committed {
+		t.Fatal("expected commit in best-effort mode")
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "imported books: 2" {
+		t.Fatalf("expected one summary event, got %v", p.Msgs)
+	}
+	// Book A и Book E; откатившаяся Duplicate в выборку не попадает
+	if fmt.Sprint(m.linked) != "[101 102]" || fmt.Sprint(m.revised) != "[101 102]" {
+		t.Fatalf("expected authors and revisions for imported books only, got %v and %v", m.linked, m.revised)
+	}
+}
+
+func TestImportAtomicRollsBackOnError(t *testing.T) {
+	m := &importDB{}
+	p := &testutil.Producer{}
+	SetProducer(p)
+	report, err := Import(context.Background(), m, strings.NewReader(importCSV), ImportOptions{Format: FormatCSV})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Mode != ImportAtomic || report.Imported != 0 || report.Failed == 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if m.committed || len(p.Msgs) != 0 {
+		t.Fatal("atomic import with errors must not commit or emit events")
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	m := &importDB{}
+	p := &testutil.Producer{}
+	SetProducer(p)
+	in := "{\"title\":\"A\",\"author\":\"X\"}\n{\"title\":\"B\",\"author\":\"Y\"}\n"
+	report, err := Import(context.Background(), m, strings.NewReader(in), ImportOptions{Format: FormatNDJSON, DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.DryRun || report.Imported != 2 || report.Failed != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if m.committed || len(p.Msgs) != 0 {
+		t.Fatal("dry run must not commit or emit events")
+	}
+}
+
+func TestImportInvalidInput(t *testing.T) {
+	_, err := Import(context.Background(), &importDB{}, strings.NewReader("name\nx\n"), ImportOptions{Format: FormatCSV})
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("expected ErrInvalidImport, got %v", err)
+	}
+}
+
+func TestImportBooksMultipart(t *testing.T) {
+	SetBookDB(&importDB{})
+	SetProducer(&mockProducer{})
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "catalog.csv")
+	fw.Write([]byte("title,author\nBook,Author\n"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books/import?mode=best_effort", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	ImportBooks(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report ImportReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if report.Imported != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
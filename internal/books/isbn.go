@@ -0,0 +1,112 @@
+package books
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/render"
+)
+
+var ErrInvalidISBN = errors.New("invalid isbn")
+
+// NormalizeISBN убирает дефисы и пробелы, проверяет контрольную цифру и
+// приводит ISBN-10 к ISBN-13 с префиксом 978
+func NormalizeISBN(s string) (string, error) {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '-' || r == ' ':
+		case r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		case r == 'x' || r == 'X':
+			sb.WriteRune('X')
+		default:
+			return "", ErrInvalidISBN
+		}
+	}
+	digits := sb.String()
+	switch len(digits) {
+	case 10:
+		if !validISBN10(digits) {
+			return "", ErrInvalidISBN
+		}
+		isbn := "978" + digits[:9]
+		return isbn + string(isbn13CheckDigit(isbn)), nil
+	case 13:
+		if strings.Contains(digits, "X") || !(strings.HasPrefix(digits, "978") || strings.HasPrefix(digits, "979")) {
+			return "", ErrInvalidISBN
+		}
+		if isbn13CheckDigit(digits[:12]) != digits[12] {
+			return "", ErrInvalidISBN
+		}
+		return digits, nil
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// validISBN10: взвешенная сумма 10..1 кратна 11, X допустим только последним
+func validISBN10(s string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var d int
+		switch {
+		case s[i] == 'X' && i == 9:
+			d = 10
+		case s[i] >= '0' && s[i] <= '9':
+			d = int(s[i] - '0')
+		default:
+			return false
+		}
+		sum += d * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// isbn13CheckDigit считает контрольную цифру по первым 12 цифрам
+func isbn13CheckDigit(s string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(s[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ISBNConflict — тело ответа 409 со ссылкой на уже существующую книгу
+type ISBNConflict struct {
+	Error      string `json:"error" xml:"error"`
+	ISBN       string `json:"isbn" xml:"isbn"`
+	ExistingID int    `json:"existing_id" xml:"existing_id"`
+	Existing   string `json:"existing" xml:"existing"`
+}
+
+func isISBNConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "books_isbn_key"
+}
+
+// writeISBNConflict отвечает 409 и указывает на книгу с тем же ISBN.
+// Транзакция запроса к этому моменту уже сломана, поэтому ищем через dbi.
+// Книга может лежать в корзине — тогда ссылка ведёт на её восстановление.
+func writeISBNConflict(w http.ResponseWriter, r *http.Request, isbn string) {
+	conflict := ISBNConflict{Error: "book with this isbn already exists", ISBN: isbn}
+	var deleted bool
+	row := dbi.QueryRow(r.Context(), "SELECT id, deleted_at IS NOT NULL FROM books WHERE isbn=$1", isbn)
+	if err := row.Scan(&conflict.ExistingID, &deleted); err == nil {
+		conflict.Existing = "/api/v1/books/" + strconv.Itoa(conflict.ExistingID)
+		if deleted {
+			conflict.Error = "book with this isbn is in the trash"
+			conflict.Existing += "/restore"
+		}
+		w.Header().Set("Location", conflict.Existing)
+	}
+	render.Render(w, r, http.StatusConflict, conflict)
+}
@@ -0,0 +1,107 @@
+package books
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"978-0-306-40615-7", "9780306406157", true},
+		{"0-306-40615-2", "9780306406157", true},
+		{"0 8044 2957 X", "9780804429573", true},
+		{"080442957x", "9780804429573", true},
+		{"979-10-90636-07-1", "9791090636071", true},
+		{"978-0-306-40615-8", "", false},
+		{"0-306-40615-3", "", false},
+		{"123-4567890123", "", false},
+		{"X804429570", "", false},
+		{"97803064061", "", false},
+		{"isbn 0306406152", "", false},
+	}
+	for _, c := range cases {
+		got, err := NormalizeISBN(c.in)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("NormalizeISBN(%q) = %q, %v; want %q ok=%v", c.in, got, err, c.want, c.ok)
+		}
+	}
+}
+
+// conflictDB имитирует нарушение уникального индекса по ISBN при вставке
+type conflictDB struct{ mockDB }
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(dest ...any) error { return r.err }
+
+func (m *conflictDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.HasPrefix(sql, "INSERT") {
+		return errRow{&pgconn.PgError{Code: "23505", ConstraintName: "books_isbn_key"}}
+	}
+	return &mockRow{}
+}
+func (m *conflictDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func TestCreateBookDuplicateISBN(t *testing.T) {
+	SetBookDB(&conflictDB{})
+	SetProducer(&mockProducer{})
+	body := []byte(`{"title":"T","author":"A","isbn":"0-306-40615-2"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	CreateBook(w, req)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	if loc := w.Header().Get("Location"); loc != "/api/v1/books/1" {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+	var conflict ISBNConflict
+	if err := json.NewDecoder(w.Body).Decode(&conflict); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if conflict.ISBN != "9780306406157" || conflict.ExistingID != 1 {
+		t.Fatalf("unexpected conflict: %+v", conflict)
+	}
+}
+
+func TestCreateBookInvalidISBN(t *testing.T) {
+	SetBookDB(&mockDB{})
+	body := []byte(`{"title":"T","author":"A","isbn":"0-306-40615-3"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	CreateBook(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	SetBookDB(&mockDB{})
+	for isbn, want := range map[string]int{"978-0-306-40615-7": http.StatusOK, "12345": http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/books/by-isbn/"+isbn, nil)
+		ctx := chi.NewRouteContext()
+		ctx.URLParams.Add("isbn", isbn)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+		w := httptest.NewRecorder()
+		GetBookByISBN(w, req)
+		if w.Code != want {
+			t.Fatalf("%s: expected %d, got %d", isbn, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+// Revision — сохранённое состояние книги после очередного изменения.
+// Actor, как и журнал изменений, виден только с правом audit:read.
+type Revision struct {
+	XMLName xml.Name  `json:"-" xml:"revision"`
+	Rev     int       `json:"rev" xml:"rev"`
+	At      time.Time `json:"at" xml:"at"`
+	Actor   string    `json:"actor,omitempty" xml:"actor,omitempty"`
+	Action  string    `json:"action" xml:"action"`
+	Book    Book      `json:"book" xml:"book"`
+}
+
+// RevisionDiff — различия между двумя ревизиями, только изменившиеся поля
+type RevisionDiff struct {
+	XMLName xml.Name        `json:"-" xml:"diff"`
+	From    int             `json:"from" xml:"from"`
+	To      int             `json:"to" xml:"to"`
+	Before  json.RawMessage `json:"before" xml:"before"`
+	After   json.RawMessage `json:"after" xml:"after"`
+}
+
+// bookSnapshotSQL — состояние книги в том же JSON, что отдаёт API
+const bookSnapshotSQL = `jsonb_build_object('id', books.id, 'title', books.title, 'author', books.author,
+	'published_at', books.published_at::text, 'isbn', books.isbn, 'language', books.language,
+	'deleted_at', books.deleted_at, 'authors', ` + authorsColumn + `, 'genres', ` + genresColumn + `)`
+
+// saveRevision записывает текущее состояние книги из БД следующей ревизией.
+// Строка книги к этому моменту уже заблокирована изменением, поэтому
+// номера ревизий не пересекаются.
+func saveRevision(ctx context.Context, tx db.TxDB, src audit.Source, action string, bookID int) (int, error) {
+	var rev int
+	err := tx.QueryRow(ctx, `INSERT INTO book_revisions (book_id, rev, actor, request_id, action, data)
+		SELECT books.id, COALESCE((SELECT max(rev) FROM book_revisions WHERE book_id = books.id), 0) + 1,
+			$2, NULLIF($3, ''), $4, `+bookSnapshotSQL+`
+		FROM books WHERE books.id = $1
+		RETURNING rev`, bookID, src.Actor, src.RequestID, action).Scan(&rev)
+	return rev, err
+}
+
+// RecordInitialRevisions создаёт первую ревизию книгам ids, вставленным в
+// обход обработчиков (массовый импорт, seed)
+func RecordInitialRevisions(ctx context.Context, tx db.TxDB, src audit.Source, ids []int) error {
+	_, err := tx.Exec(ctx, `INSERT INTO book_revisions (book_id, rev, actor, request_id, action, data)
+		SELECT books.id, 1, $1, NULLIF($2, ''), 'create', `+bookSnapshotSQL+`
+		FROM books WHERE books.id = ANY($3)
+			AND NOT EXISTS (SELECT 1 FROM book_revisions br WHERE br.book_id = books.id)`,
+		src.Actor, src.RequestID, ids)
+	return err
+}
+
+// recordChange пишет ревизию и запись журнала и отвечает 500 сам;
+// false — ответ уже отправлен
+func recordChange(ctx context.Context, w http.ResponseWriter, r *http.Request, tx db.TxDB, action string, before, after Book) bool {
+	src := audit.FromRequest(r)
+	if _, err := saveRevision(ctx, tx, src, action, after.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	var b any
+	if before.ID != 0 {
+		b = before
+	}
+	if err := audit.Record(ctx, tx, src, action, auditEntity, &after.ID, b, after); err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	return true
+}
+
+func scanRevision(row db.Row) (Revision, error) {
+	var rev Revision
+	var data []byte
+	if err := row.Scan(&rev.Rev, &rev.At, &rev.Actor, &rev.Action, &data); err != nil {
+		return rev, err
+	}
+	if len(data) == 0 {
+		return rev, nil
+	}
+	return rev, json.Unmarshal(data, &rev.Book)
+}
+
+const revisionColumns = "rev, at, actor, action, data"
+
+// parseRevRange разбирает ?diff=3..5
+func parseRevRange(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "..")
+	a, errA := strconv.Atoi(from)
+	b, errB := strconv.Atoi(to)
+	if !ok || errA != nil || errB != nil || a <= 0 || b <= 0 {
+		return 0, 0, errors.New("diff must look like 3..5")
+	}
+	return a, b, nil
+}
+
+// @Summary Ревизии книги
+// @Tags books
+// @Produce json
+// @Param id path int true "ID книги"
+// @Description Автор изменения (actor) виден только с правом audit:read.
+// @Param diff query string false "Сравнить две ревизии, например 3..5"
+// @Success 200 {array} Revision
+// @Success 200 {object} RevisionDiff "если передан diff"
+// @Failure 404 {string} string "нет такой ревизии"
+// @Router /api/v1/books/{id}/revisions [get]
+func ListRevisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if v := r.URL.Query().Get("diff"); v != "" {
+		from, to, err := parseRevRange(v)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		var revs [2]Revision
+		for i, n := range []int{from, to} {
+			if revs[i], err = scanRevision(dbi.QueryRow(ctx, "SELECT "+revisionColumns+" FROM book_revisions WHERE book_id=$1 AND rev=$2", id, n)); err != nil {
+				http.Error(w, fmt.Sprintf("revision %d not found", n), http.StatusNotFound)
+				return
+			}
+		}
+		before, after, err := audit.Diff(revs[0].Book, revs[1].Book)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		render.Render(w, r, http.StatusOK, RevisionDiff{From: from, To: to, Before: before, After: after})
+		return
+	}
+	rows, err := dbi.Query(ctx, "SELECT "+revisionColumns+" FROM book_revisions WHERE book_id=$1 ORDER BY rev DESC", id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	showActor := middleware.Can(r, middleware.PermAuditRead)
+	revisions := []Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !showActor {
+			rev.Actor = ""
+		}
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, revisions)
+}
+
+// @Summary Вернуть книгу к ревизии
+// @Description Поля, авторы и жанры книги берутся из ревизии rev, результат
+// @Description сохраняется новой ревизией.
+// @Tags books
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param rev path int true "Номер ревизии"
+// @Success 200 {object} Book
+// @Failure 404 {string} string "книга или ревизия не найдены"
+// @Failure 409 {object} ISBNConflict
+// @Router /api/v1/books/{id}/revisions/{rev}/revert [post]
+func RevertBook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	before, err := lockBook(ctx, tx, id, false)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	rev, err := scanRevision(tx.QueryRow(ctx, "SELECT "+revisionColumns+" FROM book_revisions WHERE book_id=$1 AND rev=$2", before.ID, chi.URLParam(r, "rev")))
+	if err != nil {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	b := rev.Book
+	b.ID = before.ID
+	if b.Genres == nil {
+		b.Genres = []BookGenre{}
+	}
+	if err := validateBook(&b); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE books SET title=$1, author=$2, published_at=$3::date, isbn=$4, language=$5 WHERE id=$6",
+		b.Title, b.Author, b.PublishedAt, b.ISBN, b.Language, b.ID); err != nil {
+		if isISBNConflict(err) {
+			writeISBNConflict(w, r, *b.ISBN)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !saveBookRelations(ctx, w, tx, &b) {
+		return
+	}
+	if b, err = scanBook(tx.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id=$1", b.ID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordChange(ctx, w, r, tx, "revert", before, b) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("updated book: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, b)
+}
@@ -0,0 +1,171 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// revisionRow отдаёт ревизию rev с книгой data
+type revisionRow struct {
+	rev  int
+	data string
+}
+
+func (r revisionRow) Scan(dest ...any) error {
+	*dest[0].(*int) = r.rev
+	*dest[1].(*time.Time) = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	*dest[2].(*string) = "alice"
+	*dest[3].(*string) = "update"
+	*dest[4].(*[]byte) = []byte(r.data)
+	return nil
+}
+
+// revisionDB хранит ревизии книги 1 и отвечает на остальное как mockDB
+type revisionDB struct {
+	auditDB
+	revisions map[int]string
+	updates   [][]any
+}
+
+func (m *revisionDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.HasPrefix(sql, "SELECT "+revisionColumns) {
+		rev := args[1]
+		if s, ok := rev.(string); ok {
+			rev = map[string]int{"3": 3, "5": 5}[s]
+		}
+		data, ok := m.revisions[rev.(int)]
+		if !ok {
+			return errRow{err: pgx.ErrNoRows}
+		}
+		return revisionRow{rev: rev.(int), data: data}
+	}
+	return m.auditDB.QueryRow(ctx, sql, args...)
+}
+func (m *revisionDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	if strings.HasPrefix(sql, "SELECT "+revisionColumns) {
+		at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		rows := &testutil.Rows{}
+		for rev, data := range m.revisions {
+			rows.Values = append(rows.Values, []any{rev, at, "alice", "update", []byte(data)})
+		}
+		return rows, nil
+	}
+	return m.auditDB.Query(ctx, sql, args...)
+}
+func (m *revisionDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.HasPrefix(sql, "UPDATE books SET title") {
+		m.updates = append(m.updates, args)
+	}
+	return m.auditDB.Exec(ctx, sql, args...)
+}
+func (m *revisionDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func revisionRequest(method, target, rev string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", "1")
+	ctx.URLParams.Add("rev", rev)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+}
+
+func TestParseRevRange(t *testing.T) {
+	if from, to, err := parseRevRange("3..5"); err != nil || from != 3 || to != 5 {
+		t.Fatalf("unexpected range: %d %d %v", from, to, err)
+	}
+	for _, bad := range []string{"3", "3..", "a..b", "0..2"} {
+		if _, _, err := parseRevRange(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestListRevisionsDiff(t *testing.T) {
+	SetBookDB(&revisionDB{revisions: map[int]string{
+		3: `{"id":1,"title":"War","author":"Tolstoy"}`,
+		5: `{"id":1,"title":"War and Peace","author":"Tolstoy","language":"ru"}`,
+	}})
+	w := httptest.NewRecorder()
+	ListRevisions(w, revisionRequest(http.MethodGet, "/api/v1/books/1/revisions?diff=3..5", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var diff RevisionDiff
+	if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if diff.From != 3 || diff.To != 5 || string(diff.Before) != `{"title":"War"}` || string(diff.After) != `{"language":"ru","title":"War and Peace"}` {
+		t.Fatalf("unexpected diff: %+v %s %s", diff, diff.Before, diff.After)
+	}
+}
+
+func TestListRevisionsDiffMissing(t *testing.T) {
+	SetBookDB(&revisionDB{revisions: map[int]string{3: `{"id":1}`}})
+	w := httptest.NewRecorder()
+	ListRevisions(w, revisionRequest(http.MethodGet, "/api/v1/books/1/revisions?diff=3..5", ""))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestListRevisionsActor(t *testing.T) {
+	SetBookDB(&revisionDB{revisions: map[int]string{3: `{"id":1,"title":"War"}`}})
+	for role, want := range map[string]string{middleware.RoleReader: "", middleware.RoleAdmin: "alice"} {
+		testutil.AsUser(t, "bob", role)
+		w := httptest.NewRecorder()
+		ListRevisions(w, revisionRequest(http.MethodGet, "/api/v1/books/1/revisions", ""))
+		var revs []Revision
+		if err := json.NewDecoder(w.Body).Decode(&revs); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if len(revs) != 1 || revs[0].Actor != want {
+			t.Fatalf("%s: expected actor %q, got %+v", role, want, revs)
+		}
+	}
+}
+
+func TestRevertBook(t *testing.T) {
+	database := &revisionDB{revisions: map[int]string{
+		3: `{"id":1,"title":"War","author":"Tolstoy","isbn":"9780306406157","genres":[]}`,
+	}}
+	SetBookDB(database)
+	producer := &testutil.Producer{}
+	SetProducer(producer)
+	w := httptest.NewRecorder()
+	RevertBook(w, revisionRequest(http.MethodPost, "/api/v1/books/1/revisions/3/revert", "3"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(database.updates) != 1 || database.updates[0][0] != "War" {
+		t.Fatalf("unexpected updates: %v", database.updates)
+	}
+	if len(database.entries) != 1 || database.entries[0][3] != "revert" {
+		t.Fatalf("unexpected audit entries: %v", database.entries)
+	}
+	if len(producer.Msgs) != 1 || producer.Msgs[0] != "updated book: 1" {
+		t.Fatalf("unexpected events: %v", producer.Msgs)
+	}
+}
+
+func TestRevertBookUnknownRevision(t *testing.T) {
+	SetBookDB(&revisionDB{})
+	w := httptest.NewRecorder()
+	RevertBook(w, revisionRequest(http.MethodPost, "/api/v1/books/1/revisions/9/revert", "9"))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
@@ -1,6 +1,10 @@
 package books
 
-import "github.com/go-chi/chi/v5"
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
 
 // RegisterRoutes регистрирует роуты для книг
 func RegisterRoutes(r chi.Router) {
@@ -10,7 +14,7 @@ func RegisterRoutes(r chi.Router) {
 		// @Produce json
 		// @Success 200 {array} Book
 		// @Router /books [get]
-		r.Get("/", ListBooks)
+		r.With(middleware.Require(middleware.PermRead)).Get("/", ListBooks)
 
 		// @Summary Создать книгу
 		// @Tags books
@@ -19,7 +23,39 @@ func RegisterRoutes(r chi.Router) {
 		// @Param book body Book true "Книга"
 		// @Success 201 {object} Book
 		// @Router /books [post]
-		r.Post("/", CreateBook)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/", CreateBook)
+
+		// @Summary Массовый импорт книг из CSV или NDJSON
+		// @Tags books
+		// @Accept mpfd
+		// @Produce json
+		// @Param file formData file true "Файл с книгами"
+		// @Success 200 {object} ImportReport
+		// @Router /books/import [post]
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/import", ImportBooks)
+
+		// @Summary Потоковая выгрузка каталога
+		// @Tags books
+		// @Produce json
+		// @Param format query string false "ndjson, csv или json"
+		// @Success 200 {array} Book
+		// @Router /books/export [get]
+		r.With(middleware.Require(middleware.PermRead)).Get("/export", ExportBooks)
+
+		// @Summary Корзина
+		// @Tags books
+		// @Produce json
+		// @Success 200 {array} Book
+		// @Router /books/trash [get]
+		r.With(middleware.Require(middleware.PermBooksDelete)).Get("/trash", ListTrash)
+
+		// @Summary Очистить корзину
+		// @Tags books
+		// @Produce json
+		// @Param older_than query string false "Срок хранения"
+		// @Success 200 {object} PurgeReport
+		// @Router /books/trash/purge [post]
+		r.With(middleware.Require(middleware.PermTrashPurge)).Post("/trash/purge", PurgeTrash)
 
 		// @Summary Получить книгу
 		// @Tags books
@@ -27,7 +63,15 @@ func RegisterRoutes(r chi.Router) {
 		// @Param id path int true "ID книги"
 		// @Success 200 {object} Book
 		// @Router /books/{id} [get]
-		r.Get("/{id}", GetBook)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}", GetBook)
+
+		// @Summary Найти книгу по ISBN
+		// @Tags books
+		// @Produce json
+		// @Param isbn path string true "ISBN"
+		// @Success 200 {object} Book
+		// @Router /books/by-isbn/{isbn} [get]
+		r.With(middleware.Require(middleware.PermRead)).Get("/by-isbn/{isbn}", GetBookByISBN)
 
 		// @Summary Обновить книгу
 		// @Tags books
@@ -37,13 +81,47 @@ func RegisterRoutes(r chi.Router) {
 		// @Param book body Book true "Книга"
 		// @Success 200 {object} Book
 		// @Router /books/{id} [put]
-		r.Put("/{id}", UpdateBook)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Put("/{id}", UpdateBook)
 
 		// @Summary Удалить книгу
 		// @Tags books
 		// @Param id path int true "ID книги"
 		// @Success 204
 		// @Router /books/{id} [delete]
-		r.Delete("/{id}", DeleteBook)
+		r.With(middleware.Require(middleware.PermBooksDelete)).Delete("/{id}", DeleteBook)
+
+		// @Summary Восстановить книгу из корзины
+		// @Tags books
+		// @Produce json
+		// @Param id path int true "ID книги"
+		// @Success 200 {object} Book
+		// @Router /books/{id}/restore [post]
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/{id}/restore", RestoreBook)
+
+		// @Summary История изменений книги
+		// @Tags books
+		// @Produce json
+		// @Param id path int true "ID книги"
+		// @Success 200 {array} audit.Entry
+		// @Router /books/{id}/history [get]
+		r.With(middleware.Require(middleware.PermAuditRead)).Get("/{id}/history", BookHistory)
+
+		// @Summary Ревизии книги
+		// @Tags books
+		// @Produce json
+		// @Param id path int true "ID книги"
+		// @Param diff query string false "Например 3..5"
+		// @Success 200 {array} Revision
+		// @Router /books/{id}/revisions [get]
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}/revisions", ListRevisions)
+
+		// @Summary Вернуть книгу к ревизии
+		// @Tags books
+		// @Produce json
+		// @Param id path int true "ID книги"
+		// @Param rev path int true "Номер ревизии"
+		// @Success 200 {object} Book
+		// @Router /books/{id}/revisions/{rev}/revert [post]
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/{id}/revisions/{rev}/revert", RevertBook)
 	})
 }
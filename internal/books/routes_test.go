@@ -0,0 +1,41 @@
+package books
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+func TestRoutePermissions(t *testing.T) {
+	SetBookDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	r := chi.NewRouter()
+	RegisterRoutes(r)
+	defer middleware.SetPolicy(nil)
+
+	cases := []struct {
+		role, method, path string
+		want               int
+	}{
+		{middleware.RoleReader, http.MethodGet, "/books/1", http.StatusOK},
+		{middleware.RoleReader, http.MethodPut, "/books/1", http.StatusForbidden},
+		{middleware.RoleEditor, http.MethodDelete, "/books/1", http.StatusForbidden},
+		{middleware.RoleEditor, http.MethodPost, "/books/trash/purge", http.StatusForbidden},
+		{middleware.RoleEditor, http.MethodGet, "/books/trash", http.StatusForbidden},
+		{middleware.RoleReader, http.MethodGet, "/books/1/history", http.StatusForbidden},
+		{middleware.RoleAdmin, http.MethodGet, "/books/trash", http.StatusOK},
+		{middleware.RoleAdmin, http.MethodDelete, "/books/1", http.StatusNoContent},
+	}
+	for _, tc := range cases {
+		middleware.SetPolicy(&middleware.Policy{Extract: middleware.StubIdentity(middleware.Identity{Subject: "u", Roles: []string{tc.role}})})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s %s %s: expected %d, got %d", tc.role, tc.method, tc.path, tc.want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package books
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+// DefaultTrashRetention — сколько книга лежит в корзине до окончательного удаления
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+var trashRetention = DefaultTrashRetention
+
+// SetTrashRetention задаёт срок хранения для PurgeTrash без ?older_than
+func SetTrashRetention(d time.Duration) {
+	trashRetention = d
+}
+
+// PurgeReport — результат очистки корзины
+type PurgeReport struct {
+	XMLName   xml.Name `json:"-" xml:"purge"`
+	Purged    int      `json:"purged" xml:"purged"`
+	OlderThan string   `json:"older_than" xml:"older_than"`
+}
+
+// Purge окончательно удаляет книги, пролежавшие в корзине дольше olderThan.
+// Вместе с ними каскадно уходят связи с авторами, жанрами и подборками.
+// Книги, отложенные под незакрытые заказы (pending и paid), остаются в
+// корзине до отмены или отгрузки: иначе каскадом ушли бы их остатки на
+// складах, и заказ стало бы нечем отменить или отгрузить.
+// В журнал пишется одна запись со списком удалённых ID.
+func Purge(ctx context.Context, database db.TxDB, olderThan time.Duration, src audit.Source) (int, error) {
+	tx, err := database.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	rows, err := tx.Query(ctx, `DELETE FROM books b WHERE deleted_at < now() - make_interval(secs => $1)
+		AND NOT EXISTS (SELECT 1 FROM order_reservations r JOIN orders o ON o.id = r.order_id
+			WHERE r.book_id = b.id AND o.status IN ('pending', 'paid'))
+		RETURNING id`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	n := len(ids)
+	if n == 0 {
+		return 0, nil
+	}
+	// связи с подборками удалились каскадом; сжимаем позиции, чтобы в
+	// подборках снова было 1..n без дыр
+	if _, err := tx.Exec(ctx, `UPDATE collection_books cb SET position = o.pos
+		FROM (SELECT collection_id, book_id, row_number() OVER (PARTITION BY collection_id ORDER BY position) AS pos FROM collection_books) o
+		WHERE cb.collection_id = o.collection_id AND cb.book_id = o.book_id AND cb.position <> o.pos`); err != nil {
+		return 0, err
+	}
+	after := map[string]any{"ids": ids, "older_than": olderThan.String()}
+	if err := audit.Record(ctx, tx, src, "purge", auditEntity, nil, nil, after); err != nil {
+		return 0, err
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("purged books: " + strconv.Itoa(n))}); err != nil {
+			return 0, err
+		}
+	}
+	return n, tx.Commit(ctx)
+}
+
+// @Summary Корзина: удалённые книги
+// @Tags books
+// @Produce json
+// @Success 200 {array} Book
+// @Router /api/v1/books/trash [get]
+func ListTrash(w http.ResponseWriter, r *http.Request) {
+	where, args := BookFilter{Deleted: true}.where(nil)
+	rows, err := dbi.Query(r.Context(), "SELECT "+bookColumns+" FROM books"+where+" ORDER BY deleted_at DESC, id", args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	books := []Book{}
+	for rows.Next() {
+		b, err := scanBook(rows)
+		if err != nil {
+			continue
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, books)
+}
+
+// @Summary Восстановить книгу из корзины
+// @Description Связи с подборками при удалении не трогаются, поэтому книга
+// @Description возвращается во все подборки, где была.
+// @Tags books
+// @Produce json
+// @Param id path int true "ID книги"
+// @Success 200 {object} Book
+// @Failure 404 {string} string "в корзине нет такой книги"
+// @Router /api/v1/books/{id}/restore [post]
+func RestoreBook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	before, err := lockBook(ctx, tx, id, true)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE books SET deleted_at=NULL WHERE id=$1", before.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	b, err := scanBook(tx.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id=$1", before.ID))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordChange(ctx, w, r, tx, "restore", before, b) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("restored book: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, b)
+}
+
+// @Summary Очистить корзину
+// @Tags books
+// @Produce json
+// @Param older_than query string false "Срок хранения, например 720h; по умолчанию из BOOKS_TRASH_RETENTION"
+// @Success 200 {object} PurgeReport
+// @Router /api/v1/books/trash/purge [post]
+func PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	olderThan := trashRetention
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "older_than must be a non-negative duration", 400)
+			return
+		}
+		olderThan = d
+	}
+	n, err := Purge(r.Context(), dbi, olderThan, audit.FromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, PurgeReport{Purged: n, OlderThan: olderThan.String()})
+}
@@ -0,0 +1,145 @@
+package books
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+// idRows отдаёт заданные ID по одному в колонке
+type idRows struct {
+	idx int
+	ids []int
+}
+
+func (r *idRows) Next() bool { r.idx++; return r.idx <= len(r.ids) }
+func (r *idRows) Scan(dest ...any) error {
+	*dest[0].(*int) = r.ids[r.idx-1]
+	return nil
+}
+func (r *idRows) Close()     {}
+func (r *idRows) Err() error { return nil }
+
+// purgeDB отвечает на DELETE заданными ID и запоминает записи журнала
+type purgeDB struct {
+	mockDB
+	deleted []int
+	sql     string
+	args    []any
+	audit   []any
+}
+
+func (m *purgeDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	if strings.HasPrefix(sql, "DELETE FROM books") {
+		m.sql, m.args = sql, args
+		return &idRows{ids: m.deleted}, nil
+	}
+	return m.mockDB.Query(ctx, sql, args...)
+}
+
+func (m *purgeDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	if strings.HasPrefix(sql, "INSERT INTO audit_log") {
+		m.audit = args
+	}
+	return m.mockDB.Exec(ctx, sql, args...)
+}
+
+func (m *purgeDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func TestBookFilterExcludesDeleted(t *testing.T) {
+	where, _ := BookFilter{}.where(nil)
+	if where != " WHERE deleted_at IS NULL" {
+		t.Fatalf("unexpected where: %q", where)
+	}
+	where, _ = BookFilter{Deleted: true, Title: "war"}.where(nil)
+	if !strings.HasPrefix(where, " WHERE deleted_at IS NOT NULL AND title") {
+		t.Fatalf("unexpected where: %q", where)
+	}
+}
+
+func TestListTrash(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/books/trash", nil)
+	w := httptest.NewRecorder()
+	ListTrash(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var books []Book
+	if err := json.NewDecoder(w.Body).Decode(&books); err != nil || len(books) != 1 {
+		t.Fatalf("unexpected body: %v %+v", err, books)
+	}
+}
+
+func TestRestoreBook(t *testing.T) {
+	SetBookDB(&mockDB{})
+	producer := &testutil.Producer{}
+	SetProducer(producer)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books/1/restore", nil)
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+	w := httptest.NewRecorder()
+	RestoreBook(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if len(producer.Msgs) != 1 || producer.Msgs[0] != "restored book: 1" {
+		t.Fatalf("unexpected events: %v", producer.Msgs)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	database := &purgeDB{deleted: []int{4, 5, 6}}
+	SetBookDB(database)
+	producer := &testutil.Producer{}
+	SetProducer(producer)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books/trash/purge?older_than=24h", nil)
+	w := httptest.NewRecorder()
+	PurgeTrash(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var report PurgeReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if report.Purged != 3 || report.OlderThan != "24h0m0s" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(database.args) != 1 || database.args[0] != (24*time.Hour).Seconds() {
+		t.Fatalf("unexpected args: %v", database.args)
+	}
+	if !strings.Contains(database.sql, "o.status IN ('pending', 'paid')") {
+		t.Fatalf("purge must keep books reserved for open orders: %s", database.sql)
+	}
+	if len(producer.Msgs) != 1 || producer.Msgs[0] != "purged books: 3" {
+		t.Fatalf("unexpected events: %v", producer.Msgs)
+	}
+	if len(database.audit) != 8 || database.audit[3] != "purge" || !strings.Contains(string(database.audit[7].(json.RawMessage)), `"ids":[4,5,6]`) {
+		t.Fatalf("unexpected audit entry: %v", database.audit)
+	}
+}
+
+func TestPurgeTrashBadDuration(t *testing.T) {
+	SetBookDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books/trash/purge?older_than=month", nil)
+	w := httptest.NewRecorder()
+	PurgeTrash(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,187 @@
+package carts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+var created = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// mockDB — корзина 5 пользователя alice в EUR с двумя экземплярами книги 1;
+// книга стоит 12.99, свободно free экземпляров
+type mockDB struct {
+	testutil.Tx
+	testutil.Statements
+	free    int
+	noCart  bool
+	noBook  bool
+	noPrice bool
+	missing bool
+	args    [][]any
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	switch {
+	case strings.HasPrefix(sql, "SELECT ci.book_id"):
+		return &testutil.Rows{Values: [][]any{{1, "Dune", 2}}}, nil
+	case strings.HasPrefix(sql, "SELECT i.location"):
+		if m.noBook {
+			return &testutil.Rows{}, nil
+		}
+		loc, q, res := "main", m.free, 0
+		return &testutil.Rows{Values: [][]any{{&loc, &q, &res}}}, nil
+	}
+	return &testutil.Rows{}, nil
+}
+
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.args = append(m.args, args)
+	switch {
+	case strings.HasPrefix(sql, "SELECT id, user_id, currency"):
+		if m.noCart {
+			return testutil.Row{Err: pgx.ErrNoRows}
+		}
+		return testutil.Row{Values: []any{5, "alice", "EUR", created, created}}
+	case strings.HasPrefix(sql, "SELECT id, book_id, currency, amount"):
+		if m.noPrice {
+			return testutil.Row{Err: pgx.ErrNoRows}
+		}
+		return testutil.Row{Values: []any{int64(3), 1, "EUR", int64(1299), created}}
+	case strings.HasPrefix(sql, "INSERT INTO carts"):
+		return testutil.Row{Values: []any{6, created, created}}
+	}
+	return testutil.Row{}
+}
+
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.Record(sql, args)
+	if m.missing {
+		return pgconn.NewCommandTag("DELETE 0"), nil
+	}
+	return pgconn.NewCommandTag("MOCK 1"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+var serve = testutil.Server(RegisterRoutes)
+
+func TestCreateCart(t *testing.T) {
+	m := &mockDB{}
+	SetCartDB(m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve("POST", "/carts", `{"currency": "usd"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var c Cart
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != 6 || c.Currency != "USD" || c.UserID != "alice" || c.Total != "0.00" {
+		t.Errorf("cart = %+v", c)
+	}
+	if w := serve("POST", "/carts", ""); w.Code != http.StatusCreated || m.args[1][1] != DefaultCurrency {
+		t.Errorf("default currency: status = %d, args = %v", w.Code, m.args[1])
+	}
+	if w := serve("POST", "/carts", `{"currency": "euro"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bad currency: status = %d", w.Code)
+	}
+}
+
+func TestCartAnonymous(t *testing.T) {
+	SetCartDB(&mockDB{})
+	if w := serve("POST", "/carts", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d", w.Code)
+	}
+}
+
+func TestGetCart(t *testing.T) {
+	m := &mockDB{free: 4}
+	SetCartDB(m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve("GET", "/carts/5", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var c Cart
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Total != "25.98" || len(c.Items) != 1 || c.Items[0].UnitPrice != "12.99" || c.Items[0].Available != 4 {
+		t.Errorf("cart = %+v", c)
+	}
+	// чужая корзина ищется с user_id вызывающего и не находится
+	m.noCart = true
+	if w := serve("GET", "/carts/5", ""); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d", w.Code)
+	}
+	if m.args[len(m.args)-1][1] != "alice" {
+		t.Errorf("args = %v", m.args[len(m.args)-1])
+	}
+}
+
+func TestPutItem(t *testing.T) {
+	m := &mockDB{free: 3}
+	SetCartDB(m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve("PUT", "/carts/5/items/1", `{"quantity": 2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if m.Commits != 1 || m.Count("INSERT INTO cart_items") != 1 {
+		t.Errorf("commits = %d, execs = %v", m.Commits, m.SQL)
+	}
+}
+
+func TestPutItemRejected(t *testing.T) {
+	cases := map[string]struct {
+		m    *mockDB
+		body string
+		code int
+	}{
+		"not enough": {&mockDB{free: 1}, `{"quantity": 2}`, http.StatusConflict},
+		"no price":   {&mockDB{free: 3, noPrice: true}, `{"quantity": 2}`, http.StatusConflict},
+		"no book":    {&mockDB{noBook: true}, `{"quantity": 2}`, http.StatusNotFound},
+		"no cart":    {&mockDB{free: 3, noCart: true}, `{"quantity": 2}`, http.StatusNotFound},
+		"zero":       {&mockDB{free: 3}, `{"quantity": 0}`, http.StatusBadRequest},
+		"too many":   {&mockDB{free: 300}, `{"quantity": 101}`, http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		SetCartDB(c.m)
+		testutil.AsUser(t, "alice", middleware.RoleReader)
+		if w := serve("PUT", "/carts/5/items/1", c.body); w.Code != c.code {
+			t.Errorf("%s: status = %d, body = %s", name, w.Code, w.Body.String())
+		}
+		if len(c.m.SQL) != 0 || c.m.Commits != 0 {
+			t.Errorf("%s: execs = %v, commits = %d", name, c.m.SQL, c.m.Commits)
+		}
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	m := &mockDB{}
+	SetCartDB(m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve("DELETE", "/carts/5/items/1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("status = %d", w.Code)
+	}
+	if w := serve("DELETE", "/carts/5", ""); w.Code != http.StatusNoContent {
+		t.Errorf("cart: status = %d", w.Code)
+	}
+	m.missing = true
+	if w := serve("DELETE", "/carts/5/items/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,378 @@
+package carts
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/inventory"
+	"books-api/internal/middleware"
+	"books-api/internal/pricing"
+	"books-api/internal/render"
+)
+
+var dbi db.TxDB
+
+func SetCartDB(database db.TxDB) {
+	dbi = database
+}
+
+// DefaultCurrency — валюта корзины, если она не указана
+const DefaultCurrency = "EUR"
+
+// maxQuantity — предел экземпляров одной книги в корзине
+const maxQuantity = 100
+
+// ErrNotFound — корзины нет или она чужая
+var ErrNotFound = errors.New("cart not found")
+
+// Item — книга в корзине. Цена и наличие — текущие, в корзине они не
+// хранятся и фиксируются только при оформлении заказа.
+type Item struct {
+	XMLName        xml.Name `json:"-" xml:"item"`
+	BookID         int      `json:"book_id" xml:"book_id"`
+	Title          string   `json:"title" xml:"title"`
+	Quantity       int      `json:"quantity" xml:"quantity"`
+	UnitPrice      string   `json:"unit_price,omitempty" xml:"unit_price,omitempty"`
+	UnitPriceMinor int64    `json:"unit_price_minor,omitempty" xml:"unit_price_minor,omitempty"`
+	Available      int      `json:"available" xml:"available"`
+}
+
+// Cart — корзина покупателя
+type Cart struct {
+	XMLName    xml.Name  `json:"-" xml:"cart"`
+	ID         int       `json:"id" xml:"id"`
+	UserID     string    `json:"user_id" xml:"user_id"`
+	Currency   string    `json:"currency" xml:"currency"`
+	Items      []Item    `json:"items" xml:"items>item"`
+	Total      string    `json:"total" xml:"total"`
+	TotalMinor int64     `json:"total_minor" xml:"total_minor"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+// Load читает корзину owner с позициями по порядку book_id; книги из
+// корзины удалённых пропускаются. lock блокирует корзину до конца транзакции.
+func Load(ctx context.Context, q db.TxDB, id int, owner string, lock bool) (Cart, error) {
+	c := Cart{Items: []Item{}}
+	sql := "SELECT id, user_id, currency, created_at, updated_at FROM carts WHERE id=$1 AND user_id=$2"
+	if lock {
+		sql += " FOR UPDATE"
+	}
+	err := q.QueryRow(ctx, sql, id, owner).Scan(&c.ID, &c.UserID, &c.Currency, &c.CreatedAt, &c.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+	rows, err := q.Query(ctx, `SELECT ci.book_id, b.title, ci.quantity FROM cart_items ci
+		JOIN books b ON b.id = ci.book_id AND b.deleted_at IS NULL
+		WHERE ci.cart_id = $1 ORDER BY ci.book_id`, id)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.BookID, &it.Title, &it.Quantity); err != nil {
+			return c, err
+		}
+		c.Items = append(c.Items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// price дополняет позиции текущими ценами и наличием и считает итог;
+// позиции без цены в валюте корзины в итог не входят
+func price(ctx context.Context, q db.TxDB, c *Cart) error {
+	at := time.Now()
+	c.TotalMinor = 0
+	for i := range c.Items {
+		it := &c.Items[i]
+		p, err := pricing.Current(ctx, q, it.BookID, c.Currency, at)
+		switch {
+		case err == nil:
+			it.UnitPrice, it.UnitPriceMinor = p.Amount, p.AmountMinor
+			c.TotalMinor += p.AmountMinor * int64(it.Quantity)
+		case !errors.Is(err, pricing.ErrNoPrice):
+			return err
+		}
+		a, err := inventory.Available(ctx, q, it.BookID)
+		if err != nil {
+			return err
+		}
+		it.Available = a.Available
+	}
+	c.Total = pricing.FormatAmount(c.TotalMinor, c.Currency)
+	return nil
+}
+
+// owner возвращает пользователя запроса; у анонимного корзины нет — 401
+func owner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := middleware.Caller(r)
+	if !ok || id.Subject == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		render.WriteProblem(w, r, http.StatusUnauthorized, "carts are tied to a user, authentication required")
+		return "", false
+	}
+	return id.Subject, true
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+// cartInput — валюта новой корзины
+type cartInput struct {
+	Currency string `json:"currency"`
+}
+
+// @Summary Создать корзину
+// @Description Пустая корзина текущего пользователя в указанной валюте
+// @Tags carts
+// @Accept json
+// @Produce json
+// @Param cart body cartInput false "Валюта, по умолчанию EUR"
+// @Success 201 {object} Cart
+// @Router /api/v1/carts [post]
+func CreateCart(w http.ResponseWriter, r *http.Request) {
+	user, ok := owner(w, r)
+	if !ok {
+		return
+	}
+	var in cartInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+	c := Cart{UserID: user, Currency: DefaultCurrency, Items: []Item{}}
+	if in.Currency != "" {
+		currency, err := pricing.NormalizeCurrency(in.Currency)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		c.Currency = currency
+	}
+	err := dbi.QueryRow(r.Context(), "INSERT INTO carts (user_id, currency) VALUES ($1, $2) RETURNING id, created_at, updated_at",
+		c.UserID, c.Currency).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	c.Total = pricing.FormatAmount(0, c.Currency)
+	render.Render(w, r, http.StatusCreated, c)
+}
+
+// @Summary Корзина
+// @Description Позиции с текущими ценами, наличием и итогом
+// @Tags carts
+// @Produce json
+// @Param id path int true "ID корзины"
+// @Success 200 {object} Cart
+// @Failure 404 {string} string "корзины нет"
+// @Router /api/v1/carts/{id} [get]
+func GetCart(w http.ResponseWriter, r *http.Request) {
+	user, ok := owner(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	c, err := Load(r.Context(), dbi, id, user, false)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err == nil {
+		err = price(r.Context(), dbi, &c)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, c)
+}
+
+// quantityInput — сколько экземпляров книги положить в корзину
+type quantityInput struct {
+	Quantity int `json:"quantity"`
+}
+
+// @Summary Положить книгу в корзину
+// @Description Задаёт количество экземпляров книги. Книга должна быть в
+// @Description наличии в нужном количестве и иметь цену в валюте корзины.
+// @Tags carts
+// @Accept json
+// @Produce json
+// @Param id path int true "ID корзины"
+// @Param book_id path int true "ID книги"
+// @Param item body quantityInput true "Количество"
+// @Success 200 {object} Cart
+// @Failure 409 {string} string "нет в наличии или нет цены"
+// @Router /api/v1/carts/{id}/items/{book_id} [put]
+func PutItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := owner(w, r)
+	if !ok {
+		return
+	}
+	id, err1 := strconv.Atoi(chi.URLParam(r, "id"))
+	bookID, err2 := strconv.Atoi(chi.URLParam(r, "book_id"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	var in quantityInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if in.Quantity < 1 || in.Quantity > maxQuantity {
+		http.Error(w, fmt.Sprintf("quantity must be between 1 and %d", maxQuantity), 400)
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	c, err := Load(ctx, tx, id, user, true)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	a, err := inventory.Available(ctx, tx, bookID)
+	if errors.Is(err, inventory.ErrBookNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if a.Available < in.Quantity {
+		http.Error(w, fmt.Sprintf("only %d copies of book %d available", a.Available, bookID), 409)
+		return
+	}
+	if _, err := pricing.Current(ctx, tx, bookID, c.Currency, time.Now()); errors.Is(err, pricing.ErrNoPrice) {
+		http.Error(w, fmt.Sprintf("book %d has no %s price", bookID, c.Currency), 409)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO cart_items (cart_id, book_id, quantity) VALUES ($1, $2, $3)
+		ON CONFLICT (cart_id, book_id) DO UPDATE SET quantity = EXCLUDED.quantity`, id, bookID, in.Quantity); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE carts SET updated_at = now() WHERE id=$1", id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if c, err = Load(ctx, tx, id, user, false); err == nil {
+		err = price(ctx, tx, &c)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, c)
+}
+
+// @Summary Убрать книгу из корзины
+// @Tags carts
+// @Param id path int true "ID корзины"
+// @Param book_id path int true "ID книги"
+// @Success 204 {string} string "Книга убрана"
+// @Failure 404 {string} string "корзины или книги в ней нет"
+// @Router /api/v1/carts/{id}/items/{book_id} [delete]
+func DeleteItem(w http.ResponseWriter, r *http.Request) {
+	user, ok := owner(w, r)
+	if !ok {
+		return
+	}
+	id, err1 := strconv.Atoi(chi.URLParam(r, "id"))
+	bookID, err2 := strconv.Atoi(chi.URLParam(r, "book_id"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	tag, err := dbi.Exec(r.Context(), `DELETE FROM cart_items ci USING carts c
+		WHERE ci.cart_id = c.id AND c.id = $1 AND c.user_id = $2 AND ci.book_id = $3`, id, user, bookID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, "не найдено", 404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// @Summary Удалить корзину
+// @Tags carts
+// @Param id path int true "ID корзины"
+// @Success 204 {string} string "Корзина удалена"
+// @Failure 404 {string} string "корзины нет"
+// @Router /api/v1/carts/{id} [delete]
+func DeleteCart(w http.ResponseWriter, r *http.Request) {
+	user, ok := owner(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	tag, err := dbi.Exec(r.Context(), "DELETE FROM carts WHERE id=$1 AND user_id=$2", id, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		http.Error(w, ErrNotFound.Error(), 404)
+		return
+	}
+	w.WriteHeader(204)
+}
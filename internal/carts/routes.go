@@ -0,0 +1,18 @@
+package carts
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты корзин; корзина видна только владельцу
+func RegisterRoutes(r chi.Router) {
+	r.Route("/carts", func(r chi.Router) {
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Post("/", CreateCart)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Get("/{id}", GetCart)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Delete("/{id}", DeleteCart)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Put("/{id}/items/{book_id}", PutItem)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Delete("/{id}/items/{book_id}", DeleteItem)
+	})
+}
@@ -0,0 +1,125 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+// Видимость подборки: private — только владелец и участники, unlisted —
+// любой по ссылке, но не в списке, public — всем
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+func validVisibility(v string) bool {
+	return v == VisibilityPrivate || v == VisibilityUnlisted || v == VisibilityPublic
+}
+
+// Роли участников подборки
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+)
+
+// Viewer — кто смотрит подборки. Admin видит и меняет всё.
+type Viewer struct {
+	Subject string
+	Admin   bool
+}
+
+// viewerFrom определяет Viewer по Identity запроса из политики доступа
+func viewerFrom(r *http.Request) Viewer {
+	id, _ := middleware.Caller(r)
+	return Viewer{Subject: id.Subject, Admin: middleware.Can(r, middleware.PermCollectionsAdmin)}
+}
+
+// listCond — условие на подборки c, которые показываются v в списках
+func (v Viewer) listCond(args []any) (string, []any) {
+	if v.Admin {
+		return "TRUE", args
+	}
+	if v.Subject == "" {
+		return "c.visibility = 'public'", args
+	}
+	args = append(args, v.Subject)
+	n := len(args)
+	return fmt.Sprintf(`(c.visibility = 'public' OR c.owner_id = $%d
+		OR EXISTS (SELECT 1 FROM collection_members m WHERE m.collection_id = c.id AND m.member_id = $%d))`, n, n), args
+}
+
+// access — что вызывающему можно делать с конкретной подборкой
+type access int
+
+const (
+	accessNone access = iota
+	accessView
+	accessEdit
+	accessOwner
+)
+
+// loadAccess читает владельца, видимость и роль v в подборке. Строка
+// подборки блокируется FOR SHARE, чтобы права не поменялись до конца
+// транзакции. found=false — подборки нет.
+func loadAccess(ctx context.Context, q db.TxDB, id string, v Viewer) (a access, found bool, err error) {
+	var collectionID int
+	var owner, role *string
+	var visibility string
+	err = q.QueryRow(ctx, `SELECT c.id, c.owner_id, c.visibility, m.role FROM collections c
+		LEFT JOIN collection_members m ON m.collection_id = c.id AND m.member_id = $2
+		WHERE c.id = $1 FOR SHARE OF c`, id, v.Subject).Scan(&collectionID, &owner, &visibility, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return accessNone, false, nil
+	}
+	if err != nil {
+		return accessNone, false, err
+	}
+	switch {
+	case v.Admin:
+		return accessOwner, true, nil
+	case owner == nil:
+		// подборки без владельца остались с прежних времён: их видят все,
+		// а меняет только admin, пока он не назначит владельца
+		return accessView, true, nil
+	case v.Subject != "" && *owner == v.Subject:
+		return accessOwner, true, nil
+	case role != nil && *role == RoleEditor:
+		return accessEdit, true, nil
+	case role != nil || visibility != VisibilityPrivate:
+		return accessView, true, nil
+	}
+	return accessNone, true, nil
+}
+
+// authorize проверяет, что у вызывающего есть хотя бы need, и отвечает сам:
+// 404, если подборку не видно, 403, если видно, но прав мало. false —
+// ответ уже отправлен.
+func authorize(ctx context.Context, w http.ResponseWriter, r *http.Request, q db.TxDB, id string, need access) bool {
+	a, found, err := loadAccess(ctx, q, id, viewerFrom(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	if !found || a == accessNone {
+		http.Error(w, "не найдено", 404)
+		return false
+	}
+	if a < need {
+		detail := "editor access to the collection required"
+		if need == accessOwner {
+			detail = "only the collection owner can do this"
+		}
+		render.WriteProblem(w, r, http.StatusForbidden, detail)
+		return false
+	}
+	return true
+}
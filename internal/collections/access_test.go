@@ -0,0 +1,199 @@
+package collections
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// accessRow — строка loadAccess: id, owner_id, visibility, роль участника
+type accessRow struct {
+	owner, role *string
+	visibility  string
+}
+
+func (r *accessRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	if len(dest) == 4 {
+		*dest[1].(**string) = r.owner
+		*dest[2].(*string) = r.visibility
+		*dest[3].(**string) = r.role
+	}
+	return nil
+}
+
+type accessDB struct {
+	mockDB
+	row accessRow
+}
+
+func (m *accessDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	return &m.row
+}
+func (m *accessDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func ptr(s string) *string { return &s }
+
+func TestLoadAccess(t *testing.T) {
+	alice := Viewer{Subject: "alice"}
+	bob := Viewer{Subject: "bob"}
+	cases := []struct {
+		name string
+		row  accessRow
+		v    Viewer
+		want access
+	}{
+		{"legacy collection", accessRow{visibility: VisibilityPublic}, bob, accessView},
+		{"legacy collection admin", accessRow{visibility: VisibilityPublic}, Viewer{Subject: "root", Admin: true}, accessOwner},
+		{"owner", accessRow{owner: ptr("alice"), visibility: VisibilityPrivate}, alice, accessOwner},
+		{"stranger private", accessRow{owner: ptr("alice"), visibility: VisibilityPrivate}, bob, accessNone},
+		{"stranger unlisted", accessRow{owner: ptr("alice"), visibility: VisibilityUnlisted}, bob, accessView},
+		{"anonymous public", accessRow{owner: ptr("alice"), visibility: VisibilityPublic}, Viewer{}, accessView},
+		{"viewer", accessRow{owner: ptr("alice"), visibility: VisibilityPrivate, role: ptr(RoleViewer)}, bob, accessView},
+		{"editor", accessRow{owner: ptr("alice"), visibility: VisibilityPrivate, role: ptr(RoleEditor)}, bob, accessEdit},
+		{"admin", accessRow{owner: ptr("alice"), visibility: VisibilityPrivate}, Viewer{Subject: "root", Admin: true}, accessOwner},
+	}
+	for _, tc := range cases {
+		got, found, err := loadAccess(context.Background(), &accessDB{row: tc.row}, "1", tc.v)
+		if err != nil || !found || got != tc.want {
+			t.Errorf("%s: got %v (found %v, err %v), want %v", tc.name, got, found, err, tc.want)
+		}
+	}
+}
+
+func TestListCond(t *testing.T) {
+	if cond, _ := (Viewer{}).listCond(nil); cond != "c.visibility = 'public'" {
+		t.Fatalf("anonymous must see only public collections: %q", cond)
+	}
+	cond, args := Viewer{Subject: "bob"}.listCond([]any{"x"})
+	if !strings.Contains(cond, "c.owner_id = $2") || !strings.Contains(cond, "collection_members") || len(args) != 2 || args[1] != "bob" {
+		t.Fatalf("unexpected cond %q %v", cond, args)
+	}
+}
+
+func collectionRequest(method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", "1")
+	ctx.URLParams.Add("book_id", "1")
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+}
+
+func TestAddBookRequiresEditorAccess(t *testing.T) {
+	testutil.AsUser(t, "bob", middleware.RoleEditor)
+	SetCollectionDB(&accessDB{row: accessRow{owner: ptr("alice"), visibility: VisibilityPublic, role: ptr(RoleViewer)}})
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":1}`)))
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected 403 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	SetCollectionDB(&accessDB{row: accessRow{owner: ptr("alice"), visibility: VisibilityPrivate}})
+	w = httptest.NewRecorder()
+	RemoveBookFromCollection(w, collectionRequest(http.MethodDelete, "/api/v1/collections/1/books/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("private collection must look missing, got %d", w.Code)
+	}
+}
+
+func TestGetPrivateCollection(t *testing.T) {
+	SetCollectionDB(&accessDB{row: accessRow{owner: ptr("alice"), visibility: VisibilityPrivate}})
+	testutil.AsUser(t, "bob", middleware.RoleReader)
+	w := httptest.NewRecorder()
+	GetCollection(w, collectionRequest(http.MethodGet, "/api/v1/collections/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a stranger, got %d", w.Code)
+	}
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w = httptest.NewRecorder()
+	GetCollection(w, collectionRequest(http.MethodGet, "/api/v1/collections/1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the owner, got %d", w.Code)
+	}
+}
+
+func TestShareCollectionOwnerOnly(t *testing.T) {
+	testutil.AsUser(t, "bob", middleware.RoleEditor)
+	SetCollectionDB(&accessDB{row: accessRow{owner: ptr("alice"), visibility: VisibilityPrivate, role: ptr(RoleEditor)}})
+	w := httptest.NewRecorder()
+	ShareCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/share", []byte(`{"member_id":"carol","role":"viewer"}`)))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("editor must not share, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	ShareCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/share", []byte(`{"member_id":"carol","role":"owner"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown role, got %d", w.Code)
+	}
+}
+
+func TestCreateCollectionNeedsCaller(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	testutil.AsUser(t, "", middleware.RoleReader, middleware.RoleEditor)
+	for _, body := range []string{`{"name":"Mine","visibility":"private"}`, `{"name":"Open","visibility":"public"}`, `{"name":"Default"}`} {
+		w := httptest.NewRecorder()
+		CreateCollection(w, httptest.NewRequest(http.MethodPost, "/api/v1/collections", strings.NewReader(body)))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestCreateCollectionWithoutPolicy(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	middleware.SetPolicy(nil)
+	w := httptest.NewRecorder()
+	CreateCollection(w, httptest.NewRequest(http.MethodPost, "/api/v1/collections", strings.NewReader(`{"name":"Shared"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 without a policy, got %d: %s", w.Code, w.Body.String())
+	}
+	var c Collection
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.OwnerID != nil || c.Visibility != VisibilityPublic {
+		t.Fatalf("expected an ownerless public collection, got owner %v and %q", c.OwnerID, c.Visibility)
+	}
+}
+
+func TestSetOwner(t *testing.T) {
+	database := &accessDB{}
+	SetCollectionDB(database)
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	SetOwner(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/owner", []byte(`{"owner_id":"  alice "}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	SetOwner(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/owner", []byte(`{"owner_id":" "}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without owner, got %d", w.Code)
+	}
+}
+
+func TestLegacyCollectionReadOnly(t *testing.T) {
+	testutil.AsUser(t, "bob", middleware.RoleEditor)
+	SetCollectionDB(&accessDB{row: accessRow{visibility: VisibilityPublic}})
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":1}`)))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("ownerless collection must be read-only, got %d", w.Code)
+	}
+}
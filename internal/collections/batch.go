@@ -0,0 +1,242 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+// maxBatch — сколько ID можно передать в одном пакетном запросе
+const maxBatch = 1000
+
+// Итоги пакетной операции по каждой книге
+const (
+	BatchAdded          = "added"
+	BatchAlreadyPresent = "already_present"
+	BatchBookNotFound   = "book_not_found"
+	BatchRemoved        = "removed"
+	BatchNotPresent     = "not_present"
+)
+
+// BatchRequest — какие книги добавить в конец подборки (в этом порядке)
+// и какие убрать
+type BatchRequest struct {
+	Add    []int `json:"add"`
+	Remove []int `json:"remove"`
+}
+
+// BatchResult — итог для одной книги
+type BatchResult struct {
+	XMLName xml.Name `json:"-" xml:"result"`
+	BookID  int      `json:"book_id" xml:"book_id"`
+	Op      string   `json:"op" xml:"op"`
+	Status  string   `json:"status" xml:"status"`
+}
+
+func (b BatchResult) CSVHeader() []string {
+	return []string{"book_id", "op", "status"}
+}
+
+func (b BatchResult) CSVRecord() []string {
+	return []string{strconv.Itoa(b.BookID), b.Op, b.Status}
+}
+
+// validate убирает повторы и проверяет, что книга не идёт сразу в оба списка
+func (b *BatchRequest) validate() error {
+	if len(b.Add)+len(b.Remove) == 0 {
+		return fmt.Errorf("add or remove must list at least one book")
+	}
+	if len(b.Add)+len(b.Remove) > maxBatch {
+		return fmt.Errorf("at most %d books per batch", maxBatch)
+	}
+	b.Add, b.Remove = dedupe(b.Add), dedupe(b.Remove)
+	removing := make(map[int]bool, len(b.Remove))
+	for _, id := range b.Remove {
+		removing[id] = true
+	}
+	for _, id := range b.Add {
+		if removing[id] {
+			return fmt.Errorf("book %d is both added and removed", id)
+		}
+	}
+	return nil
+}
+
+func dedupe(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	out := []int{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// collectIDs читает одну колонку int из запроса в множество
+func collectIDs(ctx context.Context, tx db.TxDB, sql string, args ...any) (map[int]bool, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// compactPositions перенумеровывает книги подборки 1..n по текущему порядку
+func compactPositions(ctx context.Context, tx db.TxDB, collectionID int) error {
+	_, err := tx.Exec(ctx, `UPDATE collection_books cb SET position = o.pos
+		FROM (SELECT book_id, row_number() OVER (ORDER BY position) AS pos FROM collection_books WHERE collection_id = $1) o
+		WHERE cb.collection_id = $1 AND cb.book_id = o.book_id AND cb.position <> o.pos`, collectionID)
+	return err
+}
+
+// @Summary Добавить и убрать книги пачкой
+// @Description Всё в одной транзакции: новые книги встают в конец в порядке
+// @Description списка add, уже добавленные и несуществующие пропускаются.
+// @Description Ответ — итог по каждому ID, событие одно на весь запрос.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Param batch body BatchRequest true "ID книг"
+// @Success 200 {array} BatchResult
+// @Router /api/v1/collections/{id}/books:batch [post]
+func BatchBooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	collectionID, n, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	results := []BatchResult{}
+	removed := map[int]bool{}
+	if len(req.Remove) > 0 {
+		removed, err = collectIDs(ctx, tx, "DELETE FROM collection_books WHERE collection_id=$1 AND book_id = ANY($2) RETURNING book_id", collectionID, req.Remove)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		for _, bookID := range req.Remove {
+			status := BatchNotPresent
+			if removed[bookID] {
+				status = BatchRemoved
+			}
+			results = append(results, BatchResult{BookID: bookID, Op: "remove", Status: status})
+		}
+	}
+	added := map[int]bool{}
+	if len(req.Add) > 0 {
+		found, err := collectIDs(ctx, tx, "SELECT id FROM books WHERE id = ANY($1) AND deleted_at IS NULL", req.Add)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var existing []int
+		for _, bookID := range req.Add {
+			if found[bookID] {
+				existing = append(existing, bookID)
+			}
+		}
+		// позиции после n заведомо свободны; пропуски от уже добавленных
+		// книг закрывает compactPositions
+		if len(existing) > 0 {
+			added, err = collectIDs(ctx, tx, `INSERT INTO collection_books (collection_id, book_id, position)
+				SELECT $1, o.book_id, $3 + o.ord FROM unnest($2::int[]) WITH ORDINALITY AS o(book_id, ord)
+				ON CONFLICT (collection_id, book_id) DO NOTHING RETURNING book_id`, collectionID, existing, n)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+		for _, bookID := range req.Add {
+			status := BatchAlreadyPresent
+			switch {
+			case !found[bookID]:
+				status = BatchBookNotFound
+			case added[bookID]:
+				status = BatchAdded
+			}
+			results = append(results, BatchResult{BookID: bookID, Op: "add", Status: status})
+		}
+	}
+	if len(added)+len(removed) > 0 {
+		if err := compactPositions(ctx, tx, collectionID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		change := map[string][]int{"added": keys(added, req.Add), "removed": keys(removed, req.Remove)}
+		if !recordAudit(ctx, w, r, tx, "batch", collectionID, nil, change) {
+			return
+		}
+		if producer != nil {
+			msg := fmt.Sprintf("updated collection books: %s added=%d removed=%d", id, len(added), len(removed))
+			if err := producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)}); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, results)
+}
+
+// keys возвращает ID из set в порядке order
+func keys(set map[int]bool, order []int) []int {
+	out := []int{}
+	for _, id := range order {
+		if set[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
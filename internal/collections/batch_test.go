@@ -0,0 +1,139 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+// idList — строки из одной колонки int
+type idList struct {
+	ids []int
+	idx int
+}
+
+func (r *idList) Next() bool { r.idx++; return r.idx <= len(r.ids) }
+func (r *idList) Scan(dest ...any) error {
+	*dest[0].(*int) = r.ids[r.idx-1]
+	return nil
+}
+func (r *idList) Close()     {}
+func (r *idList) Err() error { return nil }
+
+// batchDB: в подборке книги 2 и 3, существуют книги 1, 2, 3
+type batchDB struct {
+	mockDB
+	audits int
+}
+
+func (m *batchDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	switch {
+	case strings.HasPrefix(sql, "DELETE"):
+		return &idList{ids: []int{2}}, nil
+	case strings.HasPrefix(sql, "SELECT id FROM books"):
+		return &idList{ids: []int{1, 3}}, nil
+	case strings.HasPrefix(sql, "INSERT"):
+		return &idList{ids: []int{1}}, nil
+	}
+	return m.mockDB.Query(ctx, sql, args...)
+}
+func (m *batchDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func TestBatchBooks(t *testing.T) {
+	SetCollectionDB(&batchDB{})
+	events := &testutil.Producer{}
+	SetProducer(events)
+	serve := testutil.Server(RegisterRoutes)
+	w := serve(http.MethodPost, "/collections/1/books:batch", `{"add":[1,3,4,1],"remove":[2,5]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var got []BatchResult
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	want := []BatchResult{
+		{BookID: 2, Op: "remove", Status: BatchRemoved},
+		{BookID: 5, Op: "remove", Status: BatchNotPresent},
+		{BookID: 1, Op: "add", Status: BatchAdded},
+		{BookID: 3, Op: "add", Status: BatchAlreadyPresent},
+		{BookID: 4, Op: "add", Status: BatchBookNotFound},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected results %+v", got)
+	}
+	for i := range want {
+		if got[i].BookID != want[i].BookID || got[i].Op != want[i].Op || got[i].Status != want[i].Status {
+			t.Fatalf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if len(events.Msgs) != 1 || events.Msgs[0] != "updated collection books: 1 added=1 removed=1" {
+		t.Fatalf("expected one aggregated event, got %v", events.Msgs)
+	}
+}
+
+func TestBatchBooksValidation(t *testing.T) {
+	SetCollectionDB(&batchDB{})
+	for _, body := range []string{`{}`, `{"add":[1],"remove":[1]}`} {
+		w := httptest.NewRecorder()
+		BatchBooks(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books:batch", []byte(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+// addCheckRow — ответ на проверку книги перед добавлением
+type addCheckRow struct{ exists, present int }
+
+func (r addCheckRow) Scan(dest ...any) error {
+	*dest[0].(*int) = r.exists
+	if len(dest) == 2 {
+		*dest[1].(*int) = r.present
+	}
+	return nil
+}
+
+type addCheckDB struct {
+	mockDB
+	row addCheckRow
+}
+
+func (m *addCheckDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.Contains(sql, "FROM books") {
+		return m.row
+	}
+	return m.mockDB.QueryRow(ctx, sql, args...)
+}
+func (m *addCheckDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func TestAddBookConflicts(t *testing.T) {
+	SetProducer(&mockProducer{})
+	cases := []struct {
+		row  addCheckRow
+		want int
+	}{
+		{addCheckRow{exists: 0}, http.StatusNotFound},
+		{addCheckRow{exists: 1, present: 1}, http.StatusConflict},
+	}
+	for _, tc := range cases {
+		SetCollectionDB(&addCheckDB{row: tc.row})
+		w := httptest.NewRecorder()
+		AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":7}`)))
+		if w.Code != tc.want {
+			t.Fatalf("%+v: expected %d, got %d", tc.row, tc.want, w.Code)
+		}
+	}
+}
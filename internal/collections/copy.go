@@ -0,0 +1,443 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+// notify пишет событие подборок в транзакции tx; без записи в outbox
+// транзакция прервана, поэтому ошибка возвращается вызывающему
+func notify(ctx context.Context, tx db.TxDB, msg string) error {
+	if producer == nil {
+		return nil
+	}
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// lockCollections блокирует подборки FOR UPDATE в порядке id, чтобы
+// встречные слияния не ждали друг друга по кругу
+func lockCollections(ctx context.Context, tx db.TxDB, ids ...int) error {
+	rows, err := tx.Query(ctx, "SELECT id FROM collections WHERE id = ANY($1) ORDER BY id FOR UPDATE", ids)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	return nil
+}
+
+// orderedMembers возвращает те из ids, что есть в подборке, по позициям
+func orderedMembers(ctx context.Context, tx db.TxDB, collectionID int, ids []int) ([]int, error) {
+	rows, err := tx.Query(ctx, "SELECT book_id FROM collection_books WHERE collection_id=$1 AND book_id = ANY($2) ORDER BY position", collectionID, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// derivedOwner — владелец новой подборки: по умолчанию вызывающий, назначить
+// другого может только admin. Анонимному при включённой проверке прав — 401;
+// без политики подборка остаётся без владельца, как до появления владельцев.
+func derivedOwner(w http.ResponseWriter, r *http.Request, requested *string) (*string, bool) {
+	v := viewerFrom(r)
+	if requested != nil && *requested != v.Subject {
+		if !v.Admin {
+			render.WriteProblem(w, r, http.StatusForbidden, "only an admin can create a collection for another owner")
+			return nil, false
+		}
+		return requested, true
+	}
+	if v.Subject == "" {
+		if middleware.Enforced() {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			render.WriteProblem(w, r, http.StatusUnauthorized, errNoOwner.Error())
+			return nil, false
+		}
+		return nil, true
+	}
+	return &v.Subject, true
+}
+
+// CopyRequest — параметры копии; пустое описание берётся у исходной подборки
+type CopyRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	OwnerID     *string `json:"owner_id"`
+	Visibility  string  `json:"visibility"`
+}
+
+// @Summary Скопировать подборку
+// @Description Новая подборка получает книги в том же порядке (или то же правило),
+// @Description участники не копируются. Владелец — вызывающий, другого может задать admin.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Param id path int true "ID исходной подборки"
+// @Param copy body CopyRequest true "Имя и владелец копии"
+// @Success 201 {object} Collection
+// @Failure 403 {object} render.Problem "владельца может задать только admin"
+// @Router /api/v1/collections/{id}/copy [post]
+func CopyCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", 400)
+		return
+	}
+	owner, ok := derivedOwner(w, r, req.OwnerID)
+	if !ok {
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessView) {
+		return
+	}
+	src, err := scanCollection(tx.QueryRow(ctx, "SELECT "+collectionColumns+" FROM collections c WHERE c.id=$1", id))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	c := Collection{Name: req.Name, Description: src.Description, Visibility: req.Visibility, Rule: src.Rule, Materialized: src.Materialized}
+	if req.Description != nil {
+		c.Description = *req.Description
+	}
+	if err := setOwnership(&c, owner); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := insertCollection(ctx, tx, &c); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if c.Rule == nil {
+		// позиции источника уже идут 1..n, их можно переносить как есть
+		if _, err := tx.Exec(ctx, `INSERT INTO collection_books (collection_id, book_id, position)
+			SELECT $1, book_id, position FROM collection_books WHERE collection_id=$2`, c.ID, src.ID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if !recordAudit(ctx, w, r, tx, "copy", c.ID, nil, map[string]any{"source_id": src.ID, "collection": c}) {
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("copied collection: %d to %d", src.ID, c.ID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, c)
+}
+
+// MergeRequest — какую подборку влить и удалить ли её после этого
+type MergeRequest struct {
+	SourceID     int  `json:"source_id"`
+	DeleteSource bool `json:"delete_source"`
+}
+
+// MergeResult — итог слияния: добавленные книги по порядку и число
+// пропущенных, которые уже были в подборке
+type MergeResult struct {
+	TargetID      int   `json:"target_id"`
+	SourceID      int   `json:"source_id"`
+	Added         []int `json:"added"`
+	Skipped       int   `json:"skipped"`
+	SourceDeleted bool  `json:"source_deleted"`
+}
+
+// @Summary Влить в подборку другую
+// @Description Книги источника встают в конец в своём порядке, уже имеющиеся
+// @Description пропускаются. Удалить источник (delete_source) может только его владелец.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подборки, в которую вливают"
+// @Param merge body MergeRequest true "Источник"
+// @Success 200 {object} MergeResult
+// @Failure 409 {string} string "одна из подборок умная"
+// @Router /api/v1/collections/{id}/merge [post]
+func MergeCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	targetID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.SourceID == 0 || req.SourceID == targetID {
+		http.Error(w, "source_id must name another collection", 400)
+		return
+	}
+	sourceID := strconv.Itoa(req.SourceID)
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if err := lockCollections(ctx, tx, targetID, req.SourceID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	sourceNeed := accessView
+	if req.DeleteSource {
+		sourceNeed = accessOwner
+	}
+	if !authorize(ctx, w, r, tx, id, accessEdit) || !authorize(ctx, w, r, tx, sourceID, sourceNeed) {
+		return
+	}
+	_, n, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	_, sourceN, err := lockPositions(ctx, tx, sourceID)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	// row_number считается после отбора, поэтому новые позиции идут подряд
+	// за последней книгой подборки
+	added, err := orderedAdded(ctx, tx, `INSERT INTO collection_books (collection_id, book_id, position)
+		SELECT $1, s.book_id, $3 + row_number() OVER (ORDER BY s.position) FROM collection_books s
+		WHERE s.collection_id = $2
+		AND NOT EXISTS (SELECT 1 FROM collection_books t WHERE t.collection_id = $1 AND t.book_id = s.book_id)
+		RETURNING book_id, position`, targetID, req.SourceID, n)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	res := MergeResult{TargetID: targetID, SourceID: req.SourceID, Added: added, Skipped: sourceN - len(added)}
+	if req.DeleteSource {
+		before, err := scanCollection(tx.QueryRow(ctx, "SELECT "+collectionColumns+" FROM collections c WHERE c.id=$1", req.SourceID))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM collections WHERE id=$1", req.SourceID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !recordAudit(ctx, w, r, tx, "delete", req.SourceID, before, nil) {
+			return
+		}
+		res.SourceDeleted = true
+	}
+	if !recordAudit(ctx, w, r, tx, "merge", targetID, nil, res) {
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("merged collection: %d into %d added=%d", req.SourceID, targetID, len(added))); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if res.SourceDeleted {
+		if err := notify(ctx, tx, "deleted collection: "+sourceID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, res)
+}
+
+// orderedAdded выполняет INSERT ... RETURNING book_id, position и
+// возвращает вставленные книги по позициям
+func orderedAdded(ctx context.Context, tx db.TxDB, sql string, args ...any) ([]int, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var added []membership
+	for rows.Next() {
+		var m membership
+		if err := rows.Scan(&m.BookID, &m.Position); err != nil {
+			return nil, err
+		}
+		added = append(added, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Position < added[j].Position })
+	out := make([]int, len(added))
+	for i, m := range added {
+		out[i] = m.BookID
+	}
+	return out, nil
+}
+
+// SplitRequest — какие книги перенести и как назвать новую подборку
+type SplitRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"`
+	BookIDs     []int  `json:"book_ids"`
+}
+
+func (s *SplitRequest) validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(s.BookIDs) == 0 {
+		return errors.New("book_ids must list at least one book")
+	}
+	if len(s.BookIDs) > maxBatch {
+		return fmt.Errorf("at most %d books per split", maxBatch)
+	}
+	s.BookIDs = dedupe(s.BookIDs)
+	return nil
+}
+
+// @Summary Выделить часть подборки в новую
+// @Description Книги переносятся в новую подборку вызывающего в прежнем порядке
+// @Description и убираются из исходной; все они должны быть в ней.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Param id path int true "ID исходной подборки"
+// @Param split body SplitRequest true "Книги и имя новой подборки"
+// @Success 201 {object} Collection
+// @Failure 409 {string} string "подборка умная"
+// @Router /api/v1/collections/{id}/split [post]
+func SplitCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	owner, ok := derivedOwner(w, r, nil)
+	if !ok {
+		return
+	}
+	c := Collection{Name: req.Name, Description: req.Description, Visibility: req.Visibility}
+	if err := setOwnership(&c, owner); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	sourceID, _, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	moved, err := orderedMembers(ctx, tx, sourceID, req.BookIDs)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(moved) != len(req.BookIDs) {
+		present := map[int]bool{}
+		for _, bookID := range moved {
+			present[bookID] = true
+		}
+		for _, bookID := range req.BookIDs {
+			if !present[bookID] {
+				http.Error(w, fmt.Sprintf("book %d is not in the collection", bookID), 400)
+				return
+			}
+		}
+	}
+	if err := insertCollection(ctx, tx, &c); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO collection_books (collection_id, book_id, position)
+		SELECT $1, o.book_id, o.ord FROM unnest($2::int[]) WITH ORDINALITY AS o(book_id, ord)`, c.ID, moved); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM collection_books WHERE collection_id=$1 AND book_id = ANY($2)", sourceID, moved); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := compactPositions(ctx, tx, sourceID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	c.Books = moved
+	if !recordAudit(ctx, w, r, tx, "split", sourceID, bookOrder{BookIDs: moved}, map[string]any{"collection_id": c.ID}) ||
+		!recordAudit(ctx, w, r, tx, "create", c.ID, nil, c) {
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("split collection: %d into %d moved=%d", sourceID, c.ID, len(moved))); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, c)
+}
@@ -0,0 +1,193 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// collectionRow — строка scanCollection: обычная публичная подборка
+type collectionRow struct{}
+
+func (collectionRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	*dest[1].(*string) = "Source"
+	*dest[2].(*string) = "Desc"
+	*dest[4].(*string) = VisibilityPublic
+	return nil
+}
+
+type newIDRow struct{}
+
+func (newIDRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 7
+	return nil
+}
+
+// insertedRows — RETURNING book_id, position не в порядке позиций
+type insertedRows struct{ idx int }
+
+func (r *insertedRows) Next() bool { r.idx++; return r.idx <= 2 }
+func (r *insertedRows) Scan(dest ...any) error {
+	*dest[0].(*int), *dest[1].(*int) = 4, 5
+	if r.idx == 2 {
+		*dest[0].(*int), *dest[1].(*int) = 5, 4
+	}
+	return nil
+}
+func (r *insertedRows) Close()     {}
+func (r *insertedRows) Err() error { return nil }
+
+// copyDB: в подборках по три книги, книги 2 и 1 стоят в таком порядке
+type copyDB struct {
+	mockDB
+	execs []string
+}
+
+func (m *copyDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	switch {
+	case strings.Contains(sql, "collection_members"):
+		owner := "alice"
+		return &accessRow{owner: &owner, visibility: VisibilityPublic}
+	case strings.Contains(sql, "c.rule IS NOT NULL"):
+		return positionRow{}
+	case strings.HasPrefix(sql, "SELECT "+collectionColumns):
+		return collectionRow{}
+	case strings.HasPrefix(sql, "INSERT INTO collections"):
+		return newIDRow{}
+	}
+	return m.mockDB.QueryRow(ctx, sql, args...)
+}
+func (m *copyDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	switch {
+	case strings.HasPrefix(sql, "INSERT INTO collection_books"):
+		return &insertedRows{}, nil
+	case strings.HasPrefix(sql, "SELECT book_id FROM collection_books"):
+		return &idList{ids: []int{2, 1}}, nil
+	}
+	return m.mockDB.Query(ctx, sql, args...)
+}
+func (m *copyDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.execs = append(m.execs, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *copyDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func (m *copyDB) executed(prefix string) bool {
+	for _, sql := range m.execs {
+		if strings.HasPrefix(sql, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCopyCollection(t *testing.T) {
+	database := &copyDB{}
+	SetCollectionDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+	testutil.AsUser(t, "alice")
+	w := httptest.NewRecorder()
+	CopyCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/copy", []byte(`{"name":"Copy"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var c Collection
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if c.ID != 7 || c.Name != "Copy" || c.Description != "Desc" || c.OwnerID == nil || *c.OwnerID != "alice" || c.Visibility != VisibilityPrivate {
+		t.Fatalf("unexpected copy %+v", c)
+	}
+	if !database.executed("INSERT INTO collection_books") {
+		t.Fatalf("books were not copied: %v", database.execs)
+	}
+	if len(events.Msgs) != 1 || events.Msgs[0] != "copied collection: 1 to 7" {
+		t.Fatalf("unexpected events %v", events.Msgs)
+	}
+
+	w = httptest.NewRecorder()
+	CopyCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/copy", []byte(`{"name":"Copy","owner_id":"bob"}`)))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another owner, got %d", w.Code)
+	}
+}
+
+func TestMergeCollection(t *testing.T) {
+	database := &copyDB{}
+	SetCollectionDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+	w := httptest.NewRecorder()
+	MergeCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/merge", []byte(`{"source_id":2,"delete_source":true}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var res MergeResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(res.Added) != 2 || res.Added[0] != 5 || res.Added[1] != 4 || res.Skipped != 1 || !res.SourceDeleted {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if !database.executed("DELETE FROM collections") {
+		t.Fatalf("source was not deleted: %v", database.execs)
+	}
+	want := []string{"merged collection: 2 into 1 added=2", "deleted collection: 2"}
+	if strings.Join(events.Msgs, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected events %v", events.Msgs)
+	}
+
+	for _, body := range []string{`{"source_id":1}`, `{}`} {
+		w = httptest.NewRecorder()
+		MergeCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/merge", []byte(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestSplitCollection(t *testing.T) {
+	database := &copyDB{}
+	SetCollectionDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+	testutil.AsUser(t, "alice", middleware.RoleEditor)
+	w := httptest.NewRecorder()
+	SplitCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/split", []byte(`{"name":"Part","book_ids":[1,2,1]}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var c Collection
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if c.ID != 7 || len(c.Books) != 2 || c.Books[0] != 2 || c.Books[1] != 1 {
+		t.Fatalf("books must keep the source order, got %+v", c)
+	}
+	if !database.executed("DELETE FROM collection_books") || !database.executed("UPDATE collection_books cb SET position") {
+		t.Fatalf("books must leave the source with positions compacted: %v", database.execs)
+	}
+	if len(events.Msgs) != 1 || events.Msgs[0] != "split collection: 1 into 7 moved=2" {
+		t.Fatalf("unexpected events %v", events.Msgs)
+	}
+
+	w = httptest.NewRecorder()
+	SplitCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/split", []byte(`{"name":"Part","book_ids":[1,2,9]}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "book 9") {
+		t.Fatalf("expected 400 for a book outside the collection, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,209 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"books-api/internal/books"
+	"books-api/internal/db"
+)
+
+// ExpandBooks встраивает в подборку книги целиком вместо их ID
+const ExpandBooks = "books"
+
+// maxBooksLimit ограничивает страницу книг подборки
+const maxBooksLimit = 1000
+
+// page — страница книг подборки. Без limit (nil) отдаются все книги, как до
+// появления постраничного вывода.
+type page struct {
+	limit  *int
+	offset int
+}
+
+// parsePage читает limit и offset списка книг подборки
+func parsePage(q url.Values) (page, error) {
+	var p page
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, errors.New("limit must be a positive integer")
+		}
+		n = min(n, maxBooksLimit)
+		p.limit = &n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, errors.New("offset must be a non-negative integer")
+		}
+		p.offset = n
+	}
+	return p, nil
+}
+
+// CollectionWithBooks — подборка с книгами по ?expand=books
+type CollectionWithBooks struct {
+	Collection
+	Books []books.Book `json:"books" xml:"books>book"`
+}
+
+func (c CollectionWithBooks) CSVHeader() []string {
+	return Collection{}.CSVHeader()
+}
+
+func (c CollectionWithBooks) CSVRecord() []string {
+	c.Collection.Books = make([]int, len(c.Books))
+	for i, b := range c.Books {
+		c.Collection.Books[i] = b.ID
+	}
+	return c.Collection.CSVRecord()
+}
+
+// membersSQL — откуда брать страницу книг подборки: ручной список по
+// позициям, сохранённый состав умной подборки или её правило на лету.
+// Если подборок несколько (поддерево с ?recursive=true), берутся различные
+// книги всех, по ID. books не алиасится: на неё ссылаются подзапросы
+// авторов и жанров из books.Expand.Columns и условия правила.
+func membersSQL(cs []Collection, p page) (string, []any, error) {
+	var sql string
+	var args []any
+	c := cs[0]
+	switch {
+	case len(cs) > 1:
+		var err error
+		if sql, args, err = treeMembersSQL(cs); err != nil {
+			return "", nil, err
+		}
+	case c.Rule == nil:
+		sql, args = ` FROM collection_books cb JOIN books ON books.id = cb.book_id
+	WHERE cb.collection_id = $1 AND books.deleted_at IS NULL ORDER BY cb.position`, []any{c.ID}
+	case c.Materialized:
+		sql, args = ` FROM collection_rule_books cr JOIN books ON books.id = cr.book_id
+	WHERE cr.collection_id = $1 AND books.deleted_at IS NULL ORDER BY books.id`, []any{c.ID}
+	default:
+		cond, ruleArgs, err := c.Rule.sql(nil)
+		if err != nil {
+			return "", nil, err
+		}
+		sql, args = " FROM books WHERE books.deleted_at IS NULL AND ("+cond+") ORDER BY books.id", ruleArgs
+	}
+	args = append(args, p.limit, p.offset)
+	return sql + fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)-1, len(args)), args, nil
+}
+
+// treeMembersSQL объединяет составы нескольких подборок: ручные списки и
+// сохранённые составы — по массивам ID подборок, правила — их условиями
+func treeMembersSQL(cs []Collection) (string, []any, error) {
+	var args []any
+	var conds []string
+	manual, stored := []int{}, []int{}
+	for _, c := range cs {
+		switch {
+		case c.Rule == nil:
+			manual = append(manual, c.ID)
+		case c.Materialized:
+			stored = append(stored, c.ID)
+		default:
+			cond, next, err := c.Rule.sql(args)
+			if err != nil {
+				return "", nil, err
+			}
+			conds, args = append(conds, "("+cond+")"), next
+		}
+	}
+	args = append(args, manual, stored)
+	conds = append(conds,
+		fmt.Sprintf("books.id IN (SELECT book_id FROM collection_books WHERE collection_id = ANY($%d::int[]))", len(args)-1),
+		fmt.Sprintf("books.id IN (SELECT book_id FROM collection_rule_books WHERE collection_id = ANY($%d::int[]))", len(args)))
+	return " FROM books WHERE books.deleted_at IS NULL AND (" + strings.Join(conds, " OR ") + ") ORDER BY books.id", args, nil
+}
+
+// memberIDs возвращает страницу ID книг и общее число книг подборок cs
+// (count(*) OVER () считается тем же запросом)
+func memberIDs(ctx context.Context, cs []Collection, p page) ([]int, int, error) {
+	from, args, err := membersSQL(cs, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := dbi.Query(ctx, "SELECT books.id, count(*) OVER ()"+from, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var ids []int
+	total := 0
+	for rows.Next() {
+		var bookID int
+		if err := rows.Scan(&bookID, &total); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, bookID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return ids, total, nil
+}
+
+// memberBooks — то же, но с книгами целиком, связи книг по e
+func memberBooks(ctx context.Context, cs []Collection, e books.Expand, p page) ([]books.Book, int, error) {
+	from, args, err := membersSQL(cs, p)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := dbi.Query(ctx, "SELECT "+e.Columns()+", count(*) OVER ()"+from, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	list := []books.Book{}
+	total := 0
+	for rows.Next() {
+		b, err := books.ScanBook(totalRow{rows, &total})
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// totalRow дочитывает последнюю колонку count(*) OVER () после колонок книги
+type totalRow struct {
+	row   db.Row
+	total *int
+}
+
+func (t totalRow) Scan(dest ...any) error {
+	return t.row.Scan(append(dest, t.total)...)
+}
+
+// parseCollectionExpand: authors и genres относятся к встроенным книгам
+// и без books не имеют смысла
+func parseCollectionExpand(q url.Values) (books.Expand, error) {
+	e, err := books.ParseExpand(q.Get("expand"), ExpandBooks, books.ExpandAuthors, books.ExpandGenres)
+	if err != nil {
+		return nil, err
+	}
+	if !e[ExpandBooks] && (e[books.ExpandAuthors] || e[books.ExpandGenres]) {
+		return nil, errors.New("authors and genres can only be expanded together with books")
+	}
+	return e, nil
+}
+
+// setTotal сообщает общее число книг подборки для постраничного обхода.
+// За последней страницей строк нет, и число неизвестно — заголовок не ставим.
+func setTotal(w http.ResponseWriter, p page, n, total int) {
+	if n > 0 || p.offset == 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+}
@@ -0,0 +1,60 @@
+package collections
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// limited — страница с заданными limit и offset
+func limited(limit, offset int) page {
+	return page{limit: &limit, offset: offset}
+}
+
+func TestParsePage(t *testing.T) {
+	p, err := parsePage(url.Values{})
+	if err != nil || p.limit != nil || p.offset != 0 {
+		t.Fatalf("expected an unpaged list without limit and offset, got %+v, %v", p, err)
+	}
+	p, err = parsePage(url.Values{"limit": {"5000"}, "offset": {"20"}})
+	if err != nil || p.limit == nil || *p.limit != maxBooksLimit || p.offset != 20 {
+		t.Fatalf("unexpected page %+v, %v", p, err)
+	}
+}
+
+func TestGetCollectionExpandBooks(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	w := httptest.NewRecorder()
+	GetCollection(w, collectionRequest(http.MethodGet, "/api/v1/collections/1?expand=books,authors&limit=10", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		ID    int `json:"id"`
+		Books []struct {
+			ID int `json:"id"`
+		} `json:"books"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(got.Books) != 1 || got.Books[0].ID != 1 {
+		t.Fatalf("expected embedded books, got %+v", got)
+	}
+	if w.Header().Get("X-Total-Count") == "" {
+		t.Fatal("expected X-Total-Count")
+	}
+}
+
+func TestGetCollectionBadParams(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	for _, q := range []string{"expand=authors", "expand=owner", "limit=0", "offset=-1"} {
+		w := httptest.NewRecorder()
+		GetCollection(w, collectionRequest(http.MethodGet, "/api/v1/collections/1?"+q, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
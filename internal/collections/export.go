@@ -0,0 +1,153 @@
+package collections
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+// ExportedCollection — подборка вместе с книгами для выгрузки
+type ExportedCollection struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Books       []books.Book `json:"books"`
+}
+
+// collectionBookRow — строка CSV-выгрузки: одна пара подборка/книга
+type collectionBookRow struct {
+	collection ExportedCollection
+	book       *books.Book
+}
+
+func (r collectionBookRow) CSVHeader() []string {
+	return append([]string{"collection_id", "collection_name", "collection_description"},
+		prefixed("book_", books.Book{}.CSVHeader())...)
+}
+
+func (r collectionBookRow) CSVRecord() []string {
+	rec := []string{strconv.Itoa(r.collection.ID), r.collection.Name, r.collection.Description}
+	if r.book == nil {
+		return append(rec, make([]string, len(books.Book{}.CSVHeader()))...)
+	}
+	return append(rec, r.book.CSVRecord()...)
+}
+
+func prefixed(prefix string, names []string) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = prefix + n
+	}
+	return out
+}
+
+// Export выгружает подборки с книгами одним проходом по JOIN-у,
+// отсортированному по подборке: в памяти держится только текущая подборка.
+// В CSV каждая книга подборки — отдельная строка. Выгружаются только
+// подборки, которые v видит в списке.
+func Export(ctx context.Context, database db.TxDB, w io.Writer, format string, v Viewer) (int, error) {
+	stream, err := render.NewStream(w, format)
+	if err != nil {
+		return 0, err
+	}
+	cond, args := v.listCond(nil)
+	rows, err := database.Query(ctx, `SELECT c.id, c.name, COALESCE(c.description, ''), b.id, b.title, b.author, b.published_at::text, b.isbn
+		FROM collections c
+		LEFT JOIN collection_books cb ON cb.collection_id = c.id
+		LEFT JOIN books b ON b.id = cb.book_id AND b.deleted_at IS NULL
+		WHERE `+cond+`
+		ORDER BY c.id, cb.position`, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	var cur *ExportedCollection
+	emit := func() error {
+		if cur == nil {
+			return nil
+		}
+		n++
+		if format != render.FormatCSV {
+			return stream.Write(cur)
+		}
+		if len(cur.Books) == 0 {
+			return stream.Write(collectionBookRow{collection: *cur})
+		}
+		for i := range cur.Books {
+			if err := stream.Write(collectionBookRow{collection: *cur, book: &cur.Books[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for rows.Next() {
+		var c ExportedCollection
+		var bookID *int
+		var title, author, published, isbn *string
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &bookID, &title, &author, &published, &isbn); err != nil {
+			return n, err
+		}
+		if cur == nil || cur.ID != c.ID {
+			if err := emit(); err != nil {
+				return n, err
+			}
+			c.Books = []books.Book{}
+			cur = &c
+		}
+		if bookID != nil {
+			cur.Books = append(cur.Books, books.Book{ID: *bookID, Title: *title, Author: *author, PublishedAt: published, ISBN: isbn})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := emit(); err != nil {
+		return n, err
+	}
+	return n, stream.Close()
+}
+
+// @Summary Выгрузка подборок с книгами
+// @Tags collections
+// @Produce json,plain
+// @Param format query string false "ndjson, csv или json; по умолчанию по Accept, иначе ndjson"
+// @Success 200 {array} ExportedCollection
+// @Router /api/v1/collections/export [get]
+func ExportCollections(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		// без ?format смотрим на Accept, по умолчанию ndjson
+		var ok bool
+		offers := []string{render.FormatNDJSON, render.FormatCSV, render.FormatJSON}
+		if format, ok = render.Negotiate(r, offers...); !ok {
+			render.NotAcceptable(w, offers...)
+			return
+		}
+	}
+	if format != render.FormatNDJSON && format != render.FormatCSV && format != render.FormatJSON {
+		http.Error(w, "format must be ndjson, csv or json", 400)
+		return
+	}
+	w.Header().Set("Content-Type", render.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"collections.%s\"", format))
+	n, err := Export(r.Context(), dbi, w, format, viewerFrom(r))
+	if err != nil && n == 0 {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// после первой записи статус уже не поменять: обрываем соединение, чтобы
+	// клиент не принял обрезанную выгрузку за полную
+	if err != nil {
+		log.Printf("ошибка выгрузки подборок после %d записей: %v", n, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,35 @@
+package collections
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportCollections(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/collections/export?format=json", nil)
+	w := httptest.NewRecorder()
+	ExportCollections(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var got []ExportedCollection
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != 1 || got[0].Books == nil {
+		t.Fatalf("unexpected export: %+v", got)
+	}
+}
+
+func TestExportCollectionsBadFormat(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/collections/export?format=xml", nil)
+	w := httptest.NewRecorder()
+	ExportCollections(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
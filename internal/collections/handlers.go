@@ -3,18 +3,26 @@ package collections
 import (
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/segmentio/kafka-go"
 
+	"books-api/internal/audit"
 	"books-api/internal/db"
+	"books-api/internal/render"
 )
 
 type Producer interface {
-	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
 	Close() error
 }
 
@@ -30,13 +38,118 @@ func SetProducer(w Producer) {
 }
 
 type Collection struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Books       []int  `json:"books,omitempty"`
+	XMLName     xml.Name `json:"-" xml:"collection"`
+	ID          int      `json:"id" xml:"id"`
+	Name        string   `json:"name" xml:"name"`
+	Description string   `json:"description" xml:"description"`
+	OwnerID     *string  `json:"owner_id,omitempty" xml:"owner_id,omitempty"`
+	Visibility  string   `json:"visibility" xml:"visibility"`
+	// Rule задаёт состав умной подборки; книги в неё вручную не добавляются
+	Rule *Rule `json:"rule,omitempty" xml:"-"`
+	// Materialized — состав умной подборки хранится и обновляется по событиям книг
+	Materialized bool `json:"materialized,omitempty" xml:"materialized,omitempty"`
+	// ParentID — подборка-полка, в которую вложена эта
+	ParentID *int  `json:"parent_id,omitempty" xml:"parent_id,omitempty"`
+	Books    []int `json:"books,omitempty" xml:"books>book_id,omitempty"`
+}
+
+// collectionColumns — порядок колонок, который ожидает scanCollection
+const collectionColumns = "c.id, c.name, c.description, c.owner_id, c.visibility, c.rule, c.materialized, c.parent_id"
+
+func scanCollection(row db.Row) (Collection, error) {
+	var c Collection
+	var rule []byte
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &c.OwnerID, &c.Visibility, &rule, &c.Materialized, &c.ParentID); err != nil {
+		return c, err
+	}
+	if len(rule) > 0 {
+		c.Rule = &Rule{}
+		if err := json.Unmarshal(rule, c.Rule); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// errNoOwner — новой подборке нужен владелец, а вызывающий анонимен
+var errNoOwner = errors.New("authentication required to create a collection")
+
+// setOwnership задаёт владельца новой подборки и проверяет видимость.
+// По умолчанию подборка с владельцем private, а без владельца (проверка
+// прав выключена) — public, как общие подборки прежних времён.
+func setOwnership(c *Collection, owner *string) error {
+	c.OwnerID = owner
+	if c.Visibility == "" {
+		c.Visibility = VisibilityPrivate
+		if owner == nil {
+			c.Visibility = VisibilityPublic
+		}
+	}
+	if !validVisibility(c.Visibility) {
+		return errors.New("visibility must be private, unlisted or public")
+	}
+	return nil
+}
+
+// insertCollection сохраняет новую подборку и проставляет c.ID; состав
+// материализованной умной подборки считается сразу
+func insertCollection(ctx context.Context, tx db.TxDB, c *Collection) error {
+	var rule []byte
+	if c.Rule != nil {
+		rule, _ = json.Marshal(c.Rule)
+	}
+	err := tx.QueryRow(ctx, `INSERT INTO collections (name, description, owner_id, visibility, rule, materialized)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		c.Name, c.Description, c.OwnerID, c.Visibility, rule, c.Materialized).Scan(&c.ID)
+	if err != nil || !c.Materialized {
+		return err
+	}
+	return refreshCollection(ctx, tx, c.ID, *c.Rule)
+}
+
+// auditEntity — имя сущности подборки в журнале изменений
+const auditEntity = "collection"
+
+// recordAudit пишет запись журнала и отвечает 500 сам; false — ответ уже отправлен
+func recordAudit(ctx context.Context, w http.ResponseWriter, r *http.Request, tx db.TxDB, action string, id int, before, after any) bool {
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), action, auditEntity, &id, before, after); err != nil {
+		http.Error(w, err.Error(), 500)
+		return false
+	}
+	return true
+}
+
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// membership — состояние связи подборка–книга для журнала
+type membership struct {
+	BookID   int `json:"book_id"`
+	Position int `json:"position,omitempty"`
+}
+
+func (c Collection) CSVHeader() []string {
+	return []string{"id", "name", "description", "books"}
+}
+
+// CSVRecord перечисляет ID книг через точку с запятой
+func (c Collection) CSVRecord() []string {
+	ids := make([]string, len(c.Books))
+	for i, id := range c.Books {
+		ids[i] = strconv.Itoa(id)
+	}
+	return []string{strconv.Itoa(c.ID), c.Name, c.Description, strings.Join(ids, ";")}
 }
 
 // @Summary Создать подборку
+// @Description Владельцем становится вызывающий (другого может задать admin);
+// @Description видимость по умолчанию private. Анонимно создать нельзя, пока
+// @Description не выключена проверка прав: тогда подборка без владельца и public.
 // @Tags collections
 // @Accept json
 // @Produce json
@@ -44,60 +157,101 @@ type Collection struct {
 // @Success 201 {object} Collection
 // @Router /api/v1/collections [post]
 func CreateCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var c Collection
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	row := dbi.QueryRow(r.Context(), "INSERT INTO collections (name, description) VALUES ($1, $2) RETURNING id, name, description", c.Name, c.Description)
-	if err := row.Scan(&c.ID, &c.Name, &c.Description); err != nil {
+	owner, ok := derivedOwner(w, r, c.OwnerID)
+	if !ok {
+		return
+	}
+	if err := setOwnership(&c, owner); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if c.Rule != nil {
+		if err := c.Rule.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	} else if c.Materialized {
+		http.Error(w, "only a collection with a rule can be materialized", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if err := insertCollection(ctx, tx, &c); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "create", c.ID, nil, c) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(r.Context(), kafka.Message{Value: []byte("created collection: " + c.Name)}); err != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("created collection: " + c.Name)}); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
 	}
-	w.WriteHeader(201)
-	if err := json.NewEncoder(w).Encode(c); err != nil {
+	if err := tx.Commit(ctx); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	render.Render(w, r, http.StatusCreated, c)
 }
 
 // @Summary Получить список подборок
+// @Description Публичные подборки, а также свои и те, которыми поделились
 // @Tags collections
 // @Produce json
 // @Success 200 {array} Collection
 // @Router /api/v1/collections [get]
 func ListCollections(w http.ResponseWriter, r *http.Request) {
-	rows, err := dbi.Query(r.Context(), "SELECT id, name, description FROM collections")
+	cond, args := viewerFrom(r).listCond(nil)
+	rows, err := dbi.Query(r.Context(), "SELECT "+collectionColumns+" FROM collections c WHERE "+cond+" ORDER BY c.id", args...)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	defer rows.Close()
-	var collections []Collection
+	collections := []Collection{}
 	for rows.Next() {
-		var c Collection
-		if err := rows.Scan(&c.ID, &c.Name, &c.Description); err != nil {
+		c, err := scanCollection(rows)
+		if err != nil {
 			continue
 		}
 		collections = append(collections, c)
 	}
-	if err := json.NewEncoder(w).Encode(collections); err != nil {
+	if err := rows.Err(); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	render.Render(w, r, http.StatusOK, collections)
 }
 
 // @Summary Получить подборку по id
 // @Tags collections
 // @Produce json
+// @Description Книги идут по позициям; с limit/offset — страницами, без них —
+// @Description все сразу; общее число — в X-Total-Count. С expand=books вместо ID отдаются книги целиком
+// @Description (одним JOIN), expand=books,authors,genres — ещё и со связями.
+// @Description recursive=true — различные книги подборки и всех вложенных, по ID.
 // @Param id path int true "ID подборки"
-// @Success 200 {object} Collection
+// @Param expand query string false "books, authors, genres через запятую"
+// @Param recursive query bool false "Включить книги вложенных подборок"
+// @Param limit query int false "Книг на странице; без limit и offset отдаются все"
+// @Param offset query int false "Сколько книг пропустить"
+// @Success 200 {object} CollectionWithBooks
 // @Failure 404 {string} string "не найдено"
 // @Router /api/v1/collections/{id} [get]
 func GetCollection(w http.ResponseWriter, r *http.Request) {
@@ -106,35 +260,72 @@ func GetCollection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "не указан id коллекции", 400)
 		return
 	}
-	var c Collection
-	row := dbi.QueryRow(r.Context(), "SELECT id, name, description FROM collections WHERE id=$1", id)
-	if err := row.Scan(&c.ID, &c.Name, &c.Description); err != nil {
+	expand, err := parseCollectionExpand(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	p, err := parsePage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	recursive := false
+	if v := r.URL.Query().Get("recursive"); v != "" {
+		if recursive, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "recursive must be true or false", 400)
+			return
+		}
+	}
+	if !authorize(r.Context(), w, r, dbi, id, accessView) {
+		return
+	}
+	c, err := scanCollection(dbi.QueryRow(r.Context(), "SELECT "+collectionColumns+" FROM collections c WHERE c.id=$1", id))
+	if err != nil {
 		http.Error(w, "не найдено", 404)
 		return
 	}
-	// Получаем книги в подборке
-	booksRows, err := dbi.Query(r.Context(), "SELECT book_id FROM collection_books WHERE collection_id=$1", id)
-	if err == nil {
-		defer booksRows.Close()
-		for booksRows.Next() {
-			var bookID int
-			if err := booksRows.Scan(&bookID); err == nil {
-				c.Books = append(c.Books, bookID)
-			}
+	tree := []Collection{c}
+	if recursive {
+		if tree, err = subtree(r.Context(), dbi, c.ID, viewerFrom(r)); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if len(tree) == 0 {
+			http.Error(w, "не найдено", 404)
+			return
 		}
 	}
-	if err := json.NewEncoder(w).Encode(c); err != nil {
+	if expand[ExpandBooks] {
+		list, total, err := memberBooks(r.Context(), tree, expand, p)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		setTotal(w, p, len(list), total)
+		render.Render(w, r, http.StatusOK, CollectionWithBooks{Collection: c, Books: list})
+		return
+	}
+	ids, total, err := memberIDs(r.Context(), tree, p)
+	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	c.Books = ids
+	setTotal(w, p, len(ids), total)
+	render.Render(w, r, http.StatusOK, c)
 }
 
 // @Summary Добавить книгу в подборку
+// @Description Без position книга встаёт в конец, иначе книги с этой позиции сдвигаются
 // @Tags collections
 // @Accept json
 // @Param id path int true "ID подборки"
-// @Param book_id body object true "ID книги"
+// @Param book_id body object true "ID книги и необязательная позиция с 1"
 // @Success 204 {string} string "Книга добавлена"
+// @Failure 403 {object} render.Problem "нужна роль editor в подборке"
+// @Failure 404 {string} string "книги нет"
+// @Failure 409 {string} string "книга уже в подборке"
 // @Router /api/v1/collections/{id}/books [post]
 func AddBookToCollection(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -150,20 +341,68 @@ func AddBookToCollection(w http.ResponseWriter, r *http.Request) {
 	}()
 	id := chi.URLParam(r, "id")
 	var req struct {
-		BookID int `json:"book_id"`
+		BookID   int  `json:"book_id"`
+		Position *int `json:"position"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	_, err = tx.Exec(ctx, "INSERT INTO collection_books (collection_id, book_id) VALUES ($1, $2)", id, req.BookID)
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	collectionID, n, err := lockPositions(ctx, tx, id)
 	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	var bookExists, present int
+	err = tx.QueryRow(ctx, `SELECT (SELECT count(*) FROM books WHERE id=$2 AND deleted_at IS NULL),
+		(SELECT count(*) FROM collection_books WHERE collection_id=$1 AND book_id=$2)`, collectionID, req.BookID).Scan(&bookExists, &present)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if bookExists == 0 {
+		http.Error(w, "book not found", 404)
+		return
+	}
+	if present > 0 {
+		http.Error(w, "book is already in the collection", 409)
+		return
+	}
+	position := n + 1
+	if req.Position != nil {
+		if *req.Position < 1 || *req.Position > n+1 {
+			http.Error(w, fmt.Sprintf("position must be between 1 and %d", n+1), 400)
+			return
+		}
+		position = *req.Position
+	}
+	if err := shiftPositions(ctx, tx, collectionID, position, n, 1); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
+	_, err = tx.Exec(ctx, "INSERT INTO collection_books (collection_id, book_id, position) VALUES ($1, $2, $3)", collectionID, req.BookID, position)
+	switch pgErrorCode(err) {
+	case "":
+	case "23503":
+		http.Error(w, "book not found", 404)
+		return
+	case "23505":
+		http.Error(w, "book is already in the collection", 409)
+		return
+	default:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "add_book", collectionID, nil, membership{BookID: req.BookID, Position: position}) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(ctx, kafka.Message{Value: []byte("added book to collection: " + id)}); err != nil {
-			log.Printf("ошибка отправки в Kafka: %v", err)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("added book to collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
 	}
 	if err := tx.Commit(ctx); err != nil {
@@ -178,6 +417,7 @@ func AddBookToCollection(w http.ResponseWriter, r *http.Request) {
 // @Param id path int true "ID подборки"
 // @Param book_id path int true "ID книги"
 // @Success 204 {string} string "Книга удалена"
+// @Failure 403 {object} render.Problem "нужна роль editor в подборке"
 // @Router /api/v1/collections/{id}/books/{book_id} [delete]
 func RemoveBookFromCollection(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -193,15 +433,32 @@ func RemoveBookFromCollection(w http.ResponseWriter, r *http.Request) {
 	}()
 	id := chi.URLParam(r, "id")
 	bookID := chi.URLParam(r, "book_id")
-	row := tx.QueryRow(ctx, "DELETE FROM collection_books WHERE collection_id=$1 AND book_id=$2 RETURNING book_id", id, bookID)
-	var deletedID int
-	if err := row.Scan(&deletedID); err != nil {
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	collectionID, n, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	row := tx.QueryRow(ctx, "DELETE FROM collection_books WHERE collection_id=$1 AND book_id=$2 RETURNING book_id, position", collectionID, bookID)
+	var deletedID, position int
+	if err := row.Scan(&deletedID, &position); err != nil {
 		http.Error(w, "not found", 404)
 		return
 	}
+	// закрываем дыру, чтобы позиции оставались 1..n
+	if err := shiftPositions(ctx, tx, collectionID, position+1, n, -1); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "remove_book", collectionID, membership{BookID: deletedID, Position: position}, nil) {
+		return
+	}
 	if producer != nil {
-		if err := producer.WriteMessages(ctx, kafka.Message{Value: []byte("removed book from collection: " + id)}); err != nil {
-			log.Printf("ошибка отправки в Kafka: %v", err)
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("removed book from collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
 		}
 	}
 	if err := tx.Commit(ctx); err != nil {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +16,7 @@ import (
 	"github.com/segmentio/kafka-go"
 
 	"books-api/internal/db"
+	"books-api/internal/testutil"
 )
 
 type mockRows struct{ idx int }
@@ -23,7 +26,8 @@ func (r *mockRows) Scan(dest ...any) error {
 	*dest[0].(*int) = 1
 	return nil
 }
-func (r *mockRows) Close() {}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
 
 type mockRow struct{}
 
@@ -51,7 +55,7 @@ func (m *mockDB) Commit(ctx context.Context) error   { return nil }
 
 type mockProducer struct{}
 
-func (m *mockProducer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+func (m *mockProducer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
 	return nil
 }
 func (m *mockProducer) Close() error { return nil }
@@ -109,6 +113,28 @@ func TestAddBookToCollection(t *testing.T) {
 	}
 }
 
+// commitDB — mockDB, который считает фиксации транзакций
+type commitDB struct {
+	mockDB
+	commits int
+}
+
+func (m *commitDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *commitDB) Commit(ctx context.Context) error { m.commits++; return nil }
+
+func TestAddBookEventFailure(t *testing.T) {
+	database := &commitDB{}
+	SetCollectionDB(database)
+	SetProducer(&testutil.Producer{Err: errors.New("outbox insert failed")})
+	w := httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":1}`)))
+	if w.Code != http.StatusInternalServerError || database.commits != 0 {
+		t.Fatalf("failed event write must abort the request, got %d with %d commits", w.Code, database.commits)
+	}
+}
+
 func TestRemoveBookFromCollection(t *testing.T) {
 	SetCollectionDB(&mockDB{})
 	SetProducer(&mockProducer{})
@@ -123,3 +149,26 @@ func TestRemoveBookFromCollection(t *testing.T) {
 		t.Fatalf("expected 204, got %d", w.Code)
 	}
 }
+
+func TestListCollectionsXML(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/collections", nil)
+	req.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	ListCollections(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	var got struct {
+		Collections []Collection `xml:"collection"`
+	}
+	if err := xml.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(got.Collections) != 1 || got.Collections[0].ID != 1 {
+		t.Fatalf("unexpected collections: %+v", got)
+	}
+}
@@ -0,0 +1,248 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+)
+
+// errSmartCollection — состав умной подборки задаёт правило, вручную его
+// не меняют
+var errSmartCollection = errors.New("books of a smart collection are defined by its rule")
+
+// lockPositions блокирует подборку FOR UPDATE, чтобы параллельные вставки
+// и перестановки не раздали одинаковые позиции, и возвращает её id и
+// число книг (включая лежащие в корзине — их позиции сохраняются)
+func lockPositions(ctx context.Context, tx db.TxDB, id string) (collectionID, n int, err error) {
+	var smart bool
+	err = tx.QueryRow(ctx, `SELECT c.id, (SELECT count(*) FROM collection_books cb WHERE cb.collection_id = c.id), c.rule IS NOT NULL
+		FROM collections c WHERE c.id=$1 FOR UPDATE`, id).Scan(&collectionID, &n, &smart)
+	if err == nil && smart {
+		err = errSmartCollection
+	}
+	return collectionID, n, err
+}
+
+// writeLockError отвечает на ошибку lockPositions
+func writeLockError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSmartCollection) {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	http.Error(w, err.Error(), 500)
+}
+
+// shiftPositions сдвигает книги на позициях from..to на delta
+func shiftPositions(ctx context.Context, tx db.TxDB, collectionID, from, to, delta int) error {
+	if from > to {
+		return nil
+	}
+	_, err := tx.Exec(ctx, "UPDATE collection_books SET position = position + $4 WHERE collection_id=$1 AND position BETWEEN $2 AND $3",
+		collectionID, from, to, delta)
+	return err
+}
+
+// bookOrder — порядок книг подборки для журнала
+type bookOrder struct {
+	BookIDs []int `json:"book_ids"`
+}
+
+func notifyReorder(ctx context.Context, tx db.TxDB, id string) error {
+	return notify(ctx, tx, "reordered collection: "+id)
+}
+
+// @Summary Задать порядок книг в подборке
+// @Description Принимает полный список ID книг подборки в нужном порядке.
+// @Description Книги из корзины сохраняют относительный порядок и идут в конце.
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param order body bookOrder true "ID книг по порядку"
+// @Success 204 {string} string "Порядок сохранён"
+// @Router /api/v1/collections/{id}/books/order [put]
+func ReorderBooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req bookOrder
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	collectionID, _, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	rows, err := tx.Query(ctx, `SELECT cb.book_id, b.deleted_at IS NOT NULL FROM collection_books cb JOIN books b ON b.id = cb.book_id
+		WHERE cb.collection_id=$1 ORDER BY cb.position`, collectionID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var before bookOrder
+	var trashed []int
+	for rows.Next() {
+		var bookID int
+		var deleted bool
+		if err := rows.Scan(&bookID, &deleted); err != nil {
+			rows.Close()
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if deleted {
+			trashed = append(trashed, bookID)
+		} else {
+			before.BookIDs = append(before.BookIDs, bookID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := sameMembers(before.BookIDs, req.BookIDs); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	order := append(append([]int{}, req.BookIDs...), trashed...)
+	if _, err := tx.Exec(ctx, `UPDATE collection_books cb SET position = o.pos
+		FROM unnest($2::int[]) WITH ORDINALITY AS o(book_id, pos)
+		WHERE cb.collection_id = $1 AND cb.book_id = o.book_id`, collectionID, order); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "reorder", collectionID, before, req) {
+		return
+	}
+	if err := notifyReorder(ctx, tx, id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// sameMembers проверяет, что order — перестановка current без повторов
+func sameMembers(current, order []int) error {
+	if len(order) != len(current) {
+		return fmt.Errorf("book_ids must list all %d books of the collection, got %d", len(current), len(order))
+	}
+	members := make(map[int]bool, len(current))
+	for _, id := range current {
+		members[id] = true
+	}
+	seen := make(map[int]bool, len(order))
+	for _, id := range order {
+		if !members[id] {
+			return fmt.Errorf("book %d is not in the collection", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("book %d is listed twice", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// @Summary Переместить книгу в подборке
+// @Description Книги между старой и новой позицией сдвигаются на одну
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param book_id path int true "ID книги"
+// @Param position body object true "Новая позиция, с 1"
+// @Success 204 {string} string "Книга перемещена"
+// @Router /api/v1/collections/{id}/books/{book_id} [patch]
+func MoveBook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	bookID, err := strconv.Atoi(chi.URLParam(r, "book_id"))
+	if err != nil {
+		http.Error(w, "invalid book_id", 400)
+		return
+	}
+	var req struct {
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	collectionID, n, err := lockPositions(ctx, tx, id)
+	if err != nil {
+		writeLockError(w, err)
+		return
+	}
+	var from int
+	if err := tx.QueryRow(ctx, "SELECT position FROM collection_books WHERE collection_id=$1 AND book_id=$2", collectionID, bookID).Scan(&from); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if req.Position < 1 || req.Position > n {
+		http.Error(w, fmt.Sprintf("position must be between 1 and %d", n), 400)
+		return
+	}
+	// книга и все соседи между старой и новой позицией меняются одним
+	// оператором, поэтому уникальность позиций не нарушается
+	if _, err := tx.Exec(ctx, `UPDATE collection_books SET position = CASE
+			WHEN book_id = $2 THEN $4
+			WHEN $4 < $3 THEN position + 1
+			ELSE position - 1 END
+		WHERE collection_id = $1 AND position BETWEEN least($3::int, $4::int) AND greatest($3::int, $4::int)`,
+		collectionID, bookID, from, req.Position); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	before := membership{BookID: bookID, Position: from}
+	after := membership{BookID: bookID, Position: req.Position}
+	if !recordAudit(ctx, w, r, tx, "move_book", collectionID, before, after) {
+		return
+	}
+	if err := notifyReorder(ctx, tx, id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,107 @@
+package collections
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+)
+
+// positionRow: в подборке 1 три книги, перемещаемая стоит третьей
+type positionRow struct{}
+
+func (positionRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	if len(dest) == 3 {
+		*dest[1].(*int) = 3
+	}
+	if len(dest) == 1 {
+		*dest[0].(*int) = 3
+	}
+	return nil
+}
+
+type positionDB struct {
+	mockDB
+	execs []string
+	args  [][]any
+}
+
+func (m *positionDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.Contains(sql, "collection_members") {
+		return &accessRow{visibility: VisibilityPublic}
+	}
+	if strings.Contains(sql, "FROM books") {
+		return &mockRow{}
+	}
+	return positionRow{}
+}
+func (m *positionDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.execs = append(m.execs, sql)
+	m.args = append(m.args, args)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *positionDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func TestAddBookAtPosition(t *testing.T) {
+	database := &positionDB{}
+	SetCollectionDB(database)
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":9,"position":2}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(database.execs[0], "position = position + $4") || database.args[0][1] != 2 || database.args[0][2] != 3 || database.args[0][3] != 1 {
+		t.Fatalf("expected positions 2..3 shifted down, got %v %v", database.execs[0], database.args[0])
+	}
+	if !strings.Contains(database.execs[1], "INSERT INTO collection_books") || database.args[1][2] != 2 {
+		t.Fatalf("unexpected insert %v %v", database.execs[1], database.args[1])
+	}
+
+	w = httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":9,"position":5}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for position past the end, got %d", w.Code)
+	}
+}
+
+func TestMoveBook(t *testing.T) {
+	database := &positionDB{}
+	SetCollectionDB(database)
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	MoveBook(w, collectionRequest(http.MethodPatch, "/api/v1/collections/1/books/1", []byte(`{"position":1}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(database.args) == 0 || database.args[0][2] != 3 || database.args[0][3] != 1 {
+		t.Fatalf("expected move from 3 to 1 in one update, got %v", database.args)
+	}
+
+	w = httptest.NewRecorder()
+	MoveBook(w, collectionRequest(http.MethodPatch, "/api/v1/collections/1/books/1", []byte(`{"position":4}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestSameMembers(t *testing.T) {
+	current := []int{3, 1, 2}
+	if err := sameMembers(current, []int{1, 2, 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, order := range [][]int{{1, 2}, {1, 2, 4}, {1, 1, 2}} {
+		if err := sameMembers(current, order); err == nil {
+			t.Fatalf("expected error for %v", order)
+		}
+	}
+}
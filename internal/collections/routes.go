@@ -1,13 +1,32 @@
 package collections
 
-import "github.com/go-chi/chi/v5"
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
 
 func RegisterRoutes(r chi.Router) {
 	r.Route("/collections", func(r chi.Router) {
-		r.Post("/", CreateCollection)
-		r.Get("/", ListCollections)
-		r.Get("/{id}", GetCollection)
-		r.Post("/{id}/books", AddBookToCollection)
-		r.Delete("/{id}/books/{book_id}", RemoveBookFromCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/", CreateCollection)
+		r.With(middleware.Require(middleware.PermRead)).Get("/", ListCollections)
+		r.With(middleware.Require(middleware.PermRead)).Get("/export", ExportCollections)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}", GetCollection)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}/tree", GetTree)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Put("/{id}/parent", SetParent)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/books", AddBookToCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/books:batch", BatchBooks)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Put("/{id}/books/order", ReorderBooks)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Patch("/{id}/books/{book_id}", MoveBook)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Delete("/{id}/books/{book_id}", RemoveBookFromCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/copy", CopyCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/merge", MergeCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/split", SplitCollection)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}/share", ListMembers)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Post("/{id}/share", ShareCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Delete("/{id}/share/{member_id}", UnshareCollection)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Put("/{id}/visibility", SetVisibility)
+		r.With(middleware.Require(middleware.PermCollectionsAdmin)).Put("/{id}/owner", SetOwner)
+		r.With(middleware.Require(middleware.PermCollectionsWrite)).Put("/{id}/rule", SetRule)
 	})
 }
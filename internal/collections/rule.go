@@ -0,0 +1,248 @@
+package collections
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"books-api/internal/db"
+)
+
+// Rule — условие умной подборки над полями книг. Узел — либо and/or/not,
+// либо сравнение {"field": ..., "op": ..., "value": ...}:
+//
+//	{"and": [
+//	  {"field": "author", "op": "contains", "value": "Pratchett"},
+//	  {"field": "published_year", "op": "gt", "value": 2020}
+//	]}
+type Rule struct {
+	And   []Rule          `json:"and,omitempty"`
+	Or    []Rule          `json:"or,omitempty"`
+	Not   *Rule           `json:"not,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Ограничения на размер правила, чтобы оно не превращалось в тяжёлый SQL
+const (
+	maxRuleDepth = 8
+	maxRuleNodes = 64
+)
+
+type ruleKind int
+
+const (
+	kindString ruleKind = iota
+	kindInt
+	kindDate
+)
+
+// ruleField описывает поле книги: тип значения, допустимые операции и
+// SQL-выражение. cond строит условие сам — для связей через EXISTS.
+type ruleField struct {
+	kind ruleKind
+	ops  []string
+	expr string
+	cond func(op, param string) string
+}
+
+var (
+	stringOps   = []string{"eq", "ne", "in", "contains", "starts_with"}
+	orderedOps  = []string{"eq", "ne", "in", "gt", "gte", "lt", "lte"}
+	relationOps = []string{"eq", "in"}
+)
+
+// existsCond — условие «у книги есть связанная строка с подходящим значением»
+func existsCond(from, column string) func(op, param string) string {
+	return func(op, param string) string {
+		if op == "in" {
+			param = "ANY(" + param + ")"
+		}
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM %s AND %s = %s)", from, column, param)
+	}
+}
+
+var ruleFields = map[string]ruleField{
+	"title":          {kind: kindString, ops: stringOps, expr: "books.title"},
+	"author":         {kind: kindString, ops: stringOps, expr: "books.author"},
+	"language":       {kind: kindString, ops: stringOps, expr: "books.language"},
+	"isbn":           {kind: kindString, ops: []string{"eq", "ne", "in"}, expr: "books.isbn"},
+	"published_at":   {kind: kindDate, ops: orderedOps, expr: "books.published_at"},
+	"published_year": {kind: kindInt, ops: orderedOps, expr: "extract(year FROM books.published_at)"},
+	"author_id": {kind: kindInt, ops: relationOps,
+		cond: existsCond("book_authors ba WHERE ba.book_id = books.id", "ba.author_id")},
+	"genre": {kind: kindString, ops: relationOps,
+		cond: existsCond("book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = books.id", "g.slug")},
+}
+
+var sqlOps = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+
+// Validate проверяет правило целиком
+func (r Rule) Validate() error {
+	_, _, err := r.sql(nil)
+	return err
+}
+
+// sql превращает правило в условие WHERE над таблицей books; значения
+// уходят в args параметрами
+func (r Rule) sql(args []any) (string, []any, error) {
+	nodes := 0
+	cond, args, err := r.compile(args, 1, &nodes)
+	if err != nil {
+		return "", nil, fmt.Errorf("rule: %w", err)
+	}
+	return cond, args, nil
+}
+
+func (r Rule) compile(args []any, depth int, nodes *int) (string, []any, error) {
+	if depth > maxRuleDepth {
+		return "", nil, fmt.Errorf("nested deeper than %d", maxRuleDepth)
+	}
+	if *nodes++; *nodes > maxRuleNodes {
+		return "", nil, fmt.Errorf("more than %d conditions", maxRuleNodes)
+	}
+	kinds := 0
+	for _, set := range []bool{r.And != nil, r.Or != nil, r.Not != nil, r.Field != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return "", nil, errors.New("each node must have exactly one of and, or, not, field")
+	}
+	switch {
+	case r.Not != nil:
+		cond, args, err := r.Not.compile(args, depth+1, nodes)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + cond + ")", args, nil
+	case r.And != nil || r.Or != nil:
+		children, join := r.And, " AND "
+		if r.Or != nil {
+			children, join = r.Or, " OR "
+		}
+		if len(children) == 0 {
+			return "", nil, errors.New("and/or must not be empty")
+		}
+		parts := make([]string, len(children))
+		for i, child := range children {
+			cond, next, err := child.compile(args, depth+1, nodes)
+			if err != nil {
+				return "", nil, err
+			}
+			parts[i], args = "("+cond+")", next
+		}
+		return strings.Join(parts, join), args, nil
+	}
+	return r.compare(args)
+}
+
+func (r Rule) compare(args []any) (string, []any, error) {
+	f, ok := ruleFields[r.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %q", r.Field)
+	}
+	known := false
+	for _, op := range f.ops {
+		known = known || op == r.Op
+	}
+	if !known {
+		return "", nil, fmt.Errorf("field %s supports ops %s, got %q", r.Field, strings.Join(f.ops, ", "), r.Op)
+	}
+	value, err := f.decode(r.Field, r.Op, r.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	// contains и starts_with ищут подстроку буквально
+	if r.Op == "contains" || r.Op == "starts_with" {
+		value = db.EscapeLike(value.(string))
+	}
+	args = append(args, value)
+	param := fmt.Sprintf("$%d", len(args))
+	switch f.kind {
+	case kindInt:
+		param += "::int"
+	case kindDate:
+		param += "::date"
+	default:
+		param += "::text"
+	}
+	if r.Op == "in" {
+		param += "[]"
+	}
+	if f.cond != nil {
+		return f.cond(r.Op, param), args, nil
+	}
+	switch r.Op {
+	case "in":
+		return f.expr + " = ANY(" + param + ")", args, nil
+	case "contains":
+		return f.expr + " ILIKE '%' || " + param + " || '%'", args, nil
+	case "starts_with":
+		return f.expr + " ILIKE " + param + " || '%'", args, nil
+	}
+	return f.expr + " " + sqlOps[r.Op] + " " + param, args, nil
+}
+
+// decode читает значение нужного типа; для in — непустой массив
+func (f ruleField) decode(field, op string, raw json.RawMessage) (any, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("field %s: value is required", field)
+	}
+	if op != "in" {
+		return f.scalar(field, raw)
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil || len(items) == 0 {
+		return nil, fmt.Errorf("field %s: in needs a non-empty array", field)
+	}
+	if f.kind == kindInt {
+		out := make([]int, len(items))
+		for i, item := range items {
+			v, err := f.scalar(field, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v.(int)
+		}
+		return out, nil
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		v, err := f.scalar(field, item)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v.(string)
+	}
+	return out, nil
+}
+
+func (f ruleField) scalar(field string, raw json.RawMessage) (any, error) {
+	switch f.kind {
+	case kindInt:
+		var n int
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return nil, fmt.Errorf("field %s: value must be an integer", field)
+		}
+		return n, nil
+	case kindDate:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("field %s: value must be a YYYY-MM-DD string", field)
+		}
+		if _, err := time.Parse(time.DateOnly, s); err != nil {
+			return nil, fmt.Errorf("field %s: value must be a YYYY-MM-DD string", field)
+		}
+		return s, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("field %s: value must be a string", field)
+	}
+	return s, nil
+}
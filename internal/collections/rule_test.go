@@ -0,0 +1,103 @@
+package collections
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseRule(t *testing.T, s string) Rule {
+	t.Helper()
+	var r Rule
+	if err := json.Unmarshal([]byte(s), &r); err != nil {
+		t.Fatalf("bad rule %s: %v", s, err)
+	}
+	return r
+}
+
+func TestRuleSQL(t *testing.T) {
+	r := parseRule(t, `{"and": [
+		{"field": "author", "op": "contains", "value": "Pratchett"},
+		{"not": {"field": "published_year", "op": "lt", "value": 2020}},
+		{"field": "genre", "op": "in", "value": ["fantasy", "humor"]}
+	]}`)
+	cond, args, err := r.sql([]any{7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "(books.author ILIKE '%' || $2::text || '%') AND " +
+		"(NOT (extract(year FROM books.published_at) < $3::int)) AND " +
+		"(EXISTS (SELECT 1 FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = books.id AND g.slug = ANY($4::text[])))"
+	if cond != want {
+		t.Fatalf("unexpected sql:\n%s\nwant:\n%s", cond, want)
+	}
+	if !reflect.DeepEqual(args, []any{7, "Pratchett", 2020, []string{"fantasy", "humor"}}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestRuleLikeValuesAreLiteral(t *testing.T) {
+	cases := map[string]string{
+		`{"field": "title", "op": "contains", "value": "100% _C\\D_"}`: `100\% \_C\\D\_`,
+		`{"field": "title", "op": "starts_with", "value": "50%"}`:      `50\%`,
+		`{"field": "title", "op": "eq", "value": "50%"}`:               `50%`,
+	}
+	for s, want := range cases {
+		_, args, err := parseRule(t, s).sql(nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", s, err)
+		}
+		if args[0] != want {
+			t.Fatalf("%s: expected %q, got %q", s, want, args[0])
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	deep := `{"field": "title", "op": "eq", "value": "x"}`
+	for i := 0; i < maxRuleDepth; i++ {
+		deep = `{"not": ` + deep + `}`
+	}
+	cases := map[string]string{
+		"unknown field":  `{"field": "price", "op": "eq", "value": 1}`,
+		"unsupported op": `{"field": "isbn", "op": "contains", "value": "978"}`,
+		"wrong type":     `{"field": "published_year", "op": "gt", "value": "2020"}`,
+		"bad date":       `{"field": "published_at", "op": "gte", "value": "2020-13-01"}`,
+		"empty in":       `{"field": "author_id", "op": "in", "value": []}`,
+		"missing value":  `{"field": "title", "op": "eq"}`,
+		"empty and":      `{"and": []}`,
+		"two kinds":      `{"field": "title", "op": "eq", "value": "x", "not": {"field": "title", "op": "eq", "value": "y"}}`,
+		"too deep":       deep,
+		"empty node":     `{}`,
+	}
+	for name, s := range cases {
+		if err := parseRule(t, s).Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		} else if !strings.HasPrefix(err.Error(), "rule: ") {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+	if err := parseRule(t, `{"field": "published_at", "op": "gte", "value": "2020-01-01"}`).Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMembersSQL(t *testing.T) {
+	rule := parseRule(t, `{"field": "language", "op": "eq", "value": "ru"}`)
+	p := limited(10, 20)
+	sql, args, err := membersSQL([]Collection{{ID: 3, Rule: &rule}}, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(sql, "FROM books WHERE books.deleted_at IS NULL AND (books.language = $1::text)") || !strings.HasSuffix(sql, "LIMIT $2 OFFSET $3") {
+		t.Fatalf("unexpected sql %s", sql)
+	}
+	if !reflect.DeepEqual(args, []any{"ru", p.limit, 20}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+	sql, _, _ = membersSQL([]Collection{{ID: 3, Rule: &rule, Materialized: true}}, limited(10, 0))
+	if !strings.Contains(sql, "FROM collection_rule_books") {
+		t.Fatalf("materialized collection must read the stored members, got %s", sql)
+	}
+}
@@ -0,0 +1,348 @@
+package collections
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/render"
+)
+
+// Member — участник подборки и его роль
+type Member struct {
+	XMLName  xml.Name  `json:"-" xml:"member"`
+	MemberID string    `json:"member_id" xml:"member_id"`
+	Role     string    `json:"role" xml:"role"`
+	AddedAt  time.Time `json:"added_at" xml:"added_at"`
+}
+
+func (m Member) CSVHeader() []string {
+	return []string{"member_id", "role", "added_at"}
+}
+
+func (m Member) CSVRecord() []string {
+	return []string{m.MemberID, m.Role, m.AddedAt.Format(time.RFC3339)}
+}
+
+// sharing — состояние доступа участника для журнала
+type sharing struct {
+	MemberID string `json:"member_id"`
+	Role     string `json:"role"`
+}
+
+// @Summary Поделиться подборкой
+// @Description Выдаёт участнику роль viewer или editor; повторный вызов меняет роль.
+// @Description Доступно только владельцу.
+// @Tags collections
+// @Accept json
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Param member body sharing true "Участник и роль"
+// @Success 200 {object} Member
+// @Failure 403 {object} render.Problem
+// @Router /api/v1/collections/{id}/share [post]
+func ShareCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req sharing
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	req.MemberID = strings.TrimSpace(req.MemberID)
+	if req.MemberID == "" {
+		http.Error(w, "member_id is required", 400)
+		return
+	}
+	if req.Role != RoleViewer && req.Role != RoleEditor {
+		http.Error(w, "role must be viewer or editor", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessOwner) {
+		return
+	}
+	var collectionID int
+	var owner *string
+	if err := tx.QueryRow(ctx, "SELECT id, owner_id FROM collections WHERE id=$1", id).Scan(&collectionID, &owner); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if owner == nil {
+		http.Error(w, "collection has no owner; an admin must assign one first", 409)
+		return
+	}
+	if *owner == req.MemberID {
+		http.Error(w, "the owner already has full access", 400)
+		return
+	}
+	var before *sharing
+	var prevRole string
+	err = tx.QueryRow(ctx, "SELECT role FROM collection_members WHERE collection_id=$1 AND member_id=$2", collectionID, req.MemberID).Scan(&prevRole)
+	switch {
+	case err == nil:
+		before = &sharing{MemberID: req.MemberID, Role: prevRole}
+	case !errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	m := Member{MemberID: req.MemberID, Role: req.Role}
+	err = tx.QueryRow(ctx, `INSERT INTO collection_members (collection_id, member_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (collection_id, member_id) DO UPDATE SET role = EXCLUDED.role
+		RETURNING added_at`, collectionID, req.MemberID, req.Role).Scan(&m.AddedAt)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "share", collectionID, before, req) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("shared collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, m)
+}
+
+// @Summary Участники подборки
+// @Tags collections
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Success 200 {array} Member
+// @Router /api/v1/collections/{id}/share [get]
+func ListMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if !authorize(ctx, w, r, dbi, id, accessOwner) {
+		return
+	}
+	rows, err := dbi.Query(ctx, "SELECT member_id, role, added_at FROM collection_members WHERE collection_id=$1 ORDER BY member_id", id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.MemberID, &m.Role, &m.AddedAt); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, members)
+}
+
+// @Summary Закрыть доступ участнику
+// @Tags collections
+// @Param id path int true "ID подборки"
+// @Param member_id path string true "Участник"
+// @Success 204 {string} string "Доступ закрыт"
+// @Router /api/v1/collections/{id}/share/{member_id} [delete]
+func UnshareCollection(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessOwner) {
+		return
+	}
+	var collectionID int
+	before := sharing{MemberID: chi.URLParam(r, "member_id")}
+	row := tx.QueryRow(ctx, "DELETE FROM collection_members WHERE collection_id=$1 AND member_id=$2 RETURNING collection_id, role", id, before.MemberID)
+	if err := row.Scan(&collectionID, &before.Role); err != nil {
+		http.Error(w, "not found", 404)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "unshare", collectionID, before, nil) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("unshared collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// @Summary Изменить видимость подборки
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param visibility body object true "private, unlisted или public"
+// @Success 204 {string} string "Видимость изменена"
+// @Router /api/v1/collections/{id}/visibility [put]
+func SetVisibility(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req struct {
+		Visibility string `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if !validVisibility(req.Visibility) {
+		http.Error(w, "visibility must be private, unlisted or public", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessOwner) {
+		return
+	}
+	var collectionID int
+	var owner *string
+	var before string
+	if err := tx.QueryRow(ctx, "SELECT id, owner_id, visibility FROM collections WHERE id=$1 FOR UPDATE", id).Scan(&collectionID, &owner, &before); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if owner == nil && req.Visibility != VisibilityPublic {
+		http.Error(w, "a collection without an owner must stay public", 409)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE collections SET visibility=$1 WHERE id=$2", req.Visibility, collectionID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	type state struct {
+		Visibility string `json:"visibility"`
+	}
+	if !recordAudit(ctx, w, r, tx, "visibility", collectionID, state{before}, state{req.Visibility}) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("updated collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// ownership — владелец подборки для журнала и запроса
+type ownership struct {
+	OwnerID *string `json:"owner_id"`
+}
+
+// @Summary Назначить владельца подборки
+// @Description Только admin. Так подборки без владельца, оставшиеся с прежних
+// @Description времён, снова можно менять и делиться ими. Если новый владелец был
+// @Description участником, его роль снимается.
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param owner body ownership true "Новый владелец"
+// @Success 204 {string} string "Владелец назначен"
+// @Failure 404 {string} string "не найдено"
+// @Router /api/v1/collections/{id}/owner [put]
+func SetOwner(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req ownership
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.OwnerID == nil || strings.TrimSpace(*req.OwnerID) == "" {
+		http.Error(w, "owner_id is required", 400)
+		return
+	}
+	owner := strings.TrimSpace(*req.OwnerID)
+	req.OwnerID = &owner
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	var collectionID int
+	var before ownership
+	err = tx.QueryRow(ctx, "SELECT id, owner_id FROM collections WHERE id=$1 FOR UPDATE", id).Scan(&collectionID, &before.OwnerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "не найдено", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM collection_members WHERE collection_id=$1 AND member_id=$2", collectionID, owner); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE collections SET owner_id=$1 WHERE id=$2", owner, collectionID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "set_owner", collectionID, before, req) {
+		return
+	}
+	if err := notify(ctx, tx, "updated collection: "+id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
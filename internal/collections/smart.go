@@ -0,0 +1,262 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+)
+
+// refreshCollection пересобирает сохранённый состав умной подборки
+func refreshCollection(ctx context.Context, tx db.TxDB, id int, rule Rule) error {
+	cond, args, err := rule.sql([]any{id})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM collection_rule_books WHERE collection_id=$1", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO collection_rule_books (collection_id, book_id)
+		SELECT $1, books.id FROM books WHERE books.deleted_at IS NULL AND (`+cond+`)`, args...); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "UPDATE collections SET rule_refreshed_at = now() WHERE id=$1", id)
+	return err
+}
+
+// RefreshSmart пересчитывает все материализованные умные подборки одной
+// транзакцией и возвращает их число
+func RefreshSmart(ctx context.Context, database db.TxDB) (int, error) {
+	tx, err := database.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	rows, err := tx.Query(ctx, "SELECT id, rule FROM collections WHERE materialized ORDER BY id FOR UPDATE")
+	if err != nil {
+		return 0, err
+	}
+	type smart struct {
+		id   int
+		rule Rule
+	}
+	var list []smart
+	for rows.Next() {
+		var s smart
+		var raw []byte
+		if err := rows.Scan(&s.id, &raw); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(raw, &s.rule); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, s := range list {
+		if err := refreshCollection(ctx, tx, s.id, s.rule); err != nil {
+			return 0, err
+		}
+	}
+	return len(list), tx.Commit(ctx)
+}
+
+// refreshTriggers — события, после которых состав умных подборок мог
+// измениться: книги, а также авторы и жанры, по которым строятся правила
+var refreshTriggers = []string{
+	"created book:", "updated book:", "deleted book:", "restored book:", "purged books:", "imported books:",
+	"updated author:", "deleted author:", "updated genre:", "deleted genre:",
+}
+
+// IsRefreshTrigger сообщает, нужно ли после события пересчитать подборки
+func IsRefreshTrigger(value []byte) bool {
+	for _, prefix := range refreshTriggers {
+		if strings.HasPrefix(string(value), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MessageReader — источник событий (обычно *kafka.Reader с группой)
+type MessageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Refresher читает события из Kafka и пересчитывает материализованные
+// подборки. События копятся не дольше Interval, так что импорт тысячи книг
+// даёт один пересчёт; смещения коммитятся только после пересчёта.
+type Refresher struct {
+	DB       db.TxDB
+	Reader   MessageReader
+	Interval time.Duration
+}
+
+func NewRefresher(database db.TxDB, reader MessageReader, interval time.Duration) *Refresher {
+	return &Refresher{DB: database, Reader: reader, Interval: interval}
+}
+
+// Run работает до отмены контекста или ошибки чтения
+func (r *Refresher) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs := make(chan kafka.Message)
+	fetchErr := make(chan error, 1)
+	go func() {
+		for {
+			m, err := r.Reader.FetchMessage(ctx)
+			if err != nil {
+				fetchErr <- err
+				return
+			}
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	var pending []kafka.Message
+	dirty := false
+	for {
+		select {
+		case m := <-msgs:
+			pending = append(pending, m)
+			dirty = dirty || IsRefreshTrigger(m.Value)
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+			if dirty {
+				n, err := RefreshSmart(ctx, r.DB)
+				if err != nil {
+					// не коммитим: события придут снова после перезапуска
+					log.Printf("ошибка пересчёта умных подборок: %v", err)
+					continue
+				}
+				log.Printf("пересчитано умных подборок: %d", n)
+			}
+			if err := r.Reader.CommitMessages(ctx, pending...); err != nil {
+				return err
+			}
+			pending, dirty = nil, false
+		case err := <-fetchErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ruleRequest — новое правило подборки; rule: null превращает её обратно
+// в обычную
+type ruleRequest struct {
+	Rule         *Rule `json:"rule"`
+	Materialized bool  `json:"materialized"`
+}
+
+// @Summary Задать правило умной подборки
+// @Description Подборка с правилом не может содержать книг, добавленных вручную.
+// @Description materialized=true сохраняет состав и пересчитывает его по событиям книг.
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param rule body ruleRequest true "Правило"
+// @Success 204 {string} string "Правило сохранено"
+// @Failure 409 {string} string "в подборке есть книги"
+// @Router /api/v1/collections/{id}/rule [put]
+func SetRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	var req ruleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var rule []byte
+	if req.Rule != nil {
+		if err := req.Rule.Validate(); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		rule, _ = json.Marshal(req.Rule)
+	} else if req.Materialized {
+		http.Error(w, "only a collection with a rule can be materialized", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	before, err := scanCollection(tx.QueryRow(ctx, "SELECT "+collectionColumns+" FROM collections c WHERE c.id=$1 FOR UPDATE", id))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var manual int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM collection_books WHERE collection_id=$1", before.ID).Scan(&manual); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if req.Rule != nil && manual > 0 {
+		http.Error(w, "collection has manually added books, remove them before setting a rule", 409)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE collections SET rule=$1, materialized=$2, rule_refreshed_at=NULL WHERE id=$3", rule, req.Materialized, before.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if req.Materialized {
+		err = refreshCollection(ctx, tx, before.ID, *req.Rule)
+	} else {
+		_, err = tx.Exec(ctx, "DELETE FROM collection_rule_books WHERE collection_id=$1", before.ID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "set_rule", before.ID, ruleRequest{before.Rule, before.Materialized}, req) {
+		return
+	}
+	if producer != nil {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte("updated collection: " + id)}); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,190 @@
+package collections
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// smartRow — подборка 1 задана правилом
+type smartRow struct{}
+
+func (smartRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	*dest[1].(*int) = 0
+	*dest[2].(*bool) = true
+	return nil
+}
+
+type smartDB struct {
+	mockDB
+	mu    sync.Mutex
+	execs []string
+}
+
+func (m *smartDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.Contains(sql, "collection_members") {
+		return &accessRow{visibility: VisibilityPublic}
+	}
+	return smartRow{}
+}
+func (m *smartDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &ruleRows{}, nil
+}
+func (m *smartDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.execs = append(m.execs, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *smartDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+// ruleRows — одна материализованная подборка
+type ruleRows struct{ idx int }
+
+func (r *ruleRows) Next() bool { r.idx++; return r.idx == 1 }
+func (r *ruleRows) Scan(dest ...any) error {
+	*dest[0].(*int) = 5
+	*dest[1].(*[]byte) = []byte(`{"field": "author", "op": "eq", "value": "Le Guin"}`)
+	return nil
+}
+func (r *ruleRows) Close()     {}
+func (r *ruleRows) Err() error { return nil }
+
+func TestSmartCollectionRejectsManualBooks(t *testing.T) {
+	SetCollectionDB(&smartDB{})
+	SetProducer(&mockProducer{})
+	w := httptest.NewRecorder()
+	AddBookToCollection(w, collectionRequest(http.MethodPost, "/api/v1/collections/1/books", []byte(`{"book_id":9}`)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	RemoveBookFromCollection(w, collectionRequest(http.MethodDelete, "/api/v1/collections/1/books/1", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateCollectionRule(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	testutil.AsUser(t, "alice", middleware.RoleEditor)
+	for body, code := range map[string]int{
+		`{"name":"Smart","materialized":true}`:                                http.StatusBadRequest,
+		`{"name":"Smart","rule":{"field":"price","op":"eq","value":1}}`:       http.StatusBadRequest,
+		`{"name":"Smart","rule":{"field":"language","op":"eq","value":"ru"}}`: http.StatusCreated,
+	} {
+		w := httptest.NewRecorder()
+		CreateCollection(w, httptest.NewRequest(http.MethodPost, "/api/v1/collections", strings.NewReader(body)))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d: %s", body, code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRefreshSmart(t *testing.T) {
+	database := &smartDB{}
+	n, err := RefreshSmart(context.Background(), database)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one refreshed collection, got %d, %v", n, err)
+	}
+	if len(database.execs) != 3 || !strings.Contains(database.execs[1], "INSERT INTO collection_rule_books") ||
+		!strings.Contains(database.execs[1], "books.author = $2::text") {
+		t.Fatalf("unexpected statements %v", database.execs)
+	}
+}
+
+func TestIsRefreshTrigger(t *testing.T) {
+	for _, msg := range []string{"created book: Dune", "purged books: 3", "updated genre: 2"} {
+		if !IsRefreshTrigger([]byte(msg)) {
+			t.Errorf("%q must trigger a refresh", msg)
+		}
+	}
+	for _, msg := range []string{"created collection: 1", "reordered collection: 1", ""} {
+		if IsRefreshTrigger([]byte(msg)) {
+			t.Errorf("%q must not trigger a refresh", msg)
+		}
+	}
+}
+
+// fakeReader отдаёт сообщения по очереди, потом ждёт отмены контекста
+type fakeReader struct {
+	msgs      chan kafka.Message
+	committed chan []kafka.Message
+}
+
+func (f *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-f.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+func (f *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	f.committed <- msgs
+	return nil
+}
+
+func TestRefresherCoalescesEvents(t *testing.T) {
+	database := &smartDB{}
+	reader := &fakeReader{msgs: make(chan kafka.Message, 3), committed: make(chan []kafka.Message, 1)}
+	for _, v := range []string{"created book: A", "updated book: 1", "created collection: 2"} {
+		reader.msgs <- kafka.Message{Value: []byte(v)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- NewRefresher(database, reader, 20*time.Millisecond).Run(ctx) }()
+
+	var committed []kafka.Message
+	for len(committed) < 3 {
+		select {
+		case msgs := <-reader.committed:
+			committed = append(committed, msgs...)
+		case <-time.After(2 * time.Second):
+			t.Fatal("messages were not committed")
+		}
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	database.mu.Lock()
+	defer database.mu.Unlock()
+	inserts := 0
+	for _, sql := range database.execs {
+		if strings.Contains(sql, "INSERT INTO collection_rule_books") {
+			inserts++
+		}
+	}
+	if inserts < 1 || inserts > 2 {
+		t.Fatalf("expected events to be coalesced into few refreshes, got %d", inserts)
+	}
+}
+
+func TestSetRuleValidation(t *testing.T) {
+	SetCollectionDB(&mockDB{})
+	for _, body := range []string{`{"materialized":true}`, `{"rule":{"and":[]}}`, `{`} {
+		w := httptest.NewRecorder()
+		SetRule(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/rule", []byte(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+// maxTreeDepth ограничивает обход вложенных подборок
+const maxTreeDepth = 16
+
+// subtree возвращает подборку id и все её вложенные подборки, видимые v,
+// по уровням. Невидимая подборка скрывает и всё, что в неё вложено.
+func subtree(ctx context.Context, q db.TxDB, id int, v Viewer) ([]Collection, error) {
+	cond, args := v.listCond([]any{id})
+	rows, err := q.Query(ctx, fmt.Sprintf(`WITH RECURSIVE tree AS (
+			SELECT c.id, 0 AS depth FROM collections c WHERE c.id = $1
+			UNION ALL
+			SELECT c.id, tree.depth + 1 FROM collections c JOIN tree ON c.parent_id = tree.id
+			WHERE tree.depth < %d AND %s
+		)
+		SELECT %s FROM tree JOIN collections c ON c.id = tree.id ORDER BY tree.depth, c.id`, maxTreeDepth, cond, collectionColumns), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// TreeNode — подборка с вложенными в неё
+type TreeNode struct {
+	XMLName    xml.Name   `json:"-" xml:"collection"`
+	ID         int        `json:"id" xml:"id"`
+	Name       string     `json:"name" xml:"name"`
+	Visibility string     `json:"visibility" xml:"visibility"`
+	Children   []TreeNode `json:"children" xml:"children>collection"`
+}
+
+// buildTree собирает дерево из списка subtree: родители идут раньше детей
+func buildTree(list []Collection) TreeNode {
+	children := map[int][]Collection{}
+	for _, c := range list[1:] {
+		children[*c.ParentID] = append(children[*c.ParentID], c)
+	}
+	var build func(c Collection) TreeNode
+	build = func(c Collection) TreeNode {
+		n := TreeNode{ID: c.ID, Name: c.Name, Visibility: c.Visibility, Children: []TreeNode{}}
+		for _, child := range children[c.ID] {
+			n.Children = append(n.Children, build(child))
+		}
+		return n
+	}
+	return build(list[0])
+}
+
+// @Summary Дерево вложенных подборок
+// @Description Подборка и все вложенные в неё, которые видны вызывающему
+// @Tags collections
+// @Produce json
+// @Param id path int true "ID подборки"
+// @Success 200 {object} TreeNode
+// @Failure 404 {string} string "не найдено"
+// @Router /api/v1/collections/{id}/tree [get]
+func GetTree(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	if !authorize(r.Context(), w, r, dbi, strconv.Itoa(id), accessView) {
+		return
+	}
+	list, err := subtree(r.Context(), dbi, id, viewerFrom(r))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(list) == 0 {
+		http.Error(w, "не найдено", 404)
+		return
+	}
+	render.Render(w, r, http.StatusOK, buildTree(list))
+}
+
+// parentChange — родитель подборки; null делает её корневой
+type parentChange struct {
+	ParentID *int `json:"parent_id"`
+}
+
+// @Summary Вложить подборку в другую
+// @Description Нужны права редактора на обе подборки. Вложение, которое
+// @Description замкнуло бы цикл или сделало дерево глубже 16 уровней, отклоняется.
+// @Tags collections
+// @Accept json
+// @Param id path int true "ID подборки"
+// @Param parent body parentChange true "ID родителя или null"
+// @Success 204 {string} string "Родитель сохранён"
+// @Failure 409 {string} string "получился бы цикл или слишком глубокое дерево"
+// @Router /api/v1/collections/{id}/parent [put]
+func SetParent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	collectionID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	var req parentChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.ParentID != nil && *req.ParentID == collectionID {
+		http.Error(w, "a collection cannot contain itself", 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	// две встречные перестановки по отдельности цикла не дают, а вместе —
+	// дают, поэтому изменения иерархии идут по одному
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('collections_tree'))"); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !authorize(ctx, w, r, tx, id, accessEdit) {
+		return
+	}
+	if req.ParentID != nil {
+		if !authorize(ctx, w, r, tx, strconv.Itoa(*req.ParentID), accessEdit) {
+			return
+		}
+		// цикл будет, если подборка уже среди предков нового родителя. Предки
+		// обходятся без ограничения глубины: под блокировкой дерево ацикличное,
+		// а UNION остановит обход, даже если цикл уже есть в данных
+		var cycle bool
+		var depth int
+		err := tx.QueryRow(ctx, fmt.Sprintf(`WITH RECURSIVE up AS (
+				SELECT id, parent_id FROM collections WHERE id = $1
+				UNION
+				SELECT c.id, c.parent_id FROM collections c JOIN up ON c.id = up.parent_id
+			), down AS (
+				SELECT id, 0 AS depth FROM collections WHERE id = $2
+				UNION ALL
+				SELECT c.id, down.depth + 1 FROM collections c JOIN down ON c.parent_id = down.id WHERE down.depth <= %d
+			)
+			SELECT EXISTS (SELECT 1 FROM up WHERE id = $2),
+				(SELECT count(*) FROM up) + (SELECT COALESCE(max(depth), 0) FROM down)`, maxTreeDepth), *req.ParentID, collectionID).Scan(&cycle, &depth)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if cycle {
+			http.Error(w, fmt.Sprintf("collection %d is already nested inside collection %d", *req.ParentID, collectionID), 409)
+			return
+		}
+		// глубже maxTreeDepth дерево не покажет, поэтому и вкладывать так не даём
+		if depth > maxTreeDepth {
+			http.Error(w, fmt.Sprintf("collections cannot be nested more than %d levels deep", maxTreeDepth), 409)
+			return
+		}
+	}
+	var before parentChange
+	if err := tx.QueryRow(ctx, "SELECT parent_id FROM collections WHERE id=$1 FOR UPDATE", collectionID).Scan(&before.ParentID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if _, err := tx.Exec(ctx, "UPDATE collections SET parent_id=$1 WHERE id=$2", req.ParentID, collectionID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !recordAudit(ctx, w, r, tx, "set_parent", collectionID, before, req) {
+		return
+	}
+	parent := "none"
+	if req.ParentID != nil {
+		parent = strconv.Itoa(*req.ParentID)
+	}
+	if err := notify(ctx, tx, "moved collection: "+id+" parent="+parent); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,167 @@
+package collections
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+func intPtr(n int) *int { return &n }
+
+// shelf: 1 → (2 → 4), 3
+var shelf = []Collection{
+	{ID: 1, Name: "Summer reading", Visibility: VisibilityPublic},
+	{ID: 2, Name: "Beach thrillers", Visibility: VisibilityPublic, ParentID: intPtr(1)},
+	{ID: 3, Name: "Light sci-fi", Visibility: VisibilityPublic, ParentID: intPtr(1)},
+	{ID: 4, Name: "Noir", Visibility: VisibilityPublic, ParentID: intPtr(2)},
+}
+
+func TestBuildTree(t *testing.T) {
+	tree := buildTree(shelf)
+	if tree.ID != 1 || len(tree.Children) != 2 || tree.Children[0].ID != 2 || tree.Children[1].ID != 3 {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+	if len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].ID != 4 || len(tree.Children[1].Children) != 0 {
+		t.Fatalf("unexpected grandchildren %+v", tree.Children)
+	}
+}
+
+func TestTreeMembersSQL(t *testing.T) {
+	rule := parseRule(t, `{"field": "genre", "op": "eq", "value": "sci-fi"}`)
+	cs := []Collection{{ID: 1}, {ID: 2, Rule: &rule}, {ID: 3, Rule: &rule, Materialized: true}, {ID: 4}}
+	p := limited(10, 0)
+	sql, args, err := membersSQL(cs, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, part := range []string{
+		"g.slug = $1::text",
+		"collection_books WHERE collection_id = ANY($2::int[])",
+		"collection_rule_books WHERE collection_id = ANY($3::int[])",
+		"ORDER BY books.id LIMIT $4 OFFSET $5",
+	} {
+		if !strings.Contains(sql, part) {
+			t.Errorf("expected %q in %s", part, sql)
+		}
+	}
+	if !reflect.DeepEqual(args, []any{"sci-fi", []int{1, 4}, []int{3}, p.limit, 0}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+// shelfRows — строки subtree из shelf
+type shelfRows struct{ idx int }
+
+func (r *shelfRows) Next() bool { r.idx++; return r.idx <= len(shelf) }
+func (r *shelfRows) Scan(dest ...any) error {
+	c := shelf[r.idx-1]
+	*dest[0].(*int) = c.ID
+	*dest[1].(*string) = c.Name
+	*dest[4].(*string) = c.Visibility
+	*dest[7].(**int) = c.ParentID
+	return nil
+}
+func (r *shelfRows) Close()     {}
+func (r *shelfRows) Err() error { return nil }
+
+// treeDB: subtree отдаёт shelf, cycle и depth — ответ проверки на цикл и
+// глубину дерева после переноса
+type treeDB struct {
+	copyDB
+	cycle bool
+	depth int
+}
+
+func (m *treeDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	if strings.HasPrefix(sql, "WITH RECURSIVE tree") {
+		return &shelfRows{}, nil
+	}
+	return m.copyDB.Query(ctx, sql, args...)
+}
+func (m *treeDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	if strings.HasPrefix(sql, "WITH RECURSIVE up") {
+		return nestingRow{m.cycle, m.depth}
+	}
+	if strings.HasPrefix(sql, "SELECT parent_id") {
+		return parentRow{}
+	}
+	return m.copyDB.QueryRow(ctx, sql, args...)
+}
+func (m *treeDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+type nestingRow struct {
+	cycle bool
+	depth int
+}
+
+func (r nestingRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = r.cycle
+	*dest[1].(*int) = r.depth
+	return nil
+}
+
+type parentRow struct{}
+
+func (parentRow) Scan(dest ...any) error { return nil }
+
+func TestGetTree(t *testing.T) {
+	SetCollectionDB(&treeDB{})
+	w := httptest.NewRecorder()
+	GetTree(w, collectionRequest(http.MethodGet, "/api/v1/collections/1/tree", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tree TreeNode
+	if err := json.NewDecoder(w.Body).Decode(&tree); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if tree.Name != "Summer reading" || len(tree.Children) != 2 || tree.Children[0].Children[0].Name != "Noir" {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+}
+
+func TestSetParent(t *testing.T) {
+	database := &treeDB{}
+	SetCollectionDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+	w := httptest.NewRecorder()
+	SetParent(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/parent", []byte(`{"parent_id":5}`)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if !database.executed("UPDATE collections SET parent_id") || len(events.Msgs) != 1 || events.Msgs[0] != "moved collection: 1 parent=5" {
+		t.Fatalf("unexpected statements %v, events %v", database.execs, events.Msgs)
+	}
+
+	database.depth = maxTreeDepth + 1
+	w = httptest.NewRecorder()
+	SetParent(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/parent", []byte(`{"parent_id":5}`)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a too deep tree, got %d", w.Code)
+	}
+
+	database.cycle, database.depth = true, 0
+	w = httptest.NewRecorder()
+	SetParent(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/parent", []byte(`{"parent_id":4}`)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a cycle, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	SetParent(w, collectionRequest(http.MethodPut, "/api/v1/collections/1/parent", []byte(`{"parent_id":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for itself, got %d", w.Code)
+	}
+}
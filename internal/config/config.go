@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config — общие настройки для всех подкоманд бинарника
+type Config struct {
+	DatabaseDSN    string
+	KafkaBrokers   []string
+	KafkaTopic     string
+	HTTPAddr       string
+	OutboxEmbedded bool
+	OutboxInterval time.Duration
+	TrashRetention time.Duration
+	// JWT: HS256 с общим секретом и/или RS256/ES256 по JWKS из файла или URL
+	JWTSecret   string
+	JWKSFile    string
+	JWKSURL     string
+	JWKSTTL     time.Duration
+	JWTIssuer   string
+	JWTAudience string
+	// AuthIdentity — откуда брать пользователя для проверки прав:
+	// token (JWT), header (X-User/X-Roles от доверенного прокси) или none
+	AuthIdentity string
+}
+
+// Load читает настройки из переменных окружения
+func Load() (Config, error) {
+	cfg := Config{
+		DatabaseDSN:    os.Getenv("DATABASE_DSN"),
+		KafkaBrokers:   splitList(getenv("KAFKA_BROKERS", "localhost:9092")),
+		KafkaTopic:     getenv("KAFKA_TOPIC", "books-events"),
+		HTTPAddr:       getenv("HTTP_ADDR", ":8080"),
+		OutboxEmbedded: true,
+		OutboxInterval: time.Second,
+		TrashRetention: 30 * 24 * time.Hour,
+		JWTSecret:      os.Getenv("JWT_SECRET"),
+		JWKSFile:       os.Getenv("JWT_JWKS_FILE"),
+		JWKSURL:        os.Getenv("JWT_JWKS_URL"),
+		JWKSTTL:        time.Hour,
+		JWTIssuer:      os.Getenv("JWT_ISSUER"),
+		JWTAudience:    os.Getenv("JWT_AUDIENCE"),
+	}
+	if cfg.DatabaseDSN == "" {
+		return cfg, errors.New("DATABASE_DSN is not set")
+	}
+	if v := os.Getenv("OUTBOX_RELAY_EMBEDDED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, errors.New("OUTBOX_RELAY_EMBEDDED: " + err.Error())
+		}
+		cfg.OutboxEmbedded = b
+	}
+	if v := os.Getenv("OUTBOX_RELAY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, errors.New("OUTBOX_RELAY_INTERVAL: " + err.Error())
+		}
+		cfg.OutboxInterval = d
+	}
+	if v := os.Getenv("BOOKS_TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, errors.New("BOOKS_TRASH_RETENTION: " + err.Error())
+		}
+		cfg.TrashRetention = d
+	}
+	if v := os.Getenv("JWT_JWKS_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, errors.New("JWT_JWKS_TTL: " + err.Error())
+		}
+		cfg.JWKSTTL = d
+	}
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return cfg, errors.New("JWT_JWKS_FILE and JWT_JWKS_URL are mutually exclusive")
+	}
+	// без настроенного JWT по умолчанию права не проверяются
+	cfg.AuthIdentity = os.Getenv("AUTH_IDENTITY")
+	if cfg.AuthIdentity == "" {
+		cfg.AuthIdentity = "none"
+		if cfg.JWTSecret != "" || cfg.JWKSFile != "" || cfg.JWKSURL != "" {
+			cfg.AuthIdentity = "token"
+		}
+	}
+	switch cfg.AuthIdentity {
+	case "token", "header", "none":
+	default:
+		return cfg, errors.New("AUTH_IDENTITY must be token, header or none")
+	}
+	return cfg, nil
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
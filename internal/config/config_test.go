@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "postgres://localhost/books")
+	t.Setenv("KAFKA_BROKERS", "")
+	t.Setenv("KAFKA_TOPIC", "")
+	t.Setenv("HTTP_ADDR", "")
+	t.Setenv("OUTBOX_RELAY_EMBEDDED", "")
+	t.Setenv("OUTBOX_RELAY_INTERVAL", "")
+	t.Setenv("BOOKS_TRASH_RETENTION", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_JWKS_FILE", "")
+	t.Setenv("JWT_JWKS_URL", "")
+	t.Setenv("AUTH_IDENTITY", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.KafkaBrokers) != 1 || cfg.KafkaBrokers[0] != "localhost:9092" {
+		t.Errorf("unexpected brokers: %v", cfg.KafkaBrokers)
+	}
+	if cfg.KafkaTopic != "books-events" || cfg.HTTPAddr != ":8080" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if !cfg.OutboxEmbedded || cfg.OutboxInterval != time.Second {
+		t.Errorf("unexpected outbox config: %+v", cfg)
+	}
+	if cfg.TrashRetention != 30*24*time.Hour {
+		t.Errorf("unexpected trash retention: %v", cfg.TrashRetention)
+	}
+	if cfg.AuthIdentity != "none" {
+		t.Errorf("unexpected auth identity: %q", cfg.AuthIdentity)
+	}
+}
+
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "postgres://localhost/books")
+	t.Setenv("KAFKA_BROKERS", "kafka-1:9092, kafka-2:9092")
+	t.Setenv("OUTBOX_RELAY_EMBEDDED", "false")
+	t.Setenv("OUTBOX_RELAY_INTERVAL", "5s")
+	t.Setenv("BOOKS_TRASH_RETENTION", "168h")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.KafkaBrokers) != 2 || cfg.KafkaBrokers[1] != "kafka-2:9092" {
+		t.Errorf("unexpected brokers: %v", cfg.KafkaBrokers)
+	}
+	if cfg.OutboxEmbedded || cfg.OutboxInterval != 5*time.Second {
+		t.Errorf("unexpected outbox config: %+v", cfg)
+	}
+	if cfg.TrashRetention != 7*24*time.Hour {
+		t.Errorf("unexpected trash retention: %v", cfg.TrashRetention)
+	}
+}
+
+func TestLoadJWKSSourcesExclusive(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "postgres://localhost/books")
+	t.Setenv("JWT_JWKS_FILE", "/etc/books/jwks.json")
+	t.Setenv("JWT_JWKS_URL", "https://idp.example.com/.well-known/jwks.json")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error when both JWKS sources are set")
+	}
+}
+
+func TestLoadAuthIdentity(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "postgres://localhost/books")
+	t.Setenv("JWT_SECRET", "s3cret")
+	t.Setenv("AUTH_IDENTITY", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuthIdentity != "token" {
+		t.Errorf("expected token identity with JWT configured, got %q", cfg.AuthIdentity)
+	}
+	t.Setenv("AUTH_IDENTITY", "cookie")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error for unknown AUTH_IDENTITY")
+	}
+}
+
+func TestLoadRequiresDSN(t *testing.T) {
+	t.Setenv("DATABASE_DSN", "")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected error without DATABASE_DSN")
+	}
+}
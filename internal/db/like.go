@@ -0,0 +1,13 @@
+package db
+
+import "strings"
+
+// likeEscaper экранирует спецсимволы LIKE (экранирующий символ по умолчанию —
+// обратная косая черта)
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// EscapeLike готовит значение для поиска подстроки через LIKE/ILIKE:
+// % и _ в нём ищутся буквально, а не как шаблон
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version TEXT PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+)`
+
+// Migrate применяет ещё не применённые *.sql файлы из fsys в лексикографическом
+// порядке. Каждый файл выполняется в отдельной транзакции вместе с записью в
+// schema_migrations. Возвращает имена применённых файлов.
+func Migrate(ctx context.Context, database TxDB, fsys fs.FS) ([]string, error) {
+	if _, err := database.Exec(ctx, createMigrationsTable); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var applied []string
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+		var exists bool
+		row := database.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version=$1)", version)
+		if err := row.Scan(&exists); err != nil {
+			return applied, fmt.Errorf("check %s: %w", name, err)
+		}
+		if exists {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return applied, err
+		}
+		if err := applyMigration(ctx, database, version, string(data)); err != nil {
+			return applied, fmt.Errorf("apply %s: %w", name, err)
+		}
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, database TxDB, version, sql string) error {
+	tx, err := database.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
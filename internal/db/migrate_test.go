@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type fakeRow struct{ exists bool }
+
+func (r fakeRow) Scan(dest ...any) error {
+	*dest[0].(*bool) = r.exists
+	return nil
+}
+
+type fakeMigrationDB struct {
+	applied map[string]bool
+	execs   []string
+}
+
+func (f *fakeMigrationDB) Query(ctx context.Context, sql string, args ...any) (Rows, error) {
+	return nil, nil
+}
+func (f *fakeMigrationDB) QueryRow(ctx context.Context, sql string, args ...any) Row {
+	return fakeRow{exists: f.applied[args[0].(string)]}
+}
+func (f *fakeMigrationDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	f.execs = append(f.execs, sql)
+	if strings.HasPrefix(sql, "INSERT INTO schema_migrations") {
+		f.applied[args[0].(string)] = true
+	}
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (f *fakeMigrationDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (TxDB, error) {
+	return f, nil
+}
+func (f *fakeMigrationDB) Rollback(ctx context.Context) error { return nil }
+func (f *fakeMigrationDB) Commit(ctx context.Context) error   { return nil }
+
+func TestMigrateAppliesPendingInOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_b.sql":  {Data: []byte("CREATE TABLE b ()")},
+		"001_a.sql":  {Data: []byte("CREATE TABLE a ()")},
+		"README.txt": {Data: []byte("not a migration")},
+	}
+	fake := &fakeMigrationDB{applied: map[string]bool{}}
+	applied, err := Migrate(context.Background(), fake, fsys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 2 || applied[0] != "001_a.sql" || applied[1] != "002_b.sql" {
+		t.Fatalf("unexpected applied list: %v", applied)
+	}
+
+	applied, err = Migrate(context.Background(), fake, fsys)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("expected nothing to apply on second run, got %v", applied)
+	}
+}
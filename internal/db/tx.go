@@ -22,6 +22,8 @@ type Rows interface {
 	Next() bool
 	Scan(dest ...any) error
 	Close()
+	// Err — ошибка, на которой оборвался перебор; проверяется после цикла Next
+	Err() error
 }
 
 type Row interface {
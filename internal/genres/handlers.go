@@ -0,0 +1,374 @@
+package genres
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+
+func SetGenreDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+// Genre — узел иерархии жанров (список смежности через parent_id)
+type Genre struct {
+	XMLName  xml.Name `json:"-" xml:"genre"`
+	ID       int      `json:"id" xml:"id"`
+	Name     string   `json:"name" xml:"name"`
+	Slug     string   `json:"slug" xml:"slug"`
+	ParentID *int     `json:"parent_id" xml:"parent_id,omitempty"`
+	Children []Genre  `json:"children,omitempty" xml:"children>genre,omitempty"`
+}
+
+func (g Genre) CSVHeader() []string {
+	return []string{"id", "name", "slug", "parent_id"}
+}
+
+func (g Genre) CSVRecord() []string {
+	parent := ""
+	if g.ParentID != nil {
+		parent = strconv.Itoa(*g.ParentID)
+	}
+	return []string{strconv.Itoa(g.ID), g.Name, g.Slug, parent}
+}
+
+// Slugify делает из названия жанра идентификатор для URL: строчные буквы,
+// цифры и дефисы вместо всего остального
+func Slugify(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "-")
+}
+
+// createsCycleSQL проверяет, не является ли новый родитель самим жанром
+// или его потомком
+const createsCycleSQL = `WITH RECURSIVE subtree AS (
+	SELECT id FROM genres WHERE id = $1
+	UNION
+	SELECT g.id FROM genres g JOIN subtree s ON g.parent_id = s.id
+)
+SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`
+
+func sendEvent(ctx context.Context, tx db.TxDB, msg string) error {
+	if producer == nil {
+		return nil
+	}
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func decodeGenre(w http.ResponseWriter, r *http.Request) (Genre, bool) {
+	var g Genre
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		http.Error(w, err.Error(), 400)
+		return g, false
+	}
+	if g.Name = strings.TrimSpace(g.Name); g.Name == "" {
+		http.Error(w, "name is required", 400)
+		return g, false
+	}
+	if g.Slug == "" {
+		g.Slug = Slugify(g.Name)
+	}
+	if g.Slug != Slugify(g.Slug) || g.Slug == "" {
+		http.Error(w, "slug may contain only lowercase letters, digits and dashes", 400)
+		return g, false
+	}
+	// /genres/{id} принимает и ID, и slug: числовой slug было бы не отличить от ID
+	if _, err := strconv.Atoi(g.Slug); err == nil {
+		http.Error(w, "slug must not be a number", 400)
+		return g, false
+	}
+	return g, true
+}
+
+// buildTree раскладывает плоский список по родителям
+func buildTree(flat []Genre) []Genre {
+	children := make(map[int][]Genre)
+	var roots []Genre
+	for _, g := range flat {
+		if g.ParentID == nil {
+			roots = append(roots, g)
+		} else {
+			children[*g.ParentID] = append(children[*g.ParentID], g)
+		}
+	}
+	var attach func(gs []Genre) []Genre
+	attach = func(gs []Genre) []Genre {
+		for i := range gs {
+			gs[i].Children = attach(children[gs[i].ID])
+		}
+		return gs
+	}
+	if roots == nil {
+		roots = []Genre{}
+	}
+	return attach(roots)
+}
+
+// @Summary Получить список жанров
+// @Tags genres
+// @Produce json
+// @Param tree query bool false "Вернуть дерево вместо плоского списка"
+// @Success 200 {array} Genre
+// @Router /api/v1/genres [get]
+func ListGenres(w http.ResponseWriter, r *http.Request) {
+	rows, err := dbi.Query(r.Context(), "SELECT id, name, slug, parent_id FROM genres ORDER BY name")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	genres := []Genre{}
+	for rows.Next() {
+		var g Genre
+		if err := rows.Scan(&g.ID, &g.Name, &g.Slug, &g.ParentID); err != nil {
+			continue
+		}
+		genres = append(genres, g)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tree, _ := strconv.ParseBool(r.URL.Query().Get("tree")); tree {
+		render.Render(w, r, http.StatusOK, buildTree(genres))
+		return
+	}
+	render.Render(w, r, http.StatusOK, genres)
+}
+
+// @Summary Создать жанр
+// @Tags genres
+// @Accept json
+// @Produce json
+// @Param genre body Genre true "Жанр"
+// @Success 201 {object} Genre
+// @Router /api/v1/genres [post]
+func CreateGenre(w http.ResponseWriter, r *http.Request) {
+	g, ok := decodeGenre(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	row := tx.QueryRow(ctx, "INSERT INTO genres (name, slug, parent_id) VALUES ($1, $2, $3) RETURNING id", g.Name, g.Slug, g.ParentID)
+	if err := row.Scan(&g.ID); err != nil {
+		switch pgErrorCode(err) {
+		case "23505":
+			http.Error(w, "genre with this slug already exists", http.StatusConflict)
+		case "23503":
+			http.Error(w, "parent genre not found", 400)
+		default:
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+	if err := sendEvent(ctx, tx, "created genre: "+g.Slug); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, g)
+}
+
+// @Summary Получить жанр с поджанрами
+// @Tags genres
+// @Produce json
+// @Param id path string true "ID или slug жанра"
+// @Success 200 {object} Genre
+// @Failure 404 {string} string "не найдено"
+// @Router /api/v1/genres/{id} [get]
+func GetGenre(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "id")
+	var g Genre
+	var row db.Row
+	if id, err := strconv.Atoi(key); err == nil {
+		row = dbi.QueryRow(r.Context(), "SELECT id, name, slug, parent_id FROM genres WHERE id=$1", id)
+	} else {
+		row = dbi.QueryRow(r.Context(), "SELECT id, name, slug, parent_id FROM genres WHERE slug=$1", key)
+	}
+	if err := row.Scan(&g.ID, &g.Name, &g.Slug, &g.ParentID); err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	rows, err := dbi.Query(r.Context(), "SELECT id, name, slug, parent_id FROM genres WHERE parent_id=$1 ORDER BY name", g.ID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Genre
+		if err := rows.Scan(&c.ID, &c.Name, &c.Slug, &c.ParentID); err != nil {
+			continue
+		}
+		g.Children = append(g.Children, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, g)
+}
+
+// @Summary Обновить жанр
+// @Tags genres
+// @Accept json
+// @Produce json
+// @Param id path int true "ID жанра"
+// @Param genre body Genre true "Жанр"
+// @Success 200 {object} Genre
+// @Failure 400 {string} string "родитель создаёт цикл"
+// @Router /api/v1/genres/{id} [put]
+func UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	g, ok := decodeGenre(w, r)
+	if !ok {
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	if g.ParentID != nil {
+		// встречные переносы по отдельности цикла не дают, а вместе — дают,
+		// поэтому проверка и запись идут под общей блокировкой дерева
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext('genres_tree'))"); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		var cycle bool
+		if err := tx.QueryRow(ctx, createsCycleSQL, id, *g.ParentID).Scan(&cycle); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if cycle {
+			http.Error(w, "parent_id would create a cycle", 400)
+			return
+		}
+	}
+	row := tx.QueryRow(ctx, "UPDATE genres SET name=$1, slug=$2, parent_id=$3 WHERE id=$4 RETURNING id", g.Name, g.Slug, g.ParentID, id)
+	if err := row.Scan(&g.ID); err != nil {
+		switch pgErrorCode(err) {
+		case "23505":
+			http.Error(w, "genre with this slug already exists", http.StatusConflict)
+		case "23503":
+			http.Error(w, "parent genre not found", 400)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+		return
+	}
+	if err := sendEvent(ctx, tx, "updated genre: "+id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, g)
+}
+
+// @Summary Удалить жанр
+// @Tags genres
+// @Param id path int true "ID жанра"
+// @Success 204 {string} string "Жанр удалён"
+// @Failure 409 {string} string "у жанра есть поджанры"
+// @Router /api/v1/genres/{id} [delete]
+func DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	row := tx.QueryRow(ctx, "DELETE FROM genres WHERE id=$1 RETURNING id", id)
+	var deletedID int
+	if err := row.Scan(&deletedID); err != nil {
+		if pgErrorCode(err) == "23503" {
+			http.Error(w, "genre has subgenres", http.StatusConflict)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := sendEvent(ctx, tx, "deleted genre: "+id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,208 @@
+package genres
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+)
+
+type mockRows struct{ idx int }
+
+func (r *mockRows) Next() bool { r.idx++; return r.idx == 1 }
+func (r *mockRows) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	*dest[1].(*string) = "Фэнтези"
+	*dest[2].(*string) = "fantasy"
+	return nil
+}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
+
+type mockRow struct{ cycle bool }
+
+func (r *mockRow) Scan(dest ...any) error {
+	switch d := dest[0].(type) {
+	case *int:
+		*d = 1
+	case *bool:
+		*d = r.cycle
+	}
+	return nil
+}
+
+// mockDB запоминает выполненные запросы по порядку
+type mockDB struct {
+	cycle bool
+	sql   []string
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &mockRows{}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.sql = append(m.sql, sql)
+	return &mockRow{cycle: m.cycle}
+}
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.sql = append(m.sql, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *mockDB) Rollback(ctx context.Context) error { return nil }
+func (m *mockDB) Commit(ctx context.Context) error   { return nil }
+
+type mockProducer struct{}
+
+func (m *mockProducer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
+	return nil
+}
+func (m *mockProducer) Close() error { return nil }
+
+func withID(req *http.Request, id string) *http.Request {
+	ctx := chi.NewRouteContext()
+	ctx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, ctx))
+}
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Science Fiction":    "science-fiction",
+		"  Тёмное фэнтези! ": "тёмное-фэнтези",
+		"Sci-Fi & Fantasy 2": "sci-fi-fantasy-2",
+		"---":                "",
+	}
+	for in, want := range cases {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	one, two := 1, 2
+	tree := buildTree([]Genre{
+		{ID: 1, Name: "Fiction"},
+		{ID: 2, Name: "Fantasy", ParentID: &one},
+		{ID: 3, Name: "Urban fantasy", ParentID: &two},
+		{ID: 4, Name: "Non-fiction"},
+	})
+	if len(tree) != 2 || len(tree[0].Children) != 1 || tree[0].Children[0].Children[0].ID != 3 {
+		t.Fatalf("unexpected tree: %+v", tree)
+	}
+}
+
+func TestListGenres(t *testing.T) {
+	SetGenreDB(&mockDB{})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/genres?tree=true", nil)
+	w := httptest.NewRecorder()
+	ListGenres(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var genres []Genre
+	if err := json.NewDecoder(w.Body).Decode(&genres); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(genres) != 1 || genres[0].Slug != "fantasy" {
+		t.Fatalf("unexpected genres: %+v", genres)
+	}
+}
+
+func TestCreateGenre(t *testing.T) {
+	SetGenreDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/genres", strings.NewReader(`{"name":"Science Fiction"}`))
+	w := httptest.NewRecorder()
+	CreateGenre(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var g Genre
+	if err := json.NewDecoder(w.Body).Decode(&g); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if g.Slug != "science-fiction" {
+		t.Fatalf("unexpected slug %q", g.Slug)
+	}
+}
+
+func TestCreateGenreBadSlug(t *testing.T) {
+	SetGenreDB(&mockDB{})
+	for _, body := range []string{`{"name":"X","slug":"Bad Slug"}`, `{"name":"1984"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/genres", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		CreateGenre(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestGetGenre(t *testing.T) {
+	for key, want := range map[string]string{"fantasy": "WHERE slug=$1", "12": "WHERE id=$1"} {
+		m := &mockDB{}
+		SetGenreDB(m)
+		req := withID(httptest.NewRequest(http.MethodGet, "/api/v1/genres/"+key, nil), key)
+		w := httptest.NewRecorder()
+		GetGenre(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", key, w.Code)
+		}
+		if len(m.sql) == 0 || !strings.HasSuffix(m.sql[0], want) {
+			t.Fatalf("%s: expected lookup %q, got %q", key, want, m.sql)
+		}
+	}
+}
+
+func TestUpdateGenre(t *testing.T) {
+	m := &mockDB{}
+	SetGenreDB(m)
+	SetProducer(&mockProducer{})
+	body, _ := json.Marshal(map[string]any{"name": "Fantasy", "parent_id": 5})
+	req := withID(httptest.NewRequest(http.MethodPut, "/api/v1/genres/1", bytes.NewReader(body)), "1")
+	w := httptest.NewRecorder()
+	UpdateGenre(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	// блокировка дерева берётся до проверки цикла и держится до записи
+	if len(m.sql) != 3 || !strings.Contains(m.sql[0], "pg_advisory_xact_lock") ||
+		m.sql[1] != createsCycleSQL || !strings.HasPrefix(m.sql[2], "UPDATE genres") {
+		t.Fatalf("unexpected statements: %q", m.sql)
+	}
+}
+
+func TestUpdateGenreCycle(t *testing.T) {
+	SetGenreDB(&mockDB{cycle: true})
+	body, _ := json.Marshal(map[string]any{"name": "Fantasy", "parent_id": 3})
+	req := withID(httptest.NewRequest(http.MethodPut, "/api/v1/genres/1", bytes.NewReader(body)), "1")
+	w := httptest.NewRecorder()
+	UpdateGenre(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestDeleteGenre(t *testing.T) {
+	SetGenreDB(&mockDB{})
+	SetProducer(&mockProducer{})
+	req := withID(httptest.NewRequest(http.MethodDelete, "/api/v1/genres/1", nil), "1")
+	w := httptest.NewRecorder()
+	DeleteGenre(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+}
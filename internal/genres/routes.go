@@ -0,0 +1,18 @@
+package genres
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты для жанров
+func RegisterRoutes(r chi.Router) {
+	r.Route("/genres", func(r chi.Router) {
+		r.With(middleware.Require(middleware.PermRead)).Get("/", ListGenres)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Post("/", CreateGenre)
+		r.With(middleware.Require(middleware.PermRead)).Get("/{id}", GetGenre)
+		r.With(middleware.Require(middleware.PermBooksWrite)).Put("/{id}", UpdateGenre)
+		r.With(middleware.Require(middleware.PermBooksDelete)).Delete("/{id}", DeleteGenre)
+	})
+}
@@ -0,0 +1,230 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"books-api/internal/audit"
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/orders"
+	"books-api/internal/testutil"
+)
+
+// ordersDB подключает пакет orders к схеме migratedDB от имени читателя alice
+func ordersDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	pool := migratedDB(t)
+	orders.SetOrderDB(&db.PgxPoolTxDB{Pool: pool})
+	orders.SetProducer(&testutil.Producer{})
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	return pool
+}
+
+// seedOrder заводит книгу с ценой, 3 экземпляра на складе main и корзину
+// alice с qty экземплярами; возвращает ID книги и корзины
+func seedOrder(t *testing.T, pool *pgxpool.Pool, qty int) (bookID, cartID int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := pool.QueryRow(ctx, "INSERT INTO books (title, author) VALUES ('Dune', 'Frank Herbert') RETURNING id").Scan(&bookID); err != nil {
+		t.Fatalf("seed book: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "INSERT INTO prices (book_id, currency, amount, valid_from) VALUES ($1, 'EUR', 1299, now() - interval '1 day')", bookID); err != nil {
+		t.Fatalf("seed price: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "INSERT INTO inventory (book_id, location, quantity) VALUES ($1, 'main', 3)", bookID); err != nil {
+		t.Fatalf("seed inventory: %v", err)
+	}
+	if err := pool.QueryRow(ctx, "INSERT INTO carts (user_id, currency) VALUES ('alice', 'EUR') RETURNING id").Scan(&cartID); err != nil {
+		t.Fatalf("seed cart: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "INSERT INTO cart_items (cart_id, book_id, quantity) VALUES ($1, $2, $3)", cartID, bookID, qty); err != nil {
+		t.Fatalf("seed cart item: %v", err)
+	}
+	return bookID, cartID
+}
+
+// placeBehind оформляет заказ, пока строку склада держит конкурирующая
+// транзакция: ждёт, когда заказ встанет на её блокировке в Reserve, и только
+// тогда фиксирует её. Заказ получает 40001 и повторяется.
+func placeBehind(t *testing.T, pool *pgxpool.Pool, cartID int, competitor pgx.Tx) *httptest.ResponseRecorder {
+	t.Helper()
+	ctx := context.Background()
+	var pid int
+	if err := competitor.QueryRow(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+		t.Fatalf("backend pid: %v", err)
+	}
+	serve := testutil.Server(orders.RegisterRoutes)
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- serve(http.MethodPost, "/orders", fmt.Sprintf(`{"cart_id":%d}`, cartID))
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var waiting int
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM pg_stat_activity WHERE $1 = ANY(pg_blocking_pids(pid))", pid).Scan(&waiting); err != nil {
+			t.Fatalf("pg_stat_activity: %v", err)
+		}
+		if waiting > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("order did not block on the inventory row")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := competitor.Commit(ctx); err != nil {
+		t.Fatalf("commit competitor: %v", err)
+	}
+	select {
+	case w := <-done:
+		return w
+	case <-time.After(10 * time.Second):
+		t.Fatal("order did not finish")
+		return nil
+	}
+}
+
+func reserved(t *testing.T, pool *pgxpool.Pool, bookID int) int {
+	t.Helper()
+	var n int
+	if err := pool.QueryRow(context.Background(), "SELECT reserved FROM inventory WHERE book_id=$1 AND location='main'", bookID).Scan(&n); err != nil {
+		t.Fatalf("select reserved: %v", err)
+	}
+	return n
+}
+
+func TestPlaceOrderRetriesSerializationFailure(t *testing.T) {
+	pool := ordersDB(t)
+	bookID, cartID := seedOrder(t, pool, 2)
+	ctx := context.Background()
+
+	// конкурент меняет строку склада, не трогая остатков
+	competitor, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer competitor.Rollback(ctx)
+	if _, err := competitor.Exec(ctx, "UPDATE inventory SET updated_at = now() WHERE book_id=$1", bookID); err != nil {
+		t.Fatalf("touch inventory: %v", err)
+	}
+
+	w := placeBehind(t, pool, cartID, competitor)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201 after retry, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := reserved(t, pool, bookID); got != 2 {
+		t.Fatalf("expected 2 reserved, got %d", got)
+	}
+	var orderRows int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM orders").Scan(&orderRows); err != nil {
+		t.Fatalf("count orders: %v", err)
+	}
+	if orderRows != 1 {
+		t.Fatalf("expected exactly one order, got %d", orderRows)
+	}
+}
+
+func TestPlaceOrderDoesNotOverAllocate(t *testing.T) {
+	pool := ordersDB(t)
+	bookID, cartID := seedOrder(t, pool, 2)
+	ctx := context.Background()
+
+	// конкурент откладывает 2 из 3 экземпляров; заказу на 2 остаётся 1
+	competitor, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer competitor.Rollback(ctx)
+	if _, err := competitor.Exec(ctx, "UPDATE inventory SET reserved = reserved + 2 WHERE book_id=$1", bookID); err != nil {
+		t.Fatalf("reserve inventory: %v", err)
+	}
+
+	w := placeBehind(t, pool, cartID, competitor)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := reserved(t, pool, bookID); got != 2 {
+		t.Fatalf("expected only the competitor's 2 reserved, got %d", got)
+	}
+	var carts int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM carts WHERE id=$1", cartID).Scan(&carts); err != nil {
+		t.Fatalf("count carts: %v", err)
+	}
+	if carts != 1 {
+		t.Fatal("failed order must keep the cart")
+	}
+}
+
+// placeAndTrash оформляет заказ из корзины, кладёт книгу в корзину удалённых
+// со сроком старше суток и очищает её; книга под заказом должна остаться
+func placeAndTrash(t *testing.T, pool *pgxpool.Pool, bookID, cartID int) int {
+	t.Helper()
+	ctx := context.Background()
+	serve := testutil.Server(orders.RegisterRoutes)
+	w := serve(http.MethodPost, "/orders", fmt.Sprintf(`{"cart_id":%d}`, cartID))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var o orders.Order
+	if err := json.NewDecoder(w.Body).Decode(&o); err != nil {
+		t.Fatalf("decode order: %v", err)
+	}
+	if _, err := pool.Exec(ctx, "UPDATE books SET deleted_at = now() - interval '2 days' WHERE id=$1", bookID); err != nil {
+		t.Fatalf("trash book: %v", err)
+	}
+	if n, err := books.Purge(ctx, &db.PgxPoolTxDB{Pool: pool}, 24*time.Hour, audit.System("test")); err != nil || n != 0 {
+		t.Fatalf("book reserved for an open order must survive purge, purged %d: %v", n, err)
+	}
+	return o.ID
+}
+
+func TestPurgeKeepsBookUntilOrderCancelled(t *testing.T) {
+	pool := ordersDB(t)
+	bookID, cartID := seedOrder(t, pool, 2)
+	id := placeAndTrash(t, pool, bookID, cartID)
+
+	serve := testutil.Server(orders.RegisterRoutes)
+	if w := serve(http.MethodPost, fmt.Sprintf("/orders/%d/cancel", id), ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on cancel, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := reserved(t, pool, bookID); got != 0 {
+		t.Fatalf("expected reservation released, got %d", got)
+	}
+	if n, err := books.Purge(context.Background(), &db.PgxPoolTxDB{Pool: pool}, 24*time.Hour, audit.System("test")); err != nil || n != 1 {
+		t.Fatalf("expected the book purged after cancel, purged %d: %v", n, err)
+	}
+}
+
+func TestPurgeKeepsBookUntilOrderShipped(t *testing.T) {
+	pool := ordersDB(t)
+	bookID, cartID := seedOrder(t, pool, 2)
+	id := placeAndTrash(t, pool, bookID, cartID)
+	if _, err := pool.Exec(context.Background(), "UPDATE orders SET status = 'paid' WHERE id=$1", id); err != nil {
+		t.Fatalf("mark paid: %v", err)
+	}
+
+	testutil.AsUser(t, "alice", middleware.RoleAdmin)
+	serve := testutil.Server(orders.RegisterRoutes)
+	if w := serve(http.MethodPost, fmt.Sprintf("/orders/%d/ship", id), ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 on ship, got %d: %s", w.Code, w.Body.String())
+	}
+	var quantity int
+	if err := pool.QueryRow(context.Background(), "SELECT quantity FROM inventory WHERE book_id=$1", bookID).Scan(&quantity); err != nil {
+		t.Fatalf("select quantity: %v", err)
+	}
+	if quantity != 1 {
+		t.Fatalf("expected 1 copy left after shipping 2 of 3, got %d", quantity)
+	}
+}
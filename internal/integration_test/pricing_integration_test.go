@@ -0,0 +1,64 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/pricing"
+	"books-api/internal/testutil"
+)
+
+func TestOpenEndedPriceKeepsScheduledOne(t *testing.T) {
+	pool := migratedDB(t)
+	ctx := context.Background()
+	pricing.SetPricingDB(&db.PgxPoolTxDB{Pool: pool})
+	pricing.SetProducer(&testutil.Producer{})
+	testutil.AsUser(t, "alice", middleware.RoleAdmin)
+	serve := testutil.Server(pricing.RegisterRoutes)
+
+	var bookID int
+	if err := pool.QueryRow(ctx, "INSERT INTO books (title, author) VALUES ('Dune', 'Frank Herbert') RETURNING id").Scan(&bookID); err != nil {
+		t.Fatalf("seed book: %v", err)
+	}
+	target := fmt.Sprintf("/books/%d/prices", bookID)
+	scheduled := time.Now().UTC().AddDate(0, 1, 0).Truncate(time.Second)
+	if w := serve(http.MethodPost, target, fmt.Sprintf(`{"currency":"EUR","amount":"15.00","valid_from":%q}`, scheduled.Format(time.RFC3339))); w.Code != http.StatusCreated {
+		t.Fatalf("schedule price: %d %s", w.Code, w.Body.String())
+	}
+	// цена на сегодня без valid_to не должна стирать запланированную
+	if w := serve(http.MethodPost, target, `{"currency":"EUR","amount":"12.99"}`); w.Code != http.StatusCreated {
+		t.Fatalf("set current price: %d %s", w.Code, w.Body.String())
+	}
+
+	rows, err := pool.Query(ctx, "SELECT amount, valid_to FROM prices WHERE book_id=$1 ORDER BY valid_from", bookID)
+	if err != nil {
+		t.Fatalf("select prices: %v", err)
+	}
+	defer rows.Close()
+	var amounts []int64
+	var ends []*time.Time
+	for rows.Next() {
+		var amount int64
+		var end *time.Time
+		if err := rows.Scan(&amount, &end); err != nil {
+			t.Fatalf("scan price: %v", err)
+		}
+		amounts, ends = append(amounts, amount), append(ends, end)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	if fmt.Sprint(amounts) != "[1299 1500]" {
+		t.Fatalf("expected current and scheduled prices, got %v", amounts)
+	}
+	if ends[0] == nil || !ends[0].Equal(scheduled) || ends[1] != nil {
+		t.Fatalf("current price must end where the scheduled one starts, got %v and %v", ends[0], ends[1])
+	}
+}
@@ -0,0 +1,297 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+
+func SetInventoryDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+// DefaultLocation — склад, если он не указан
+const DefaultLocation = "main"
+
+// maxLocationLength — предел длины названия склада
+const maxLocationLength = 64
+
+// Причины изменения остатков. received и returned только добавляют,
+// sold, damaged и lost только списывают, correction — в любую сторону.
+const (
+	ReasonReceived   = "received"
+	ReasonReturned   = "returned"
+	ReasonSold       = "sold"
+	ReasonDamaged    = "damaged"
+	ReasonLost       = "lost"
+	ReasonCorrection = "correction"
+)
+
+// reasonSign — знак delta, допустимый для причины; 0 — любой
+var reasonSign = map[string]int{
+	ReasonReceived:   1,
+	ReasonReturned:   1,
+	ReasonSold:       -1,
+	ReasonDamaged:    -1,
+	ReasonLost:       -1,
+	ReasonCorrection: 0,
+}
+
+// ErrInsufficientStock — списание увело бы остаток ниже нуля или ниже
+// отложенного под заказы
+var ErrInsufficientStock = errors.New("not enough stock")
+
+// ErrBookNotFound — книги нет или она в корзине
+var ErrBookNotFound = errors.New("book not found")
+
+// Stock — остаток книги на одном складе
+type Stock struct {
+	XMLName   xml.Name `json:"-" xml:"stock"`
+	BookID    int      `json:"book_id" xml:"book_id"`
+	Location  string   `json:"location" xml:"location"`
+	Quantity  int      `json:"quantity" xml:"quantity"`
+	Reserved  int      `json:"reserved" xml:"reserved"`
+	Available int      `json:"available" xml:"available"`
+}
+
+func (s Stock) CSVHeader() []string {
+	return []string{"book_id", "location", "quantity", "reserved", "available"}
+}
+
+func (s Stock) CSVRecord() []string {
+	return []string{strconv.Itoa(s.BookID), s.Location, strconv.Itoa(s.Quantity), strconv.Itoa(s.Reserved), strconv.Itoa(s.Available)}
+}
+
+// Adjustment — изменение остатка на складе
+type Adjustment struct {
+	Location string `json:"location"`
+	Delta    int    `json:"delta"`
+	Reason   string `json:"reason"`
+	Note     string `json:"note,omitempty"`
+}
+
+func (a *Adjustment) validate() error {
+	a.Location = strings.TrimSpace(a.Location)
+	if a.Location == "" {
+		a.Location = DefaultLocation
+	}
+	if len(a.Location) > maxLocationLength {
+		return fmt.Errorf("location must be at most %d characters", maxLocationLength)
+	}
+	if a.Delta == 0 {
+		return errors.New("delta must not be zero")
+	}
+	sign, ok := reasonSign[a.Reason]
+	if !ok {
+		return errors.New("reason must be one of received, returned, sold, damaged, lost, correction")
+	}
+	if sign*a.Delta < 0 {
+		return fmt.Errorf("reason %s does not allow delta %d", a.Reason, a.Delta)
+	}
+	return nil
+}
+
+// adjust меняет остаток одним условным оператором: строка блокируется
+// самим UPDATE, а проверка quantity + delta >= reserved не даёт
+// параллельным списаниям увести остаток в минус
+func adjust(ctx context.Context, tx db.TxDB, bookID int, a Adjustment) (Stock, error) {
+	s := Stock{BookID: bookID, Location: a.Location}
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM books WHERE id=$1 AND deleted_at IS NULL)", bookID).Scan(&exists); err != nil {
+		return s, err
+	}
+	if !exists {
+		return s, ErrBookNotFound
+	}
+	var err error
+	if a.Delta > 0 {
+		err = tx.QueryRow(ctx, `INSERT INTO inventory (book_id, location, quantity) VALUES ($1, $2, $3)
+			ON CONFLICT (book_id, location) DO UPDATE SET quantity = inventory.quantity + EXCLUDED.quantity, updated_at = now()
+			RETURNING quantity, reserved`, bookID, a.Location, a.Delta).Scan(&s.Quantity, &s.Reserved)
+	} else {
+		err = tx.QueryRow(ctx, `UPDATE inventory SET quantity = quantity + $3, updated_at = now()
+			WHERE book_id = $1 AND location = $2 AND quantity + $3 >= reserved
+			RETURNING quantity, reserved`, bookID, a.Location, a.Delta).Scan(&s.Quantity, &s.Reserved)
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrInsufficientStock
+		}
+	}
+	s.Available = s.Quantity - s.Reserved
+	return s, err
+}
+
+// notifyStock сообщает об изменении остатка
+func notifyStock(ctx context.Context, tx db.TxDB, s Stock, delta int, reason string) error {
+	if producer == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("stock changed: book=%d location=%s delta=%d reason=%s available=%d", s.BookID, s.Location, delta, reason, s.Available)
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// @Summary Изменить остаток книги
+// @Description Приход (received, returned), списание (sold, damaged, lost) или
+// @Description корректировка (correction). Списать больше, чем свободно, нельзя.
+// @Tags inventory
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param adjustment body Adjustment true "Склад, изменение и причина"
+// @Success 200 {object} Stock
+// @Failure 409 {string} string "недостаточно остатка"
+// @Router /api/v1/books/{id}/inventory/adjustments [post]
+func AdjustStock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	var a Adjustment
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := a.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	s, err := adjust(ctx, tx, bookID, a)
+	switch {
+	case errors.Is(err, ErrBookNotFound):
+		http.Error(w, err.Error(), 404)
+		return
+	case errors.Is(err, ErrInsufficientStock):
+		http.Error(w, fmt.Sprintf("not enough free stock of book %d at %s", bookID, a.Location), 409)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	before := s
+	before.Quantity -= a.Delta
+	before.Available -= a.Delta
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), a.Reason, "inventory", &bookID, before, s); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notifyStock(ctx, tx, s, a.Delta, a.Reason); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, s)
+}
+
+// Availability — сводка остатков книги по всем складам
+type Availability struct {
+	XMLName   xml.Name `json:"-" xml:"availability"`
+	BookID    int      `json:"book_id" xml:"book_id"`
+	Quantity  int      `json:"quantity" xml:"quantity"`
+	Reserved  int      `json:"reserved" xml:"reserved"`
+	Available int      `json:"available" xml:"available"`
+	InStock   bool     `json:"in_stock" xml:"in_stock"`
+	Locations []Stock  `json:"locations" xml:"locations>stock"`
+}
+
+// Available возвращает сводку остатков; ErrBookNotFound — книги нет
+func Available(ctx context.Context, q db.TxDB, bookID int) (Availability, error) {
+	a := Availability{BookID: bookID, Locations: []Stock{}}
+	rows, err := q.Query(ctx, `SELECT i.location, i.quantity, i.reserved FROM books b
+		LEFT JOIN inventory i ON i.book_id = b.id
+		WHERE b.id = $1 AND b.deleted_at IS NULL ORDER BY i.location`, bookID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	found := false
+	for rows.Next() {
+		found = true
+		var location *string
+		var quantity, reserved *int
+		if err := rows.Scan(&location, &quantity, &reserved); err != nil {
+			return a, err
+		}
+		if location == nil {
+			continue // книга есть, остатков нет
+		}
+		s := Stock{BookID: bookID, Location: *location, Quantity: *quantity, Reserved: *reserved, Available: *quantity - *reserved}
+		a.Locations = append(a.Locations, s)
+		a.Quantity += s.Quantity
+		a.Reserved += s.Reserved
+		a.Available += s.Available
+	}
+	if err := rows.Err(); err != nil {
+		return a, err
+	}
+	if !found {
+		return a, ErrBookNotFound
+	}
+	a.InStock = a.Available > 0
+	return a, nil
+}
+
+// @Summary Наличие книги
+// @Description Сколько экземпляров всего, отложено и доступно, в том числе по складам
+// @Tags inventory
+// @Produce json
+// @Param id path int true "ID книги"
+// @Success 200 {object} Availability
+// @Failure 404 {string} string "книги нет"
+// @Router /api/v1/books/{id}/availability [get]
+func GetAvailability(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	a, err := Available(r.Context(), dbi, bookID)
+	if errors.Is(err, ErrBookNotFound) {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, a)
+}
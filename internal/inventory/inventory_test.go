@@ -0,0 +1,152 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+// stock — остаток после изменения: 8 на складе, 2 отложено
+var stock = testutil.Row{Values: []any{8, 2}}
+
+// locationRows — склады книги: main 8/2, store 3/0; nil — книга без остатков
+type locationRows struct {
+	rows [][]any
+	idx  int
+}
+
+func (r *locationRows) Next() bool { r.idx++; return r.idx <= len(r.rows) }
+func (r *locationRows) Scan(dest ...any) error {
+	row := r.rows[r.idx-1]
+	if row == nil {
+		return nil
+	}
+	loc, q, res := row[0].(string), row[1].(int), row[2].(int)
+	*dest[0].(**string), *dest[1].(**int), *dest[2].(**int) = &loc, &q, &res
+	return nil
+}
+func (r *locationRows) Close()     {}
+func (r *locationRows) Err() error { return nil }
+
+type mockDB struct {
+	testutil.Tx
+	testutil.Statements
+	noBook       bool
+	insufficient bool
+	rows         [][]any
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &locationRows{rows: m.rows}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.Record(sql, args)
+	switch {
+	case strings.HasPrefix(sql, "SELECT EXISTS"):
+		return testutil.Row{Values: []any{!m.noBook}}
+	case strings.HasPrefix(sql, "UPDATE inventory") && m.insufficient:
+		return testutil.Row{Err: pgx.ErrNoRows}
+	case strings.HasPrefix(sql, "INSERT INTO inventory"), strings.HasPrefix(sql, "UPDATE inventory"):
+		return stock
+	}
+	return testutil.Row{}
+}
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+var serve = testutil.Server(books.RegisterRoutes, RegisterRoutes)
+
+func TestAdjustmentValidate(t *testing.T) {
+	ok := Adjustment{Delta: 5, Reason: ReasonReceived}
+	if err := ok.validate(); err != nil || ok.Location != DefaultLocation {
+		t.Fatalf("unexpected %v, location %q", err, ok.Location)
+	}
+	for _, a := range []Adjustment{
+		{Delta: 0, Reason: ReasonCorrection},
+		{Delta: -1, Reason: ReasonReceived},
+		{Delta: 1, Reason: ReasonSold},
+		{Delta: 1, Reason: "gift"},
+		{Delta: 1, Reason: ReasonCorrection, Location: strings.Repeat("x", maxLocationLength+1)},
+	} {
+		if err := a.validate(); err == nil {
+			t.Errorf("expected error for %+v", a)
+		}
+	}
+	for _, a := range []Adjustment{{Delta: -3, Reason: ReasonCorrection}, {Delta: 3, Reason: ReasonCorrection}} {
+		if err := a.validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %v", a, err)
+		}
+	}
+}
+
+func TestAdjustStock(t *testing.T) {
+	database := &mockDB{}
+	SetInventoryDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+
+	w := serve(http.MethodPost, "/books/1/inventory/adjustments", `{"location":"main","delta":-2,"reason":"sold"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var s Stock
+	if err := json.NewDecoder(w.Body).Decode(&s); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if s.Quantity != 8 || s.Reserved != 2 || s.Available != 6 {
+		t.Fatalf("unexpected stock %+v", s)
+	}
+	if !strings.Contains(database.SQL[1], "quantity + $3 >= reserved") {
+		t.Fatalf("write-off must be a conditional update, got %s", database.SQL[1])
+	}
+	if len(events.Msgs) != 1 || events.Msgs[0] != "stock changed: book=1 location=main delta=-2 reason=sold available=6" {
+		t.Fatalf("unexpected events %v", events.Msgs)
+	}
+
+	database.insufficient = true
+	if w := serve(http.MethodPost, "/books/1/inventory/adjustments", `{"delta":-20,"reason":"lost"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	database.noBook = true
+	if w := serve(http.MethodPost, "/books/1/inventory/adjustments", `{"delta":1,"reason":"received"}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestGetAvailability(t *testing.T) {
+	SetInventoryDB(&mockDB{rows: [][]any{{"main", 8, 2}, {"store", 3, 0}}})
+	w := serve(http.MethodGet, "/books/1/availability", ``)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var a Availability
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if a.Quantity != 11 || a.Reserved != 2 || a.Available != 9 || !a.InStock || len(a.Locations) != 2 {
+		t.Fatalf("unexpected availability %+v", a)
+	}
+
+	SetInventoryDB(&mockDB{rows: [][]any{nil}})
+	a, err := Available(context.Background(), dbi, 1)
+	if err != nil || a.InStock || len(a.Locations) != 0 {
+		t.Fatalf("book without stock: %+v, %v", a, err)
+	}
+	SetInventoryDB(&mockDB{})
+	if w := serve(http.MethodGet, "/books/1/availability", ``); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing book, got %d", w.Code)
+	}
+}
@@ -0,0 +1,96 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"books-api/internal/db"
+)
+
+// Allocation — сколько экземпляров отложено на каком складе
+type Allocation struct {
+	Location string `json:"location" xml:"location"`
+	Quantity int    `json:"quantity" xml:"quantity"`
+}
+
+// Причины событий об отложенном под заказы; в корректировках их не указать
+const (
+	reasonReserved = "reserved"
+	reasonReleased = "released"
+)
+
+// Reserve откладывает qty экземпляров книги под заказ, начиная со складов,
+// где свободно больше всего. Строки склада блокируются до конца транзакции;
+// если свободно меньше qty — ErrInsufficientStock и ничего не отложено.
+func Reserve(ctx context.Context, tx db.TxDB, bookID, qty int) ([]Allocation, error) {
+	rows, err := tx.Query(ctx, `SELECT location, quantity - reserved FROM inventory
+		WHERE book_id = $1 AND quantity > reserved
+		ORDER BY quantity - reserved DESC, location FOR UPDATE`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	var plan []Allocation
+	left := qty
+	for left > 0 && rows.Next() {
+		var a Allocation
+		var free int
+		if err := rows.Scan(&a.Location, &free); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.Quantity = min(free, left)
+		left -= a.Quantity
+		plan = append(plan, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if left > 0 {
+		return nil, ErrInsufficientStock
+	}
+	for _, a := range plan {
+		if err := hold(ctx, tx, bookID, a.Location, a.Quantity, reasonReserved); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// Release возвращает отложенное в свободный остаток, например при отмене заказа
+func Release(ctx context.Context, tx db.TxDB, bookID int, a Allocation) error {
+	return hold(ctx, tx, bookID, a.Location, -a.Quantity, reasonReleased)
+}
+
+// hold меняет отложенное на складе на qty и сообщает событием stock changed:
+// общий остаток прежний, а свободный меняется на -qty
+func hold(ctx context.Context, tx db.TxDB, bookID int, location string, qty int, reason string) error {
+	s := Stock{BookID: bookID, Location: location}
+	err := tx.QueryRow(ctx, `UPDATE inventory SET reserved = reserved + $3, updated_at = now()
+		WHERE book_id = $1 AND location = $2
+		RETURNING quantity, reserved`, bookID, location, qty).Scan(&s.Quantity, &s.Reserved)
+	if err != nil {
+		return err
+	}
+	s.Available = s.Quantity - s.Reserved
+	return notifyStock(ctx, tx, s, -qty, reason)
+}
+
+// Fulfil списывает отложенное при отгрузке заказа и сообщает об этом
+// событием stock changed с причиной sold
+func Fulfil(ctx context.Context, tx db.TxDB, bookID int, a Allocation) error {
+	s := Stock{BookID: bookID, Location: a.Location}
+	err := tx.QueryRow(ctx, `UPDATE inventory SET quantity = quantity - $3, reserved = reserved - $3, updated_at = now()
+		WHERE book_id = $1 AND location = $2 AND reserved >= $3
+		RETURNING quantity, reserved`, bookID, a.Location, a.Quantity).Scan(&s.Quantity, &s.Reserved)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInsufficientStock
+	}
+	if err != nil {
+		return err
+	}
+	s.Available = s.Quantity - s.Reserved
+	return notifyStock(ctx, tx, s, -a.Quantity, ReasonSold)
+}
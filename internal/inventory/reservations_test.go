@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+type reserveDB struct {
+	mockDB
+	free    []Allocation
+	updates [][]any
+}
+
+// Query отдаёт свободный остаток по складам, как его читает Reserve
+func (m *reserveDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	rows := &testutil.Rows{}
+	for _, a := range m.free {
+		rows.Values = append(rows.Values, []any{a.Location, a.Quantity})
+	}
+	return rows, nil
+}
+func (m *reserveDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.updates = append(m.updates, args)
+	return stock
+}
+
+func TestReserve(t *testing.T) {
+	p := &testutil.Producer{}
+	SetProducer(p)
+	t.Cleanup(func() { SetProducer(nil) })
+	m := &reserveDB{free: []Allocation{{"main", 3}, {"store", 2}, {"backup", 1}}}
+	got, err := Reserve(context.Background(), m, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Allocation{{"main", 3}, {"store", 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("allocations = %v, want %v", got, want)
+	}
+	if len(m.updates) != 2 || m.updates[1][1] != "store" || m.updates[1][2] != 1 {
+		t.Errorf("updates = %v", m.updates)
+	}
+	if len(p.Msgs) != 2 || p.Msgs[1] != "stock changed: book=1 location=store delta=-1 reason=reserved available=6" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	p := &testutil.Producer{}
+	SetProducer(p)
+	t.Cleanup(func() { SetProducer(nil) })
+	m := &reserveDB{}
+	if err := Release(context.Background(), m, 1, Allocation{"main", 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.updates) != 1 || m.updates[0][2] != -2 {
+		t.Errorf("updates = %v", m.updates)
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "stock changed: book=1 location=main delta=2 reason=released available=6" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestReserveEventFailure(t *testing.T) {
+	failed := errors.New("outbox insert failed")
+	SetProducer(&testutil.Producer{Err: failed})
+	t.Cleanup(func() { SetProducer(nil) })
+	m := &reserveDB{free: []Allocation{{"main", 3}}}
+	if _, err := Reserve(context.Background(), m, 1, 2); !errors.Is(err, failed) {
+		t.Errorf("err = %v, want the event write error", err)
+	}
+}
+
+func TestReserveInsufficient(t *testing.T) {
+	m := &reserveDB{free: []Allocation{{"main", 3}, {"store", 2}}}
+	if _, err := Reserve(context.Background(), m, 1, 6); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("err = %v", err)
+	}
+	if len(m.updates) != 0 {
+		t.Errorf("updates = %v", m.updates)
+	}
+}
+
+func TestFulfil(t *testing.T) {
+	p := &testutil.Producer{}
+	SetProducer(p)
+	t.Cleanup(func() { SetProducer(nil) })
+	m := &mockDB{}
+	if err := Fulfil(context.Background(), m, 1, Allocation{"main", 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "stock changed: book=1 location=main delta=-2 reason=sold available=6" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+	// отложено меньше, чем списывается
+	if err := Fulfil(context.Background(), &mockDB{insufficient: true}, 1, Allocation{"main", 2}); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package inventory
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты остатков. Пути полные, а не через
+// Route("/books/{id}"): такой подроутер перехватил бы и GET /books/{id}.
+func RegisterRoutes(r chi.Router) {
+	r.With(middleware.Require(middleware.PermRead)).Get("/books/{id}/availability", GetAvailability)
+	r.With(middleware.Require(middleware.PermInventoryWrite)).Post("/books/{id}/inventory/adjustments", AdjustStock)
+}
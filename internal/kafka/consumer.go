@@ -0,0 +1,15 @@
+package kafka
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// NewConsumer читает топик в составе группы; смещения коммитятся вручную
+// через CommitMessages
+func NewConsumer(brokers []string, topic, group string) *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: group,
+	})
+}
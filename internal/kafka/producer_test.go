@@ -28,3 +28,14 @@ func TestSendMessage(t *testing.T) {
 	}()
 	_ = SendMessage(nil, "test")
 }
+
+func TestNewConsumer(t *testing.T) {
+	reader := NewConsumer([]string{"localhost:9092"}, "test-topic", "test-group")
+	if reader == nil {
+		t.Fatal("NewConsumer returned nil")
+	}
+	if cfg := reader.Config(); cfg.Topic != "test-topic" || cfg.GroupID != "test-group" {
+		t.Errorf("unexpected config: topic %s, group %s", cfg.Topic, cfg.GroupID)
+	}
+	reader.Close()
+}
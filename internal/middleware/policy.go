@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"books-api/internal/auth"
+	"books-api/internal/render"
+)
+
+// Permission — право на группу операций
+type Permission string
+
+const (
+	PermRead             Permission = "read"
+	PermBooksWrite       Permission = "books:write"
+	PermBooksDelete      Permission = "books:delete"
+	PermTrashPurge       Permission = "trash:purge"
+	PermCollectionsWrite Permission = "collections:write"
+	PermAuditRead        Permission = "audit:read"
+	PermAPIKeysManage    Permission = "apikeys:manage"
+	// PermCollectionsAdmin — управлять любой подборкой независимо от владельца
+	PermCollectionsAdmin Permission = "collections:admin"
+	// PermReviewsWrite — писать и править свои отзывы
+	PermReviewsWrite Permission = "reviews:write"
+	// PermReviewsModerate — править и удалять чужие отзывы
+	PermReviewsModerate Permission = "reviews:moderate"
+	// PermInventoryWrite — приход, списание и корректировка остатков
+	PermInventoryWrite Permission = "inventory:write"
+	// PermPricesWrite — назначать цены книг
+	PermPricesWrite Permission = "prices:write"
+	// PermOrdersPlace — вести свои корзины, оформлять и оплачивать заказы
+	PermOrdersPlace Permission = "orders:place"
+	// PermOrdersManage — видеть все заказы, отгружать и отменять оплаченные
+	PermOrdersManage Permission = "orders:manage"
+)
+
+// Permissions — все права, которые можно выдать ролью или ключом API
+var Permissions = []Permission{PermRead, PermBooksWrite, PermBooksDelete, PermTrashPurge, PermCollectionsWrite, PermAuditRead, PermAPIKeysManage, PermCollectionsAdmin, PermReviewsWrite, PermReviewsModerate, PermInventoryWrite, PermPricesWrite, PermOrdersPlace, PermOrdersManage}
+
+// KnownPermission проверяет, что такое право существует
+func KnownPermission(p Permission) bool {
+	for _, known := range Permissions {
+		if known == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Роли и их права по умолчанию
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// DefaultRoles: читатель читает и пишет отзывы, редактор ещё создаёт и
+// правит книги и ведёт подборки, администратор может всё
+var DefaultRoles = map[string][]Permission{
+	RoleReader: {PermRead, PermReviewsWrite, PermOrdersPlace},
+	RoleEditor: {PermRead, PermBooksWrite, PermCollectionsWrite, PermReviewsWrite, PermOrdersPlace},
+	RoleAdmin:  Permissions,
+}
+
+// Identity — кто выполняет запрос с точки зрения прав. Пользователи
+// получают права через роли, ключи API — напрямую через Permissions.
+type Identity struct {
+	Subject     string
+	Roles       []string
+	Permissions []Permission
+}
+
+// IdentityExtractor определяет Identity запроса; false — запрос анонимный
+type IdentityExtractor func(r *http.Request) (Identity, bool)
+
+// HeaderIdentity доверяет заголовкам, которые выставляет прокси перед API,
+// например X-User и X-Roles: editor,admin
+func HeaderIdentity(subjectHeader, rolesHeader string) IdentityExtractor {
+	return func(r *http.Request) (Identity, bool) {
+		id := Identity{Subject: strings.TrimSpace(r.Header.Get(subjectHeader))}
+		if id.Subject == "" {
+			return id, false
+		}
+		for _, role := range strings.Split(r.Header.Get(rolesHeader), ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				id.Roles = append(id.Roles, role)
+			}
+		}
+		return id, true
+	}
+}
+
+// TokenIdentity берёт Identity из Principal, положенного auth.Authenticate
+func TokenIdentity(r *http.Request) (Identity, bool) {
+	p, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		return Identity{}, false
+	}
+	return Identity{Subject: p.Subject, Roles: p.Roles}, true
+}
+
+// FirstIdentity пробует извлекатели по очереди, первый успешный побеждает
+func FirstIdentity(extractors ...IdentityExtractor) IdentityExtractor {
+	return func(r *http.Request) (Identity, bool) {
+		for _, extract := range extractors {
+			if id, ok := extract(r); ok {
+				return id, true
+			}
+		}
+		return Identity{}, false
+	}
+}
+
+// StubIdentity всегда возвращает id — для тестов и локального запуска
+func StubIdentity(id Identity) IdentityExtractor {
+	return func(*http.Request) (Identity, bool) { return id, true }
+}
+
+// Policy решает, можно ли выполнить запрос
+type Policy struct {
+	Extract IdentityExtractor
+	// Roles — права ролей; nil — DefaultRoles
+	Roles map[string][]Permission
+	// AnonymousRoles выдаются запросам без Identity
+	AnonymousRoles []string
+	// OnDeny вызывается при каждом отказе, например для журнала изменений
+	OnDeny func(r *http.Request, id Identity, perm Permission)
+}
+
+// Allowed проверяет, есть ли у id право perm напрямую или через роль
+func (p *Policy) Allowed(id Identity, perm Permission) bool {
+	for _, granted := range id.Permissions {
+		if granted == perm {
+			return true
+		}
+	}
+	roles := p.Roles
+	if roles == nil {
+		roles = DefaultRoles
+	}
+	for _, role := range id.Roles {
+		for _, granted := range roles[strings.ToLower(role)] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var policy *Policy
+
+// SetPolicy включает проверку прав; без политики Require ничего не проверяет
+func SetPolicy(p *Policy) {
+	policy = p
+}
+
+// Enforced сообщает, включена ли проверка прав
+func Enforced() bool {
+	return policy != nil
+}
+
+// Caller возвращает Identity запроса по текущей политике; false — политики
+// нет или запрос анонимный
+func Caller(r *http.Request) (Identity, bool) {
+	p := policy
+	if p == nil || p.Extract == nil {
+		return Identity{}, false
+	}
+	return p.Extract(r)
+}
+
+// Can проверяет право вызывающего внутри обработчика, например для проверок,
+// зависящих от данных. Без политики разрешено всё, как и в Require.
+func Can(r *http.Request, perm Permission) bool {
+	p := policy
+	if p == nil {
+		return true
+	}
+	id, ok := Caller(r)
+	if !ok {
+		id.Roles = p.AnonymousRoles
+	}
+	return p.Allowed(id, perm)
+}
+
+// Require пропускает запрос, только если у вызывающего есть право perm.
+// Анонимный запрос без права получает 401, аутентифицированный — 403.
+func Require(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := policy
+			if p == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, ok := Identity{}, false
+			if p.Extract != nil {
+				id, ok = p.Extract(r)
+			}
+			if !ok {
+				id.Roles = p.AnonymousRoles
+			}
+			if p.Allowed(id, perm) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if p.OnDeny != nil {
+				p.OnDeny(r, id, perm)
+			}
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				render.WriteProblem(w, r, http.StatusUnauthorized, "authentication required for "+string(perm))
+				return
+			}
+			render.WriteProblem(w, r, http.StatusForbidden, "permission "+string(perm)+" required")
+		})
+	}
+}
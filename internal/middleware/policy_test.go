@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"books-api/internal/auth"
+	"books-api/internal/render"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	p := &Policy{}
+	cases := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleReader, PermRead, true},
+		{RoleReader, PermBooksWrite, false},
+		{RoleEditor, PermBooksWrite, true},
+		{RoleEditor, PermCollectionsWrite, true},
+		{RoleEditor, PermBooksDelete, false},
+		{RoleEditor, PermTrashPurge, false},
+		{"Admin", PermBooksDelete, true},
+		{RoleAdmin, PermTrashPurge, true},
+		{"guest", PermRead, false},
+	}
+	for _, tc := range cases {
+		if got := p.Allowed(Identity{Roles: []string{tc.role}}, tc.perm); got != tc.want {
+			t.Errorf("%s/%s: got %v, want %v", tc.role, tc.perm, got, tc.want)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	var denied []Permission
+	handler := Require(PermBooksWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer SetPolicy(nil)
+
+	SetPolicy(&Policy{
+		Extract: StubIdentity(Identity{Subject: "bob", Roles: []string{RoleReader}}),
+		OnDeny:  func(r *http.Request, id Identity, perm Permission) { denied = append(denied, perm) },
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/books", nil))
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected 403 problem, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var problem render.Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil || problem.Status != 403 || problem.Instance != "/api/v1/books" {
+		t.Fatalf("unexpected problem: %+v %v", problem, err)
+	}
+	if len(denied) != 1 || denied[0] != PermBooksWrite {
+		t.Fatalf("denial must be reported: %v", denied)
+	}
+
+	SetPolicy(&Policy{Extract: StubIdentity(Identity{Subject: "eve", Roles: []string{RoleEditor}})})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/books", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for editor, got %d", w.Code)
+	}
+
+	SetPolicy(&Policy{Extract: TokenIdentity, AnonymousRoles: []string{RoleReader}})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/books", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for anonymous, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/books", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{Subject: "eve", Roles: []string{"editor"}}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for token editor, got %d", w.Code)
+	}
+}
+
+func TestHeaderIdentity(t *testing.T) {
+	extract := HeaderIdentity("X-User", "X-Roles")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := extract(req); ok {
+		t.Fatal("request without X-User must be anonymous")
+	}
+	req.Header.Set("X-User", "alice")
+	req.Header.Set("X-Roles", "editor, admin")
+	id, ok := extract(req)
+	if !ok || id.Subject != "alice" || len(id.Roles) != 2 || id.Roles[1] != "admin" {
+		t.Fatalf("unexpected identity: %+v", id)
+	}
+}
+
+func TestCallerAndCan(t *testing.T) {
+	defer SetPolicy(nil)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/collections", nil)
+	if _, ok := Caller(r); ok || !Can(r, PermCollectionsAdmin) {
+		t.Fatal("without a policy there is no caller and everything is allowed")
+	}
+	SetPolicy(&Policy{Extract: StubIdentity(Identity{Subject: "bob", Roles: []string{RoleEditor}})})
+	if id, ok := Caller(r); !ok || id.Subject != "bob" {
+		t.Fatalf("unexpected caller %+v", id)
+	}
+	if Can(r, PermCollectionsAdmin) {
+		t.Fatal("editor must not administer other people's collections")
+	}
+}
@@ -0,0 +1,640 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/carts"
+	"books-api/internal/db"
+	"books-api/internal/inventory"
+	"books-api/internal/middleware"
+	"books-api/internal/payment"
+	"books-api/internal/pricing"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+var gateway payment.Gateway
+
+func SetOrderDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+func SetPaymentGateway(g payment.Gateway) {
+	gateway = g
+}
+
+// auditEntity — сущность заказов в журнале изменений
+const auditEntity = "order"
+
+// maxAttempts — сколько раз оформление повторяется при конфликте сериализации
+const maxAttempts = 3
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// ErrNotFound — заказа нет или он чужой
+var ErrNotFound = errors.New("order not found")
+
+var errEmptyCart = errors.New("cart is empty")
+
+var errNoGateway = errors.New("payments are not configured")
+
+// errManageRequired — покупатель отменяет уже оплаченный заказ: возврат
+// денег решает магазин
+var errManageRequired = errors.New("cancelling a paid order requires " + string(middleware.PermOrdersManage))
+
+// Item — позиция заказа с названием и ценой на момент оформления
+type Item struct {
+	XMLName        xml.Name `json:"-" xml:"item"`
+	BookID         int      `json:"book_id" xml:"book_id"`
+	Title          string   `json:"title" xml:"title"`
+	Quantity       int      `json:"quantity" xml:"quantity"`
+	UnitPrice      string   `json:"unit_price" xml:"unit_price"`
+	UnitPriceMinor int64    `json:"unit_price_minor" xml:"unit_price_minor"`
+}
+
+// Order — заказ покупателя
+type Order struct {
+	XMLName    xml.Name  `json:"-" xml:"order"`
+	ID         int       `json:"id" xml:"id"`
+	UserID     string    `json:"user_id" xml:"user_id"`
+	Status     string    `json:"status" xml:"status"`
+	Currency   string    `json:"currency" xml:"currency"`
+	Total      string    `json:"total" xml:"total"`
+	TotalMinor int64     `json:"total_minor" xml:"total_minor"`
+	PaymentRef *string   `json:"payment_ref,omitempty" xml:"payment_ref,omitempty"`
+	Items      []Item    `json:"items,omitempty" xml:"items>item,omitempty"`
+	CreatedAt  time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+func (o Order) CSVHeader() []string {
+	return []string{"id", "user_id", "status", "currency", "total", "created_at"}
+}
+
+func (o Order) CSVRecord() []string {
+	return []string{strconv.Itoa(o.ID), o.UserID, o.Status, o.Currency, o.Total, o.CreatedAt.Format(time.RFC3339)}
+}
+
+// orderColumns — порядок колонок, который ожидает scanOrder
+const orderColumns = "id, user_id, status, currency, total, payment_ref, created_at, updated_at"
+
+func scanOrder(row db.Row) (Order, error) {
+	var o Order
+	err := row.Scan(&o.ID, &o.UserID, &o.Status, &o.Currency, &o.TotalMinor, &o.PaymentRef, &o.CreatedAt, &o.UpdatedAt)
+	o.Total = pricing.FormatAmount(o.TotalMinor, o.Currency)
+	return o, err
+}
+
+// loadItems читает позиции заказа
+func loadItems(ctx context.Context, q db.TxDB, o *Order) error {
+	rows, err := q.Query(ctx, "SELECT book_id, title, quantity, unit_price FROM order_items WHERE order_id=$1 ORDER BY book_id", o.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	o.Items = []Item{}
+	for rows.Next() {
+		var it Item
+		if err := rows.Scan(&it.BookID, &it.Title, &it.Quantity, &it.UnitPriceMinor); err != nil {
+			return err
+		}
+		it.UnitPrice = pricing.FormatAmount(it.UnitPriceMinor, o.Currency)
+		o.Items = append(o.Items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// reservation — экземпляры позиции, отложенные на складе под заказ
+type reservation struct {
+	BookID int
+	inventory.Allocation
+}
+
+func reservations(ctx context.Context, q db.TxDB, orderID int) ([]reservation, error) {
+	rows, err := q.Query(ctx, "SELECT book_id, location, quantity FROM order_reservations WHERE order_id=$1 ORDER BY book_id, location", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []reservation
+	for rows.Next() {
+		var rs reservation
+		if err := rows.Scan(&rs.BookID, &rs.Location, &rs.Quantity); err != nil {
+			return nil, err
+		}
+		list = append(list, rs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// caller возвращает пользователя запроса; у анонимного заказов нет — 401
+func caller(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := middleware.Caller(r)
+	if !ok || id.Subject == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		render.WriteProblem(w, r, http.StatusUnauthorized, "orders are tied to a user, authentication required")
+		return "", false
+	}
+	return id.Subject, true
+}
+
+// visible — заказ свой или у вызывающего право orders:manage
+func visible(r *http.Request, o Order, user string) bool {
+	return o.UserID == user || middleware.Can(r, middleware.PermOrdersManage)
+}
+
+// statusOf подбирает код ответа для ошибки оформления или перехода
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, carts.ErrNotFound):
+		return 404
+	case errors.Is(err, payment.ErrDeclined):
+		return 402
+	case errors.Is(err, ErrInvalidTransition), errors.Is(err, errEmptyCart),
+		errors.Is(err, inventory.ErrInsufficientStock), errors.Is(err, pricing.ErrNoPrice):
+		return 409
+	}
+	return 500
+}
+
+// notify пишет события заказа в транзакции tx: если оформление повторяется,
+// событие неудачной попытки откатывается вместе с ней. Ошибка записи
+// прерывает транзакцию, поэтому возвращается вызывающему.
+func notify(ctx context.Context, tx db.TxDB, msgs ...string) error {
+	if producer == nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		if err := producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transitionEvent(id int, from, to string) string {
+	return fmt.Sprintf("order status changed: %d %s -> %s", id, from, to)
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+// serializable выполняет fn в сериализуемой транзакции и повторяет её,
+// если Postgres отменил транзакцию из-за конфликта с параллельной
+func serializable(ctx context.Context, fn func(tx db.TxDB) error) error {
+	for attempt := 1; ; attempt++ {
+		err := func() error {
+			tx, rollback, err := beginTx(ctx, pgx.TxOptions{IsoLevel: pgx.Serializable})
+			if err != nil {
+				return err
+			}
+			defer rollback()
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		}()
+		var pgErr *pgconn.PgError
+		if attempt < maxAttempts && errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01") {
+			continue
+		}
+		return err
+	}
+}
+
+// place превращает корзину в заказ: фиксирует текущие цены, откладывает
+// экземпляры на складах и удаляет корзину
+func place(ctx context.Context, tx db.TxDB, cartID int, user string) (Order, error) {
+	c, err := carts.Load(ctx, tx, cartID, user, true)
+	if err != nil {
+		return Order{}, err
+	}
+	if len(c.Items) == 0 {
+		return Order{}, errEmptyCart
+	}
+	o := Order{UserID: user, Status: StatusPending, Currency: c.Currency, Items: []Item{}}
+	at := time.Now()
+	var held []reservation
+	for _, it := range c.Items {
+		p, err := pricing.Current(ctx, tx, it.BookID, c.Currency, at)
+		if errors.Is(err, pricing.ErrNoPrice) {
+			return o, fmt.Errorf("book %d has no %s price: %w", it.BookID, c.Currency, err)
+		}
+		if err != nil {
+			return o, err
+		}
+		allocs, err := inventory.Reserve(ctx, tx, it.BookID, it.Quantity)
+		if errors.Is(err, inventory.ErrInsufficientStock) {
+			return o, fmt.Errorf("book %d: %w", it.BookID, err)
+		}
+		if err != nil {
+			return o, err
+		}
+		for _, a := range allocs {
+			held = append(held, reservation{BookID: it.BookID, Allocation: a})
+		}
+		o.Items = append(o.Items, Item{BookID: it.BookID, Title: it.Title, Quantity: it.Quantity,
+			UnitPrice: p.Amount, UnitPriceMinor: p.AmountMinor})
+		o.TotalMinor += p.AmountMinor * int64(it.Quantity)
+	}
+	o.Total = pricing.FormatAmount(o.TotalMinor, o.Currency)
+	err = tx.QueryRow(ctx, `INSERT INTO orders (user_id, status, currency, total) VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at`, o.UserID, o.Status, o.Currency, o.TotalMinor).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return o, err
+	}
+	for _, it := range o.Items {
+		if _, err := tx.Exec(ctx, "INSERT INTO order_items (order_id, book_id, title, quantity, unit_price) VALUES ($1, $2, $3, $4, $5)",
+			o.ID, it.BookID, it.Title, it.Quantity, it.UnitPriceMinor); err != nil {
+			return o, err
+		}
+	}
+	for _, rs := range held {
+		if _, err := tx.Exec(ctx, "INSERT INTO order_reservations (order_id, book_id, location, quantity) VALUES ($1, $2, $3, $4)",
+			o.ID, rs.BookID, rs.Location, rs.Quantity); err != nil {
+			return o, err
+		}
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM carts WHERE id=$1", cartID)
+	return o, err
+}
+
+// placeRequest — корзина, из которой оформляется заказ
+type placeRequest struct {
+	CartID int `json:"cart_id"`
+}
+
+// @Summary Оформить заказ
+// @Description Превращает корзину в заказ в статусе pending одной сериализуемой
+// @Description транзакцией: цены фиксируются, экземпляры откладываются на складах,
+// @Description корзина удаляется. Нет цены или остатка — 409.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param order body placeRequest true "ID корзины"
+// @Success 201 {object} Order
+// @Failure 409 {string} string "корзина пуста, нет цены или остатка"
+// @Router /api/v1/orders [post]
+func PlaceOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := caller(w, r)
+	if !ok {
+		return
+	}
+	var req placeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if req.CartID <= 0 {
+		http.Error(w, "cart_id is required", 400)
+		return
+	}
+	var o Order
+	err := serializable(ctx, func(tx db.TxDB) error {
+		var err error
+		if o, err = place(ctx, tx, req.CartID, user); err != nil {
+			return err
+		}
+		if err := audit.Record(ctx, tx, audit.FromRequest(r), "create", auditEntity, &o.ID, nil, o); err != nil {
+			return err
+		}
+		return notify(ctx, tx, fmt.Sprintf("created order: %d user=%s total=%s currency=%s", o.ID, o.UserID, o.Total, o.Currency))
+	})
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	render.Render(w, r, http.StatusCreated, o)
+}
+
+// @Summary Заказ
+// @Tags orders
+// @Produce json
+// @Param id path int true "ID заказа"
+// @Success 200 {object} Order
+// @Failure 404 {string} string "заказа нет"
+// @Router /api/v1/orders/{id} [get]
+func GetOrder(w http.ResponseWriter, r *http.Request) {
+	user, ok := caller(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	o, err := scanOrder(dbi.QueryRow(r.Context(), "SELECT "+orderColumns+" FROM orders WHERE id=$1", id))
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !visible(r, o, user)) {
+		http.Error(w, ErrNotFound.Error(), 404)
+		return
+	}
+	if err == nil {
+		err = loadItems(r.Context(), dbi, &o)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, o)
+}
+
+// @Summary Список заказов
+// @Description Свои заказы, новые первыми. С правом orders:manage — все,
+// @Description или заказы пользователя user.
+// @Tags orders
+// @Produce json
+// @Param status query string false "pending, paid, shipped или cancelled"
+// @Param user query string false "Пользователь (только orders:manage)"
+// @Param limit query int false "Размер страницы, по умолчанию 20, не больше 100"
+// @Param offset query int false "Смещение"
+// @Success 200 {array} Order
+// @Router /api/v1/orders [get]
+func ListOrders(w http.ResponseWriter, r *http.Request) {
+	user, ok := caller(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	query := "SELECT " + orderColumns + " FROM orders WHERE true"
+	var args []any
+	switch {
+	case !middleware.Can(r, middleware.PermOrdersManage):
+		args = append(args, user)
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	case q.Get("user") != "":
+		args = append(args, q.Get("user"))
+		query += fmt.Sprintf(" AND user_id = $%d", len(args))
+	}
+	if s := q.Get("status"); s != "" {
+		if s != StatusPending && s != StatusPaid && s != StatusShipped && s != StatusCancelled {
+			http.Error(w, "status must be pending, paid, shipped or cancelled", 400)
+			return
+		}
+		args = append(args, s)
+		query += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	limit, offset := defaultLimit, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", 400)
+			return
+		}
+		limit = min(n, maxLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", 400)
+			return
+		}
+		offset = n
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	rows, err := dbi.Query(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	list := []Order{}
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		list = append(list, o)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, list)
+}
+
+// refund возвращает платёж ref заказа id; возврат доводится до конца,
+// даже если клиент уже отключился
+func refund(ctx context.Context, id int, ref string) {
+	if err := gateway.Refund(context.WithoutCancel(ctx), ref); err != nil {
+		log.Printf("ошибка возврата платежа %s заказа %d: %v", ref, id, err)
+	}
+}
+
+// hooks — действия с внешними системами, которые зависят от исхода
+// транзакции перехода: undo вызывается, если её не удалось зафиксировать,
+// after — после успешного коммита. Любое из них может быть nil.
+type hooks struct {
+	undo  func()
+	after func()
+}
+
+// effect — действие перехода внутри его транзакции
+type effect func(ctx context.Context, tx db.TxDB, o *Order) (hooks, error)
+
+// change переводит заказ в статус to: блокирует заказ, проверяет доступ и
+// допустимость перехода, выполняет действие перехода и пишет журнал
+func change(w http.ResponseWriter, r *http.Request, action, to string, act effect) {
+	ctx := r.Context()
+	user, ok := caller(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	tx, rollback, err := beginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	o, err := scanOrder(tx.QueryRow(ctx, "SELECT "+orderColumns+" FROM orders WHERE id=$1 FOR UPDATE", id))
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !visible(r, o, user)) {
+		http.Error(w, ErrNotFound.Error(), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	from := o.Status
+	if err := checkTransition(from, to); err != nil {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+	before := o
+	h, err := act(ctx, tx, &o)
+	if errors.Is(err, errManageRequired) {
+		render.WriteProblem(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), statusOf(err))
+		return
+	}
+	committed := false
+	defer func() {
+		if !committed && h.undo != nil {
+			h.undo()
+		}
+	}()
+	o.Status = to
+	if err := tx.QueryRow(ctx, "UPDATE orders SET status=$2, payment_ref=$3, updated_at=now() WHERE id=$1 RETURNING updated_at",
+		o.ID, o.Status, o.PaymentRef).Scan(&o.UpdatedAt); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), action, auditEntity, &o.ID, before, o); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := loadItems(ctx, tx, &o); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notify(ctx, tx, transitionEvent(o.ID, from, to)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	committed = true
+	if h.after != nil {
+		h.after()
+	}
+	render.Render(w, r, http.StatusOK, o)
+}
+
+// @Summary Оплатить заказ
+// @Description Списывает итог заказа через платёжный шлюз; pending → paid
+// @Tags orders
+// @Produce json
+// @Param id path int true "ID заказа"
+// @Success 200 {object} Order
+// @Failure 402 {string} string "платёж отклонён"
+// @Failure 409 {string} string "заказ не ждёт оплаты"
+// @Router /api/v1/orders/{id}/pay [post]
+func PayOrder(w http.ResponseWriter, r *http.Request) {
+	change(w, r, "pay", StatusPaid, func(ctx context.Context, tx db.TxDB, o *Order) (hooks, error) {
+		if gateway == nil {
+			return hooks{}, errNoGateway
+		}
+		ref, err := gateway.Charge(ctx, payment.Charge{OrderID: o.ID, Amount: o.TotalMinor, Currency: o.Currency})
+		if err != nil {
+			return hooks{}, err
+		}
+		o.PaymentRef = &ref
+		return hooks{undo: func() { refund(ctx, o.ID, ref) }}, nil
+	})
+}
+
+// @Summary Отгрузить заказ
+// @Description Списывает отложенные экземпляры со складов; paid → shipped
+// @Tags orders
+// @Produce json
+// @Param id path int true "ID заказа"
+// @Success 200 {object} Order
+// @Failure 409 {string} string "заказ не оплачен"
+// @Router /api/v1/orders/{id}/ship [post]
+func ShipOrder(w http.ResponseWriter, r *http.Request) {
+	change(w, r, "ship", StatusShipped, func(ctx context.Context, tx db.TxDB, o *Order) (hooks, error) {
+		held, err := reservations(ctx, tx, o.ID)
+		if err != nil {
+			return hooks{}, err
+		}
+		for _, rs := range held {
+			if err := inventory.Fulfil(ctx, tx, rs.BookID, rs.Allocation); err != nil {
+				return hooks{}, err
+			}
+		}
+		return hooks{}, nil
+	})
+}
+
+// @Summary Отменить заказ
+// @Description Возвращает отложенные экземпляры в свободный остаток, а оплаченному
+// @Description заказу — деньги после коммита; pending или paid → cancelled.
+// @Description Оплаченный заказ отменяется только с правом orders:manage.
+// @Tags orders
+// @Produce json
+// @Param id path int true "ID заказа"
+// @Success 200 {object} Order
+// @Failure 403 {string} string "заказ оплачен, нужно право orders:manage"
+// @Failure 409 {string} string "заказ уже отгружен или отменён"
+// @Router /api/v1/orders/{id}/cancel [post]
+func CancelOrder(w http.ResponseWriter, r *http.Request) {
+	change(w, r, "cancel", StatusCancelled, func(ctx context.Context, tx db.TxDB, o *Order) (hooks, error) {
+		if o.Status == StatusPaid && !middleware.Can(r, middleware.PermOrdersManage) {
+			return hooks{}, errManageRequired
+		}
+		held, err := reservations(ctx, tx, o.ID)
+		if err != nil {
+			return hooks{}, err
+		}
+		for _, rs := range held {
+			if err := inventory.Release(ctx, tx, rs.BookID, rs.Allocation); err != nil {
+				return hooks{}, err
+			}
+		}
+		if o.Status != StatusPaid || o.PaymentRef == nil {
+			return hooks{}, nil
+		}
+		if gateway == nil {
+			return hooks{}, errNoGateway
+		}
+		// деньги возвращаются только за заказ, отмена которого зафиксирована
+		ref := *o.PaymentRef
+		return hooks{after: func() { refund(ctx, o.ID, ref) }}, nil
+	})
+}
@@ -0,0 +1,336 @@
+package orders
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/payment"
+	"books-api/internal/testutil"
+)
+
+var created = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// mockDB — корзина 5 пользователя alice в EUR с двумя экземплярами книги 1
+// по 12.99 (на складе main свободно free) и заказ 9 в статусе status
+type mockDB struct {
+	testutil.Tx
+	testutil.Statements
+	free       int
+	emptyCart  bool
+	noCart     bool
+	status     string
+	owner      string
+	conflicts  int
+	reserved   [][]any
+	paymentRef *string
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	switch {
+	case strings.HasPrefix(sql, "SELECT ci.book_id"):
+		if m.emptyCart {
+			return &testutil.Rows{}, nil
+		}
+		return &testutil.Rows{Values: [][]any{{1, "Dune", 2}}}, nil
+	case strings.HasPrefix(sql, "SELECT location"):
+		if m.free == 0 {
+			return &testutil.Rows{}, nil
+		}
+		return &testutil.Rows{Values: [][]any{{"main", m.free}}}, nil
+	case strings.HasPrefix(sql, "SELECT book_id, title"):
+		return &testutil.Rows{Values: [][]any{{1, "Dune", 2, int64(1299)}}}, nil
+	case strings.HasPrefix(sql, "SELECT book_id, location"):
+		return &testutil.Rows{Values: m.reserved}, nil
+	}
+	return &testutil.Rows{}, nil
+}
+
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	switch {
+	case strings.HasPrefix(sql, "SELECT id, user_id, currency"):
+		if m.noCart {
+			return testutil.Row{Err: pgx.ErrNoRows}
+		}
+		return testutil.Row{Values: []any{5, "alice", "EUR", created, created}}
+	case strings.HasPrefix(sql, "SELECT id, book_id, currency, amount"):
+		return testutil.Row{Values: []any{int64(3), 1, "EUR", int64(1299), created}}
+	case strings.HasPrefix(sql, "INSERT INTO orders"):
+		return testutil.Row{Values: []any{9, created, created}}
+	case strings.HasPrefix(sql, "SELECT id, user_id, status"):
+		owner := m.owner
+		if owner == "" {
+			owner = "alice"
+		}
+		return testutil.Row{Values: []any{9, owner, m.status, "EUR", int64(2598), m.paymentRef, created, created}}
+	case strings.HasPrefix(sql, "UPDATE orders"):
+		return testutil.Row{Values: []any{created}}
+	case strings.HasPrefix(sql, "UPDATE inventory"):
+		m.Record(sql, args)
+		return testutil.Row{Values: []any{3, 0}}
+	}
+	return testutil.Row{}
+}
+
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.Record(sql, args)
+	return pgconn.NewCommandTag("MOCK 1"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+// Commit отвечает конфликтом сериализации, пока не исчерпаны conflicts
+func (m *mockDB) Commit(ctx context.Context) error {
+	m.Tx.Commit(ctx)
+	if m.conflicts > 0 {
+		m.conflicts--
+		return &pgconn.PgError{Code: "40001"}
+	}
+	return nil
+}
+
+func setup(t *testing.T, m *mockDB) (*testutil.Producer, *payment.Fake) {
+	t.Helper()
+	p := &testutil.Producer{}
+	g := payment.NewFake()
+	SetOrderDB(m)
+	SetProducer(p)
+	SetPaymentGateway(g)
+	t.Cleanup(func() {
+		SetProducer(nil)
+		SetPaymentGateway(nil)
+	})
+	return p, g
+}
+
+var serve = testutil.Server(RegisterRoutes)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		ok       bool
+	}{
+		{StatusPending, StatusPaid, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusShipped, false},
+		{StatusPaid, StatusShipped, true},
+		{StatusPaid, StatusCancelled, true},
+		{StatusPaid, StatusPending, false},
+		{StatusShipped, StatusCancelled, false},
+		{StatusCancelled, StatusPaid, false},
+	}
+	for _, c := range cases {
+		if got := CanTransition(c.from, c.to); got != c.ok {
+			t.Errorf("CanTransition(%s, %s) = %v", c.from, c.to, got)
+		}
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	m := &mockDB{free: 5}
+	p, _ := setup(t, m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve("POST", "/orders", `{"cart_id": 5}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var o Order
+	if err := json.NewDecoder(w.Body).Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != 9 || o.Status != StatusPending || o.Total != "25.98" || o.TotalMinor != 2598 {
+		t.Errorf("order = %+v", o)
+	}
+	if len(o.Items) != 1 || o.Items[0].UnitPrice != "12.99" || o.Items[0].Quantity != 2 {
+		t.Errorf("items = %+v", o.Items)
+	}
+	for _, prefix := range []string{"UPDATE inventory SET", "INSERT INTO order_items", "INSERT INTO order_reservations", "DELETE FROM carts", "INSERT INTO audit_log"} {
+		if m.Count(prefix) != 1 {
+			t.Errorf("%s: execs = %v", prefix, m.SQL)
+		}
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "created order: 9 user=alice total=25.98 currency=EUR" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestPlaceOrderRetriesSerializationFailure(t *testing.T) {
+	m := &mockDB{free: 5, conflicts: 1}
+	p, _ := setup(t, m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve("POST", "/orders", `{"cart_id": 5}`); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if m.Commits != 2 {
+		t.Errorf("commits = %d", m.Commits)
+	}
+	// событие пишется в транзакции каждой попытки; Postgres откатывает его
+	// вместе с неудачной, мок этого не умеет
+	if len(p.Msgs) != 2 {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestPlaceOrderConflicts(t *testing.T) {
+	cases := map[string]struct {
+		m    *mockDB
+		code int
+	}{
+		"no stock":   {&mockDB{free: 1}, http.StatusConflict},
+		"empty cart": {&mockDB{free: 5, emptyCart: true}, http.StatusConflict},
+		"no cart":    {&mockDB{noCart: true}, http.StatusNotFound},
+	}
+	for name, c := range cases {
+		p, _ := setup(t, c.m)
+		testutil.AsUser(t, "alice", middleware.RoleReader)
+		if w := serve("POST", "/orders", `{"cart_id": 5}`); w.Code != c.code {
+			t.Errorf("%s: status = %d, body = %s", name, w.Code, w.Body.String())
+		}
+		if c.m.Commits != 0 || len(p.Msgs) != 0 {
+			t.Errorf("%s: commits = %d, msgs = %v", name, c.m.Commits, p.Msgs)
+		}
+	}
+}
+
+func TestPlaceOrderAnonymous(t *testing.T) {
+	setup(t, &mockDB{free: 5})
+	if w := serve("POST", "/orders", `{"cart_id": 5}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d", w.Code)
+	}
+}
+
+func TestPayOrder(t *testing.T) {
+	m := &mockDB{status: StatusPending}
+	p, g := setup(t, m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve("POST", "/orders/9/pay", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var o Order
+	if err := json.NewDecoder(w.Body).Decode(&o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != StatusPaid || o.PaymentRef == nil || g.Refunded(*o.PaymentRef) {
+		t.Errorf("order = %+v", o)
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "order status changed: 9 pending -> paid" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestPayOrderDeclined(t *testing.T) {
+	m := &mockDB{status: StatusPending}
+	p, g := setup(t, m)
+	g.DeclineAbove = 1000
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve("POST", "/orders/9/pay", ""); w.Code != http.StatusPaymentRequired {
+		t.Errorf("status = %d", w.Code)
+	}
+	if m.Commits != 0 || len(p.Msgs) != 0 {
+		t.Errorf("commits = %d, msgs = %v", m.Commits, p.Msgs)
+	}
+}
+
+func TestInvalidTransition(t *testing.T) {
+	cases := map[string]string{
+		"/orders/9/pay":    StatusShipped,
+		"/orders/9/cancel": StatusShipped,
+		"/orders/9/ship":   StatusPending,
+	}
+	for target, status := range cases {
+		m := &mockDB{status: status}
+		p, _ := setup(t, m)
+		testutil.AsUser(t, "alice", middleware.RoleAdmin)
+		if w := serve("POST", target, ""); w.Code != http.StatusConflict {
+			t.Errorf("%s from %s: status = %d", target, status, w.Code)
+		}
+		if len(m.SQL) != 0 || len(p.Msgs) != 0 {
+			t.Errorf("%s: execs = %v, msgs = %v", target, m.SQL, p.Msgs)
+		}
+	}
+}
+
+func TestShipOrder(t *testing.T) {
+	m := &mockDB{status: StatusPaid, reserved: [][]any{{1, "main", 2}}}
+	p, _ := setup(t, m)
+	testutil.AsUser(t, "bob", middleware.RoleAdmin)
+	if w := serve("POST", "/orders/9/ship", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "order status changed: 9 paid -> shipped" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+	// покупатель сам отгрузить не может
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve("POST", "/orders/9/ship", ""); w.Code != http.StatusForbidden {
+		t.Errorf("reader: status = %d", w.Code)
+	}
+}
+
+func TestCancelPaidOrder(t *testing.T) {
+	m := &mockDB{status: StatusPaid, reserved: [][]any{{1, "main", 2}}}
+	p, g := setup(t, m)
+	ref, _ := g.Charge(context.Background(), payment.Charge{OrderID: 9, Amount: 2598, Currency: "EUR"})
+	m.paymentRef = &ref
+	// покупатель оплаченный заказ не отменяет, возврат решает магазин
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve("POST", "/orders/9/cancel", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("reader: status = %d", w.Code)
+	}
+	if g.Refunded(ref) || m.Commits != 0 || len(m.SQL) != 0 {
+		t.Fatalf("reader: refunded = %v, commits = %d, execs = %v", g.Refunded(ref), m.Commits, m.SQL)
+	}
+	testutil.AsUser(t, "bob", middleware.RoleAdmin)
+	if w := serve("POST", "/orders/9/cancel", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if !g.Refunded(ref) {
+		t.Error("payment not refunded")
+	}
+	if m.Count("UPDATE inventory SET") != 1 || m.Args[0][2] != -2 {
+		t.Errorf("execs = %v, args = %v", m.SQL, m.Args)
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "order status changed: 9 paid -> cancelled" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestCancelRefundsAfterCommit(t *testing.T) {
+	m := &mockDB{status: StatusPaid, conflicts: 1}
+	_, g := setup(t, m)
+	ref, _ := g.Charge(context.Background(), payment.Charge{OrderID: 9, Amount: 2598, Currency: "EUR"})
+	m.paymentRef = &ref
+	testutil.AsUser(t, "bob", middleware.RoleAdmin)
+	if w := serve("POST", "/orders/9/cancel", ""); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d", w.Code)
+	}
+	// отмена не зафиксирована — деньги остаются списанными
+	if g.Refunded(ref) {
+		t.Error("payment refunded without a committed cancel")
+	}
+}
+
+func TestForeignOrderHidden(t *testing.T) {
+	m := &mockDB{status: StatusPending, owner: "bob"}
+	setup(t, m)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	for _, c := range []struct{ method, target string }{{"GET", "/orders/9"}, {"POST", "/orders/9/cancel"}} {
+		if w := serve(c.method, c.target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: status = %d", c.method, c.target, w.Code)
+		}
+	}
+	testutil.AsUser(t, "carol", middleware.RoleAdmin)
+	if w := serve("GET", "/orders/9", ""); w.Code != http.StatusOK {
+		t.Errorf("admin: status = %d", w.Code)
+	}
+}
@@ -0,0 +1,21 @@
+package orders
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты заказов. Покупатель видит и меняет
+// только свои заказы, отгрузка — только с правом orders:manage, отмена
+// оплаченного заказа проверяет это право в самом обработчике.
+func RegisterRoutes(r chi.Router) {
+	r.Route("/orders", func(r chi.Router) {
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Post("/", PlaceOrder)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Get("/", ListOrders)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Get("/{id}", GetOrder)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Post("/{id}/pay", PayOrder)
+		r.With(middleware.Require(middleware.PermOrdersManage)).Post("/{id}/ship", ShipOrder)
+		r.With(middleware.Require(middleware.PermOrdersPlace)).Post("/{id}/cancel", CancelOrder)
+	})
+}
@@ -0,0 +1,42 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Статусы заказа
+const (
+	StatusPending   = "pending"
+	StatusPaid      = "paid"
+	StatusShipped   = "shipped"
+	StatusCancelled = "cancelled"
+)
+
+// transitions — допустимые переходы между статусами. shipped и cancelled
+// конечные: отгруженный заказ не отменить, отменённый не оплатить.
+var transitions = map[string][]string{
+	StatusPending: {StatusPaid, StatusCancelled},
+	StatusPaid:    {StatusShipped, StatusCancelled},
+}
+
+// ErrInvalidTransition — из текущего статуса в запрошенный перейти нельзя
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// CanTransition проверяет, можно ли перевести заказ из from в to
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition — CanTransition с ошибкой для ответа
+func checkTransition(from, to string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: order is %s, cannot become %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package outbox хранит события в таблице outbox и публикует их в Kafka
+// отдельным процессом (relay), чтобы недоступность брокера не ломала запросы.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+)
+
+// Publisher — то, куда relay отправляет события (обычно *kafka.Writer)
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Write сохраняет сообщения в outbox в транзакции tx: событие фиксируется
+// или откатывается вместе с изменением, о котором оно сообщает
+func Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		if _, err := tx.Exec(ctx, "INSERT INTO outbox (key, value) VALUES ($1, $2)", m.Key, m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writer сохраняет сообщения в outbox вместо прямой отправки в Kafka.
+// Реализует интерфейс Producer пакетов с обработчиками.
+type Writer struct{}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
+	return Write(ctx, tx, msgs...)
+}
+
+func (w *Writer) Close() error {
+	return nil
+}
+
+// Relay периодически выбирает неотправленные события и публикует их
+type Relay struct {
+	DB        db.TxDB
+	Publisher Publisher
+	BatchSize int
+	Interval  time.Duration
+}
+
+func NewRelay(database db.TxDB, publisher Publisher, interval time.Duration) *Relay {
+	return &Relay{DB: database, Publisher: publisher, BatchSize: 100, Interval: interval}
+}
+
+// Run крутит цикл публикации до отмены контекста
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.RelayOnce(ctx)
+			if err != nil {
+				log.Printf("ошибка outbox relay: %v", err)
+				break
+			}
+			if n < r.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce публикует одну пачку событий и возвращает их количество.
+// Строки блокируются через SKIP LOCKED, поэтому несколько relay не
+// отправят одно и то же событие одновременно.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	rows, err := tx.Query(ctx, "SELECT id, key, value FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	var msgs []kafka.Message
+	for rows.Next() {
+		var id int64
+		var m kafka.Message
+		if err := rows.Scan(&id, &m.Key, &m.Value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	if err := r.Publisher.WriteMessages(ctx, msgs...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE outbox SET sent_at = NOW() WHERE id = ANY($1)", ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+)
+
+type mockRows struct {
+	values []string
+	idx    int
+}
+
+func (r *mockRows) Next() bool { r.idx++; return r.idx <= len(r.values) }
+func (r *mockRows) Scan(dest ...any) error {
+	*dest[0].(*int64) = int64(r.idx)
+	*dest[2].(*[]byte) = []byte(r.values[r.idx-1])
+	return nil
+}
+func (r *mockRows) Close()     {}
+func (r *mockRows) Err() error { return nil }
+
+type mockDB struct {
+	pending []string
+	execs   []string
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	return &mockRows{values: m.pending}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row { return nil }
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.execs = append(m.execs, sql)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+func (m *mockDB) Rollback(ctx context.Context) error { return nil }
+func (m *mockDB) Commit(ctx context.Context) error   { return nil }
+
+type mockPublisher struct{ msgs []kafka.Message }
+
+func (p *mockPublisher) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	p.msgs = append(p.msgs, msgs...)
+	return nil
+}
+
+func TestWriterStoresMessages(t *testing.T) {
+	m := &mockDB{}
+	if err := NewWriter().Write(context.Background(), m, kafka.Message{Value: []byte("a")}, kafka.Message{Value: []byte("b")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.execs) != 2 || !strings.HasPrefix(m.execs[0], "INSERT INTO outbox") {
+		t.Fatalf("unexpected execs: %v", m.execs)
+	}
+}
+
+func TestRelayOncePublishesAndMarksSent(t *testing.T) {
+	m := &mockDB{pending: []string{"created book: A", "created book: B"}}
+	p := &mockPublisher{}
+	r := NewRelay(m, p, 0)
+	n, err := r.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 || len(p.msgs) != 2 || string(p.msgs[1].Value) != "created book: B" {
+		t.Fatalf("unexpected publish result: n=%d msgs=%v", n, p.msgs)
+	}
+	if len(m.execs) != 1 || !strings.HasPrefix(m.execs[0], "UPDATE outbox SET sent_at") {
+		t.Fatalf("expected sent_at update, got %v", m.execs)
+	}
+}
+
+func TestRelayOnceEmpty(t *testing.T) {
+	m := &mockDB{}
+	p := &mockPublisher{}
+	n, err := NewRelay(m, p, 0).RelayOnce(context.Background())
+	if err != nil || n != 0 || len(p.msgs) != 0 {
+		t.Fatalf("unexpected result: n=%d err=%v", n, err)
+	}
+}
@@ -0,0 +1,75 @@
+// Package payment — приём оплаты заказов. Обработчики работают с Gateway,
+// а конкретный провайдер подключается в cmd/serve.go.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDeclined — провайдер отказал в оплате
+var ErrDeclined = errors.New("payment declined")
+
+// Charge — списание за заказ; Amount в минимальных единицах валюты
+type Charge struct {
+	OrderID  int
+	Amount   int64
+	Currency string
+}
+
+// Gateway — провайдер оплаты. Charge возвращает идентификатор платежа,
+// по которому его можно вернуть через Refund.
+type Gateway interface {
+	Charge(ctx context.Context, c Charge) (string, error)
+	Refund(ctx context.Context, ref string) error
+}
+
+// Fake — локальный провайдер для разработки и тестов: ничего не списывает,
+// только запоминает платежи. Платежи больше DeclineAbove (если он задан)
+// отклоняются.
+type Fake struct {
+	DeclineAbove int64
+
+	mu       sync.Mutex
+	seq      int
+	payments map[string]Charge
+	refunded map[string]bool
+}
+
+func NewFake() *Fake {
+	return &Fake{payments: map[string]Charge{}, refunded: map[string]bool{}}
+}
+
+func (f *Fake) Charge(ctx context.Context, c Charge) (string, error) {
+	if f.DeclineAbove > 0 && c.Amount > f.DeclineAbove {
+		return "", ErrDeclined
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	ref := fmt.Sprintf("fake_%d_%d", c.OrderID, f.seq)
+	f.payments[ref] = c
+	return ref, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, ref string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.payments[ref]; !ok {
+		return fmt.Errorf("unknown payment %s", ref)
+	}
+	if f.refunded[ref] {
+		return fmt.Errorf("payment %s already refunded", ref)
+	}
+	f.refunded[ref] = true
+	return nil
+}
+
+// Refunded сообщает, был ли платёж возвращён
+func (f *Fake) Refunded(ref string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refunded[ref]
+}
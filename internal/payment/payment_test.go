@@ -0,0 +1,29 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake()
+	f.DeclineAbove = 10000
+	ref, err := f.Charge(ctx, Charge{OrderID: 3, Amount: 1299, Currency: "EUR"})
+	if err != nil || ref == "" {
+		t.Fatalf("ref = %q, err = %v", ref, err)
+	}
+	if _, err := f.Charge(ctx, Charge{OrderID: 4, Amount: 10001, Currency: "EUR"}); !errors.Is(err, ErrDeclined) {
+		t.Errorf("err = %v", err)
+	}
+	if err := f.Refund(ctx, ref); err != nil || !f.Refunded(ref) {
+		t.Errorf("refund: err = %v", err)
+	}
+	if err := f.Refund(ctx, ref); err == nil {
+		t.Error("second refund succeeded")
+	}
+	if err := f.Refund(ctx, "unknown"); err == nil {
+		t.Error("refund of unknown payment succeeded")
+	}
+}
@@ -0,0 +1,346 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+
+func SetPricingDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+// now — текущее время; в тестах подменяется
+var now = time.Now
+
+// ErrNoPrice — на этот момент цены в этой валюте нет
+var ErrNoPrice = errors.New("no price")
+
+// ErrBookNotFound — книги нет или она в корзине
+var ErrBookNotFound = errors.New("book not found")
+
+// Price — цена книги в одной валюте на период. Amount — десятичная строка,
+// AmountMinor — то же в минимальных единицах валюты.
+type Price struct {
+	XMLName     xml.Name   `json:"-" xml:"price"`
+	ID          int64      `json:"id" xml:"id"`
+	BookID      int        `json:"book_id" xml:"book_id"`
+	Currency    string     `json:"currency" xml:"currency"`
+	Amount      string     `json:"amount" xml:"amount"`
+	AmountMinor int64      `json:"amount_minor" xml:"amount_minor"`
+	ValidFrom   time.Time  `json:"valid_from" xml:"valid_from"`
+	ValidTo     *time.Time `json:"valid_to,omitempty" xml:"valid_to,omitempty"`
+	CreatedBy   *string    `json:"created_by,omitempty" xml:"created_by,omitempty"`
+}
+
+func (p Price) CSVHeader() []string {
+	return []string{"id", "book_id", "currency", "amount", "valid_from", "valid_to"}
+}
+
+func (p Price) CSVRecord() []string {
+	validTo := ""
+	if p.ValidTo != nil {
+		validTo = p.ValidTo.Format(time.RFC3339)
+	}
+	return []string{strconv.FormatInt(p.ID, 10), strconv.Itoa(p.BookID), p.Currency, p.Amount, p.ValidFrom.Format(time.RFC3339), validTo}
+}
+
+const priceColumns = "id, book_id, currency, amount, valid_from, valid_to, created_by"
+
+func scanPrice(row db.Row) (Price, error) {
+	var p Price
+	err := row.Scan(&p.ID, &p.BookID, &p.Currency, &p.AmountMinor, &p.ValidFrom, &p.ValidTo, &p.CreatedBy)
+	p.Amount = FormatAmount(p.AmountMinor, p.Currency)
+	return p, err
+}
+
+// Current возвращает цену, действующую в момент at; ErrNoPrice — её нет
+func Current(ctx context.Context, q db.TxDB, bookID int, currency string, at time.Time) (Price, error) {
+	p, err := scanPrice(q.QueryRow(ctx, `SELECT `+priceColumns+` FROM prices
+		WHERE book_id = $1 AND currency = $2 AND valid_from <= $3 AND (valid_to IS NULL OR valid_to > $3)`, bookID, currency, at))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, ErrNoPrice
+	}
+	return p, err
+}
+
+// @Summary Текущая цена книги
+// @Description Цена, действующая сейчас (или в момент at) в указанной валюте
+// @Tags pricing
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param currency query string true "Код валюты ISO 4217"
+// @Param at query string false "Момент времени, RFC 3339"
+// @Success 200 {object} Price
+// @Failure 404 {string} string "цены нет"
+// @Router /api/v1/books/{id}/price [get]
+func GetPrice(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	currency, err := NormalizeCurrency(r.URL.Query().Get("currency"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	at := now()
+	if s := r.URL.Query().Get("at"); s != "" {
+		if at, err = time.Parse(time.RFC3339, s); err != nil {
+			http.Error(w, "at must be an RFC 3339 timestamp", 400)
+			return
+		}
+	}
+	p, err := Current(r.Context(), dbi, bookID, currency, at)
+	if errors.Is(err, ErrNoPrice) {
+		http.Error(w, fmt.Sprintf("book %d has no %s price at %s", bookID, currency, at.Format(time.RFC3339)), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, p)
+}
+
+// @Summary История цен книги
+// @Description Прошлые, текущая и запланированные цены по порядку начала действия
+// @Tags pricing
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param currency query string false "Только эта валюта"
+// @Success 200 {array} Price
+// @Router /api/v1/books/{id}/prices [get]
+func ListPrices(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	query := "SELECT " + priceColumns + " FROM prices WHERE book_id = $1"
+	args := []any{bookID}
+	if s := r.URL.Query().Get("currency"); s != "" {
+		currency, err := NormalizeCurrency(s)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		query += " AND currency = $2"
+		args = append(args, currency)
+	}
+	rows, err := dbi.Query(r.Context(), query+" ORDER BY currency, valid_from", args...)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	list := []Price{}
+	for rows.Next() {
+		p, err := scanPrice(rows)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		list = append(list, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, list)
+}
+
+// priceInput — новая цена. Amount — десятичная строка "12.99" или число;
+// без valid_from цена действует сразу, без valid_to — до следующей.
+type priceInput struct {
+	Currency  string          `json:"currency"`
+	Amount    json.RawMessage `json:"amount" swaggertype:"string"`
+	ValidFrom *time.Time      `json:"valid_from,omitempty"`
+	ValidTo   *time.Time      `json:"valid_to,omitempty"`
+}
+
+// price проверяет ввод и собирает из него цену; задним числом цену
+// не назначить, чтобы не переписывать уже выставленные счета
+func (in priceInput) price(bookID int, t time.Time) (Price, error) {
+	p := Price{BookID: bookID, ValidFrom: t, ValidTo: in.ValidTo}
+	var err error
+	if p.Currency, err = NormalizeCurrency(in.Currency); err != nil {
+		return p, err
+	}
+	text, err := decimalText(in.Amount)
+	if err != nil {
+		return p, err
+	}
+	if p.AmountMinor, err = ParseAmount(text, p.Currency); err != nil {
+		return p, err
+	}
+	p.Amount = FormatAmount(p.AmountMinor, p.Currency)
+	if in.ValidFrom != nil {
+		if in.ValidFrom.Before(t) {
+			return p, errors.New("valid_from must not be in the past")
+		}
+		p.ValidFrom = *in.ValidFrom
+	}
+	if p.ValidTo != nil && !p.ValidTo.After(p.ValidFrom) {
+		return p, errors.New("valid_to must be after valid_from")
+	}
+	return p, nil
+}
+
+// schedule вписывает период новой цены в историю: пересекающиеся периоды
+// обрезаются, целиком перекрытые (только будущие) удаляются, а если новый
+// период лежит внутри старого, хвост старого сохраняется отдельной строкой.
+// Цена без valid_to действует до следующей запланированной, а не вместо неё.
+func schedule(ctx context.Context, tx db.TxDB, p *Price) error {
+	// параллельные назначения одной книги и валюты идут по одному
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", p.BookID, p.Currency); err != nil {
+		return err
+	}
+	if p.ValidTo == nil {
+		err := tx.QueryRow(ctx, "SELECT min(valid_from) FROM prices WHERE book_id = $1 AND currency = $2 AND valid_from > $3",
+			p.BookID, p.Currency, p.ValidFrom).Scan(&p.ValidTo)
+		if err != nil {
+			return err
+		}
+	}
+	steps := []string{
+		// хвост периода, накрывающего новый целиком
+		`INSERT INTO prices (book_id, currency, amount, valid_from, valid_to, created_by)
+			SELECT book_id, currency, amount, $4, valid_to, created_by FROM prices
+			WHERE book_id = $1 AND currency = $2 AND $4::timestamptz IS NOT NULL
+				AND valid_from < $3 AND (valid_to IS NULL OR valid_to > $4)`,
+		// периоды, начавшиеся внутри нового и идущие дальше, сдвигаются на его конец
+		`UPDATE prices SET valid_from = $4 WHERE book_id = $1 AND currency = $2 AND $4::timestamptz IS NOT NULL
+			AND valid_from >= $3 AND valid_from < $4 AND (valid_to IS NULL OR valid_to > $4)`,
+		// периоды целиком внутри нового
+		`DELETE FROM prices WHERE book_id = $1 AND currency = $2 AND valid_from >= $3
+			AND ($4::timestamptz IS NULL OR (valid_to IS NOT NULL AND valid_to <= $4))`,
+		// период, действующий на начало нового, заканчивается на нём
+		`UPDATE prices SET valid_to = $3 WHERE book_id = $1 AND currency = $2
+			AND valid_from < $3 AND (valid_to IS NULL OR valid_to > $3)`,
+	}
+	for _, sql := range steps {
+		if _, err := tx.Exec(ctx, sql, p.BookID, p.Currency, p.ValidFrom, p.ValidTo); err != nil {
+			return err
+		}
+	}
+	return tx.QueryRow(ctx, `INSERT INTO prices (book_id, currency, amount, valid_from, valid_to, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		p.BookID, p.Currency, p.AmountMinor, p.ValidFrom, p.ValidTo, p.CreatedBy).Scan(&p.ID)
+}
+
+// notifyPrice сообщает о новой или запланированной цене
+func notifyPrice(ctx context.Context, tx db.TxDB, p Price) error {
+	if producer == nil {
+		return nil
+	}
+	msg := fmt.Sprintf("price changed: book=%d currency=%s amount=%s valid_from=%s", p.BookID, p.Currency, p.Amount, p.ValidFrom.UTC().Format(time.RFC3339))
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// @Summary Назначить цену книги
+// @Description Цена действует с valid_from (по умолчанию — сразу) до valid_to
+// @Description (по умолчанию — до следующей запланированной или бессрочно).
+// @Description Пересекающиеся периоды обрезаются, перекрытые будущие — заменяются.
+// @Tags pricing
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param price body priceInput true "Валюта, сумма и период"
+// @Success 201 {object} Price
+// @Failure 404 {string} string "книги нет"
+// @Router /api/v1/books/{id}/prices [post]
+func SetPrice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	bookID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "invalid id", 400)
+		return
+	}
+	var in priceInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	p, err := in.price(bookID, now())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if id, ok := middleware.Caller(r); ok && id.Subject != "" {
+		p.CreatedBy = &id.Subject
+	}
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && err != pgx.ErrTxClosed {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}()
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM books WHERE id=$1 AND deleted_at IS NULL)", bookID).Scan(&exists); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !exists {
+		http.Error(w, ErrBookNotFound.Error(), 404)
+		return
+	}
+	before, err := Current(ctx, tx, bookID, p.Currency, p.ValidFrom)
+	if err != nil && !errors.Is(err, ErrNoPrice) {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := schedule(ctx, tx, &p); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	var prev any
+	if before.ID != 0 {
+		prev = before
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "set_price", "price", &bookID, prev, p); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notifyPrice(ctx, tx, p); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, p)
+}
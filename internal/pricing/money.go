@@ -0,0 +1,107 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Суммы хранятся целым числом минимальных единиц валюты (центы, копейки),
+// в API — десятичной строкой. float нигде не участвует.
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// minorDigits — число знаков после запятой для валют, где их не два
+var minorDigits = map[string]int{
+	"JPY": 0, "KRW": 0, "VND": 0, "CLP": 0, "ISK": 0, "UGX": 0,
+	"BHD": 3, "KWD": 3, "OMR": 3, "JOD": 3, "TND": 3, "IQD": 3, "LYD": 3,
+}
+
+// Exponent — сколько знаков после запятой у валюты
+func Exponent(currency string) int {
+	if d, ok := minorDigits[currency]; ok {
+		return d
+	}
+	return 2
+}
+
+// NormalizeCurrency приводит код ISO 4217 к верхнему регистру и проверяет формат
+func NormalizeCurrency(s string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(s))
+	if !currencyPattern.MatchString(c) {
+		return "", errors.New("currency must be a three-letter ISO 4217 code")
+	}
+	return c, nil
+}
+
+var amountPattern = regexp.MustCompile(`^(\d+)(?:\.(\d+))?$`)
+
+// ParseAmount переводит десятичную строку вроде "12.99" в минимальные
+// единицы валюты. Больше знаков после запятой, чем у валюты, — ошибка,
+// а не округление.
+func ParseAmount(s, currency string) (int64, error) {
+	m := amountPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("amount %q must be a non-negative decimal like 12.99", s)
+	}
+	exp := Exponent(currency)
+	frac := m[2]
+	if len(frac) > exp {
+		if strings.TrimRight(frac[exp:], "0") != "" {
+			return 0, fmt.Errorf("amount %q has more than %d decimal places for %s", s, exp, currency)
+		}
+		frac = frac[:exp]
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+	whole, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || whole > math.MaxInt64/pow10(exp) {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	var minor int64
+	if frac != "" {
+		minor, _ = strconv.ParseInt(frac, 10, 64)
+	}
+	return whole*pow10(exp) + minor, nil
+}
+
+// FormatAmount — обратное к ParseAmount: 1299, "EUR" → "12.99"
+func FormatAmount(minor int64, currency string) string {
+	exp := Exponent(currency)
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(minor, 10)
+	}
+	p := pow10(exp)
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/p, exp, minor%p)
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// decimalText достаёт текст суммы из JSON: строку "12.99" или число 12.99
+// как есть, без разбора в float
+func decimalText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", errors.New("amount is required")
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	return string(raw), nil
+}
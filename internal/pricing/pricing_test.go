@@ -0,0 +1,279 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/testutil"
+)
+
+var fixedNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		in, currency string
+		want         int64
+		ok           bool
+	}{
+		{"12.99", "EUR", 1299, true},
+		{"12.9", "EUR", 1290, true},
+		{"12", "EUR", 1200, true},
+		{"0.10", "USD", 10, true},
+		{"12.990", "EUR", 1299, true},
+		{"1500", "JPY", 1500, true},
+		{"1.250", "KWD", 1250, true},
+		{"12.999", "EUR", 0, false},
+		{"1.5", "JPY", 0, false},
+		{"-1.00", "EUR", 0, false},
+		{"1e3", "EUR", 0, false},
+		{"", "EUR", 0, false},
+		{"99999999999999999999", "EUR", 0, false},
+	}
+	for _, c := range cases {
+		got, err := ParseAmount(c.in, c.currency)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("ParseAmount(%q, %s) = %d, %v", c.in, c.currency, got, err)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{1299, "EUR", "12.99"},
+		{5, "EUR", "0.05"},
+		{1500, "JPY", "1500"},
+		{1250, "KWD", "1.250"},
+		{-1299, "EUR", "-12.99"},
+	}
+	for _, c := range cases {
+		if got := FormatAmount(c.minor, c.currency); got != c.want {
+			t.Errorf("FormatAmount(%d, %s) = %q, want %q", c.minor, c.currency, got, c.want)
+		}
+	}
+}
+
+// priceRow — цена 12.99 EUR с 1 января
+type priceRow struct{ err error }
+
+func (p priceRow) Scan(dest ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	*dest[0].(*int64) = 7
+	*dest[1].(*int) = 1
+	*dest[2].(*string) = "EUR"
+	*dest[3].(*int64) = 1299
+	*dest[4].(*time.Time) = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return nil
+}
+
+type priceRows struct{ idx, n int }
+
+func (r *priceRows) Next() bool             { r.idx++; return r.idx <= r.n }
+func (r *priceRows) Scan(dest ...any) error { return priceRow{}.Scan(dest...) }
+func (r *priceRows) Close()                 {}
+func (r *priceRows) Err() error             { return nil }
+
+type mockDB struct {
+	testutil.Tx
+	testutil.Statements
+	noBook  bool
+	noPrice bool
+	rows    int
+	args    [][]any
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.args = append(m.args, args)
+	return &priceRows{n: m.rows}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	m.args = append(m.args, args)
+	switch {
+	case strings.HasPrefix(sql, "SELECT EXISTS"):
+		return testutil.Row{Values: []any{!m.noBook}}
+	case strings.HasPrefix(sql, "INSERT INTO prices"):
+		return testutil.Row{Values: []any{int64(8)}}
+	case strings.HasPrefix(sql, "SELECT min(valid_from)"):
+		// следующей запланированной цены нет
+		return testutil.Row{Values: []any{nil}}
+	case m.noPrice:
+		return priceRow{err: pgx.ErrNoRows}
+	}
+	return priceRow{}
+}
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.Record(sql, args)
+	return pgconn.NewCommandTag("MOCK"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+func setup(t *testing.T, m *mockDB) *testutil.Producer {
+	t.Helper()
+	p := &testutil.Producer{}
+	SetPricingDB(m)
+	SetProducer(p)
+	now = func() time.Time { return fixedNow }
+	t.Cleanup(func() {
+		SetProducer(nil)
+		now = time.Now
+	})
+	return p
+}
+
+var serve = testutil.Server(books.RegisterRoutes, RegisterRoutes)
+
+func TestGetPrice(t *testing.T) {
+	m := &mockDB{}
+	setup(t, m)
+	w := serve("GET", "/books/1/price?currency=eur", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var p Price
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Amount != "12.99" || p.AmountMinor != 1299 || p.Currency != "EUR" {
+		t.Errorf("price = %+v", p)
+	}
+	if args := m.args[0]; args[1] != "EUR" || !args[2].(time.Time).Equal(fixedNow) {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func TestGetPriceAt(t *testing.T) {
+	m := &mockDB{}
+	setup(t, m)
+	if w := serve("GET", "/books/1/price?currency=EUR&at=2026-06-01T00:00:00Z", ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if at := m.args[0][2].(time.Time); !at.Equal(time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("at = %v", at)
+	}
+	if w := serve("GET", "/books/1/price?currency=EUR&at=tomorrow", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bad at: status = %d", w.Code)
+	}
+}
+
+func TestGetPriceErrors(t *testing.T) {
+	setup(t, &mockDB{noPrice: true})
+	if w := serve("GET", "/books/1/price?currency=EUR", ""); w.Code != http.StatusNotFound {
+		t.Errorf("no price: status = %d", w.Code)
+	}
+	for _, target := range []string{"/books/1/price", "/books/1/price?currency=EURO", "/books/x/price?currency=EUR"} {
+		if w := serve("GET", target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", target, w.Code)
+		}
+	}
+}
+
+func TestListPrices(t *testing.T) {
+	m := &mockDB{rows: 2}
+	setup(t, m)
+	w := serve("GET", "/books/1/prices?currency=eur", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var list []Price
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Amount != "12.99" {
+		t.Errorf("list = %+v", list)
+	}
+	if len(m.args[0]) != 2 || m.args[0][1] != "EUR" {
+		t.Errorf("args = %v", m.args[0])
+	}
+}
+
+func TestSetPrice(t *testing.T) {
+	m := &mockDB{}
+	p := setup(t, m)
+	w := serve("POST", "/books/1/prices", `{"currency": "eur", "amount": "14.50", "valid_from": "2026-04-01T00:00:00Z"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got Price
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 8 || got.AmountMinor != 1450 || got.Amount != "14.50" || got.ValidTo != nil {
+		t.Errorf("price = %+v", got)
+	}
+	// блокировка, четыре шага вписывания периода и журнал
+	want := []string{"SELECT", "INSERT", "UPDATE", "DELETE", "UPDATE", "INSERT"}
+	var verbs []string
+	for _, sql := range m.SQL {
+		verbs = append(verbs, strings.Fields(sql)[0])
+	}
+	if strings.Join(verbs, " ") != strings.Join(want, " ") {
+		t.Errorf("execs = %v", m.SQL)
+	}
+	if len(p.Msgs) != 1 || p.Msgs[0] != "price changed: book=1 currency=EUR amount=14.50 valid_from=2026-04-01T00:00:00Z" {
+		t.Errorf("msgs = %v", p.Msgs)
+	}
+}
+
+func TestSetPriceNumberAmount(t *testing.T) {
+	setup(t, &mockDB{noPrice: true})
+	// JSON-число разбирается как текст, без float
+	w := serve("POST", "/books/1/prices", `{"currency": "USD", "amount": 19.99}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got Price
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.AmountMinor != 1999 || !got.ValidFrom.Equal(fixedNow) {
+		t.Errorf("price = %+v", got)
+	}
+}
+
+func TestSetPriceValidation(t *testing.T) {
+	cases := map[string]string{
+		"past":      `{"currency": "EUR", "amount": "1.00", "valid_from": "2026-01-01T00:00:00Z"}`,
+		"range":     `{"currency": "EUR", "amount": "1.00", "valid_from": "2026-05-01T00:00:00Z", "valid_to": "2026-04-01T00:00:00Z"}`,
+		"precision": `{"currency": "EUR", "amount": "1.001"}`,
+		"negative":  `{"currency": "EUR", "amount": "-1"}`,
+		"missing":   `{"currency": "EUR"}`,
+		"currency":  `{"currency": "euro", "amount": "1"}`,
+	}
+	for name, body := range cases {
+		m := &mockDB{}
+		setup(t, m)
+		if w := serve("POST", "/books/1/prices", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", name, w.Code)
+		}
+		if len(m.SQL) != 0 {
+			t.Errorf("%s: execs = %v", name, m.SQL)
+		}
+	}
+}
+
+func TestSetPriceNoBook(t *testing.T) {
+	m := &mockDB{noBook: true}
+	p := setup(t, m)
+	if w := serve("POST", "/books/1/prices", `{"currency": "EUR", "amount": "1.00"}`); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d", w.Code)
+	}
+	if len(m.SQL) != 0 || len(p.Msgs) != 0 {
+		t.Errorf("execs = %v, msgs = %v", m.SQL, p.Msgs)
+	}
+}
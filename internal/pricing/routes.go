@@ -0,0 +1,15 @@
+package pricing
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты цен. Пути полные, а не через
+// Route("/books/{id}"): такой подроутер перехватил бы и GET /books/{id}.
+func RegisterRoutes(r chi.Router) {
+	r.With(middleware.Require(middleware.PermRead)).Get("/books/{id}/price", GetPrice)
+	r.With(middleware.Require(middleware.PermRead)).Get("/books/{id}/prices", ListPrices)
+	r.With(middleware.Require(middleware.PermPricesWrite)).Post("/books/{id}/prices", SetPrice)
+}
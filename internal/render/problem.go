@@ -0,0 +1,27 @@
+package render
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Problem — тело ошибки по RFC 9457 (application/problem+json)
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// WriteProblem отвечает problem+json независимо от Accept: клиенты
+// разбирают ошибки отдельно от обычных ответов
+func WriteProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	p := Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Instance: r.URL.Path}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("ошибка записи ответа: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package render
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const FormatXML = "xml"
+
+// mediaTypes — какие MIME-типы из Accept соответствуют форматам
+var mediaTypes = map[string][]string{
+	FormatJSON:   {"application/json"},
+	FormatNDJSON: {"application/x-ndjson", "application/ndjson"},
+	FormatCSV:    {"text/csv"},
+	FormatXML:    {"application/xml", "text/xml"},
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	// при равном q более конкретный тип важнее шаблона
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+	return ranges
+}
+
+func matches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(mediaRange, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return false
+}
+
+// Negotiate выбирает из offers формат, который клиент предпочитает по
+// заголовку Accept. Без Accept, как и для */*, побеждает первый из offers.
+func Negotiate(r *http.Request, offers ...string) (string, bool) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offers[0], true
+	}
+	for _, ar := range parseAccept(header) {
+		for _, format := range offers {
+			for _, mt := range mediaTypes[format] {
+				if matches(ar.mediaType, mt) {
+					return format, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// Acceptable отклоняет с 406 запросы, Accept которых не допускает ни
+// одного из поддерживаемых форматов, ещё до выполнения обработчика
+func Acceptable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offers := []string{FormatJSON, FormatNDJSON, FormatCSV, FormatXML}
+		if _, ok := Negotiate(r, offers...); !ok {
+			NotAcceptable(w, offers...)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NotAcceptable отвечает 406 со списком поддерживаемых типов
+func NotAcceptable(w http.ResponseWriter, offers ...string) {
+	var types []string
+	for _, f := range offers {
+		types = append(types, mediaTypes[f]...)
+	}
+	http.Error(w, "not acceptable, supported types: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+}
+
+// Render сериализует v в формат, выбранный по Accept, и выставляет
+// Content-Type. CSV доступен, только если v (или элементы слайса)
+// реализует CSVer.
+func Render(w http.ResponseWriter, r *http.Request, status int, v any) {
+	offers := []string{FormatJSON, FormatNDJSON, FormatXML}
+	if csvCapable(v) {
+		offers = append(offers, FormatCSV)
+	}
+	format, ok := Negotiate(r, offers...)
+	if !ok {
+		NotAcceptable(w, offers...)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	if err := encode(w, format, v); err != nil {
+		log.Printf("ошибка записи ответа: %v", err)
+	}
+}
+
+func encode(w http.ResponseWriter, format string, v any) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(v)
+	case FormatXML:
+		if _, err := w.Write([]byte(xml.Header)); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		if !isSlice(v) {
+			return enc.Encode(v)
+		}
+		root := xml.StartElement{Name: xml.Name{Local: "items"}}
+		if err := enc.EncodeToken(root); err != nil {
+			return err
+		}
+		rv := reflect.ValueOf(v)
+		for i := 0; i < rv.Len(); i++ {
+			if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(root.End()); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+	if format == FormatCSV && isSlice(v) && reflect.ValueOf(v).Len() == 0 {
+		// пустой список — только заголовок
+		elem := reflect.Zero(reflect.TypeOf(v).Elem()).Interface().(CSVer)
+		cw := csv.NewWriter(w)
+		if err := cw.Write(elem.CSVHeader()); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	stream, err := NewStream(w, format)
+	if err != nil {
+		return err
+	}
+	if isSlice(v) {
+		rv := reflect.ValueOf(v)
+		for i := 0; i < rv.Len(); i++ {
+			if err := stream.Write(rv.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	} else if err := stream.Write(v); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+var csverType = reflect.TypeOf((*CSVer)(nil)).Elem()
+
+func isSlice(v any) bool {
+	return v != nil && reflect.TypeOf(v).Kind() == reflect.Slice
+}
+
+func csvCapable(v any) bool {
+	if v == nil {
+		return false
+	}
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Implements(csverType)
+}
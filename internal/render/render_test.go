@@ -0,0 +1,110 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type plain struct {
+	ID int `json:"id" xml:"id"`
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", FormatJSON, true},
+		{"*/*", FormatJSON, true},
+		{"text/csv", FormatCSV, true},
+		{"application/x-ndjson", FormatNDJSON, true},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", FormatXML, true},
+		{"application/json;q=0.5, text/csv", FormatCSV, true},
+		{"text/*", FormatCSV, true},
+		{"image/png", "", false},
+		{"text/csv;q=0", "", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", c.accept)
+		got, ok := Negotiate(req, FormatJSON, FormatNDJSON, FormatCSV, FormatXML)
+		if got != c.want || ok != c.ok {
+			t.Errorf("Accept %q: got %q %v, want %q %v", c.accept, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func render(accept string, v any) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	Render(w, req, http.StatusOK, v)
+	return w
+}
+
+func TestRenderFormats(t *testing.T) {
+	items := []item{{1, "a"}, {2, "b"}}
+	cases := []struct {
+		accept string
+		ct     string
+		body   string
+	}{
+		{"", "application/json", "[{\"id\":1,\"name\":\"a\"},{\"id\":2,\"name\":\"b\"}]\n"},
+		{"application/x-ndjson", "application/x-ndjson", "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n"},
+		{"text/csv", "text/csv; charset=utf-8", "id,name\n1,a\n1,b\n"},
+		{"application/xml", "application/xml; charset=utf-8", "<items><item><ID>1</ID><Name>a</Name></item><item><ID>2</ID><Name>b</Name></item></items>"},
+	}
+	for _, c := range cases {
+		w := render(c.accept, items)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", c.accept, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != c.ct {
+			t.Errorf("%s: unexpected content type %q", c.accept, ct)
+		}
+		if !strings.HasSuffix(w.Body.String(), c.body) {
+			t.Errorf("%s: unexpected body %q", c.accept, w.Body.String())
+		}
+	}
+}
+
+func TestRenderEmptyCSV(t *testing.T) {
+	w := render("text/csv", []item{})
+	if w.Body.String() != "id,name\n" {
+		t.Fatalf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestRenderNotAcceptable(t *testing.T) {
+	if w := render("image/png", []item{}); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+	// тип без CSVRecord нельзя отдать в CSV
+	if w := render("text/csv", plain{ID: 1}); w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 for csv of plain struct, got %d", w.Code)
+	}
+}
+
+func TestAcceptableMiddleware(t *testing.T) {
+	h := Acceptable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406, got %d", w.Code)
+	}
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Fatalf("expected handler to run, got %d", w.Code)
+	}
+}
@@ -0,0 +1,131 @@
+// Package render отвечает за сериализацию ответов в разных форматах
+package render
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// CSVer описывает тип, который умеет представить себя строкой CSV
+type CSVer interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
+// flushEvery — через сколько записей сбрасывать буфер клиенту
+const flushEvery = 100
+
+// Stream пишет элементы по одному, не накапливая их в памяти: для json —
+// массив, для ndjson — объект на строку, для csv — заголовок и строки.
+// Если w умеет Flush (http.ResponseWriter), данные периодически отправляются.
+type Stream struct {
+	w      io.Writer
+	format string
+	enc    *json.Encoder
+	csv    *csv.Writer
+	n      int
+}
+
+func NewStream(w io.Writer, format string) (*Stream, error) {
+	s := &Stream{w: w, format: format}
+	switch format {
+	case FormatJSON, FormatNDJSON:
+		s.enc = json.NewEncoder(w)
+	case FormatCSV:
+		s.csv = csv.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return s, nil
+}
+
+// ContentType возвращает MIME-тип для формата
+func ContentType(format string) string {
+	switch format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatXML:
+		return "application/xml; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+func (s *Stream) Write(v any) error {
+	switch s.format {
+	case FormatCSV:
+		c, ok := v.(CSVer)
+		if !ok {
+			return fmt.Errorf("%T cannot be written as csv", v)
+		}
+		if s.n == 0 {
+			if err := s.csv.Write(c.CSVHeader()); err != nil {
+				return err
+			}
+		}
+		if err := s.csv.Write(c.CSVRecord()); err != nil {
+			return err
+		}
+	case FormatJSON:
+		sep := ","
+		if s.n == 0 {
+			sep = "["
+		}
+		if _, err := io.WriteString(s.w, sep); err != nil {
+			return err
+		}
+		if err := s.enc.Encode(v); err != nil {
+			return err
+		}
+	default:
+		if err := s.enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	s.n++
+	if s.n%flushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// Close дописывает окончание документа и сбрасывает буферы
+func (s *Stream) Close() error {
+	if s.format == FormatJSON {
+		end := "]\n"
+		if s.n == 0 {
+			end = "[]\n"
+		}
+		if _, err := io.WriteString(s.w, end); err != nil {
+			return err
+		}
+	}
+	return s.flush()
+}
+
+func (s *Stream) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	switch f := s.w.(type) {
+	case http.Flusher:
+		f.Flush()
+	case interface{ Flush() error }:
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (i item) CSVHeader() []string { return []string{"id", "name"} }
+func (i item) CSVRecord() []string { return []string{"1", i.Name} }
+
+func writeAll(t *testing.T, format string, items ...any) string {
+	t.Helper()
+	var buf bytes.Buffer
+	s, err := NewStream(&buf, format)
+	if err != nil {
+		t.Fatalf("NewStream: %v", err)
+	}
+	for _, it := range items {
+		if err := s.Write(it); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return buf.String()
+}
+
+func TestStreamJSON(t *testing.T) {
+	out := writeAll(t, FormatJSON, item{1, "a"}, item{2, "b"})
+	var got []item
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+	if len(got) != 2 || got[1].Name != "b" {
+		t.Fatalf("unexpected items: %+v", got)
+	}
+	if out := writeAll(t, FormatJSON); out != "[]\n" {
+		t.Fatalf("expected empty array, got %q", out)
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	out := writeAll(t, FormatNDJSON, item{1, "a"}, item{2, "b"})
+	if out != "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n" {
+		t.Fatalf("unexpected ndjson: %q", out)
+	}
+}
+
+func TestStreamCSV(t *testing.T) {
+	out := writeAll(t, FormatCSV, item{1, "a"}, item{1, "b,c"})
+	if out != "id,name\n1,a\n1,\"b,c\"\n" {
+		t.Fatalf("unexpected csv: %q", out)
+	}
+	var buf bytes.Buffer
+	s, _ := NewStream(&buf, FormatCSV)
+	if err := s.Write(struct{}{}); err == nil {
+		t.Fatal("expected error for type without CSVRecord")
+	}
+}
+
+func TestStreamFlushesResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	s, _ := NewStream(w, FormatNDJSON)
+	for i := 0; i < flushEvery; i++ {
+		if err := s.Write(item{i, "x"}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if !w.Flushed {
+		t.Fatal("expected response to be flushed")
+	}
+}
+
+func TestNewStreamUnsupported(t *testing.T) {
+	if _, err := NewStream(&bytes.Buffer{}, "xml"); err == nil {
+		t.Fatal("expected error for unsupported format")
+	}
+}
@@ -0,0 +1,494 @@
+package reviews
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/audit"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/render"
+)
+
+type Producer interface {
+	Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error
+	Close() error
+}
+
+var dbi db.TxDB
+var producer Producer
+
+func SetReviewDB(database db.TxDB) {
+	dbi = database
+}
+
+func SetProducer(w Producer) {
+	producer = w
+}
+
+// maxTextLength — предел длины текста отзыва в символах
+const maxTextLength = 10000
+
+// Review — отзыв читателя о книге
+type Review struct {
+	XMLName      xml.Name  `json:"-" xml:"review"`
+	ID           int       `json:"id" xml:"id"`
+	BookID       int       `json:"book_id" xml:"book_id"`
+	UserID       string    `json:"user_id" xml:"user_id"`
+	Rating       int       `json:"rating" xml:"rating"`
+	Text         string    `json:"text" xml:"text"`
+	HelpfulCount int       `json:"helpful_count" xml:"helpful_count"`
+	CreatedAt    time.Time `json:"created_at" xml:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+func (rv Review) CSVHeader() []string {
+	return []string{"id", "book_id", "user_id", "rating", "text", "helpful_count", "created_at"}
+}
+
+func (rv Review) CSVRecord() []string {
+	return []string{strconv.Itoa(rv.ID), strconv.Itoa(rv.BookID), rv.UserID, strconv.Itoa(rv.Rating), rv.Text,
+		strconv.Itoa(rv.HelpfulCount), rv.CreatedAt.Format(time.RFC3339)}
+}
+
+// reviewColumns — порядок колонок, который ожидает scanReview
+const reviewColumns = "id, book_id, user_id, rating, text, helpful_count, created_at, updated_at"
+
+func scanReview(row db.Row) (Review, error) {
+	var rv Review
+	err := row.Scan(&rv.ID, &rv.BookID, &rv.UserID, &rv.Rating, &rv.Text, &rv.HelpfulCount, &rv.CreatedAt, &rv.UpdatedAt)
+	return rv, err
+}
+
+// reviewInput — оценка и текст, которые присылает читатель
+type reviewInput struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+func (in reviewInput) validate() error {
+	if in.Rating < 1 || in.Rating > 5 {
+		return errors.New("rating must be between 1 and 5")
+	}
+	if utf8.RuneCountInString(in.Text) > maxTextLength {
+		return fmt.Errorf("text must be at most %d characters", maxTextLength)
+	}
+	return nil
+}
+
+// Порядок списка отзывов
+const (
+	SortNewest  = "newest"
+	SortOldest  = "oldest"
+	SortHelpful = "helpful"
+)
+
+var sortOrders = map[string]string{
+	SortNewest:  "created_at DESC, id DESC",
+	SortOldest:  "created_at, id",
+	SortHelpful: "helpful_count DESC, created_at DESC, id DESC",
+}
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// listQuery — сортировка и страница списка отзывов
+type listQuery struct {
+	sort          string
+	limit, offset int
+}
+
+func parseListQuery(q url.Values) (listQuery, error) {
+	lq := listQuery{sort: SortNewest, limit: defaultLimit}
+	if v := q.Get("sort"); v != "" {
+		if _, ok := sortOrders[v]; !ok {
+			return lq, errors.New("sort must be newest, oldest or helpful")
+		}
+		lq.sort = v
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return lq, errors.New("limit must be a positive integer")
+		}
+		lq.limit = min(n, maxLimit)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return lq, errors.New("offset must be a non-negative integer")
+		}
+		lq.offset = n
+	}
+	return lq, nil
+}
+
+// reviewer возвращает subject вызывающего; без него отзыв не оставить.
+// false — ответ 401 уже отправлен.
+func reviewer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := middleware.Caller(r)
+	if !ok || id.Subject == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		render.WriteProblem(w, r, http.StatusUnauthorized, "reviews are tied to a user, authentication required")
+		return "", false
+	}
+	return id.Subject, true
+}
+
+// beginTx открывает транзакцию и возвращает функцию отката для defer
+func beginTx(ctx context.Context) (db.TxDB, func(), error) {
+	tx, err := dbi.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			log.Printf("ошибка Rollback: %v", err)
+		}
+	}, nil
+}
+
+// lockBook блокирует книгу, чтобы параллельные отзывы пересчитывали
+// рейтинг по очереди и не теряли друг друга. false — книги нет.
+func lockBook(ctx context.Context, tx db.TxDB, bookID string) (int, bool, error) {
+	var id int
+	err := tx.QueryRow(ctx, "SELECT id FROM books WHERE id=$1 AND deleted_at IS NULL FOR NO KEY UPDATE", bookID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// refreshRating пересчитывает rating_avg и rating_count книги по её отзывам
+func refreshRating(ctx context.Context, tx db.TxDB, bookID int) error {
+	_, err := tx.Exec(ctx, `UPDATE books SET rating_count = s.n, rating_avg = s.avg
+		FROM (SELECT count(*) AS n, round(avg(rating), 2) AS avg FROM reviews WHERE book_id = $1) s
+		WHERE books.id = $1`, bookID)
+	return err
+}
+
+// auditEntity — имя сущности отзыва в журнале изменений
+const auditEntity = "review"
+
+func notify(ctx context.Context, tx db.TxDB, msg string) error {
+	if producer == nil {
+		return nil
+	}
+	return producer.Write(ctx, tx, kafka.Message{Value: []byte(msg)})
+}
+
+// @Summary Отзывы о книге
+// @Tags reviews
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param sort query string false "newest (по умолчанию), oldest или helpful"
+// @Param limit query int false "Отзывов на странице, по умолчанию 20"
+// @Param offset query int false "Сколько отзывов пропустить"
+// @Success 200 {array} Review
+// @Router /api/v1/books/{id}/reviews [get]
+func ListReviews(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	rows, err := dbi.Query(r.Context(), "SELECT "+reviewColumns+", count(*) OVER () FROM reviews WHERE book_id=$1 ORDER BY "+
+		sortOrders[lq.sort]+" LIMIT $2 OFFSET $3", chi.URLParam(r, "id"), lq.limit, lq.offset)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+	list := []Review{}
+	total := 0
+	for rows.Next() {
+		var rv Review
+		if err := rows.Scan(&rv.ID, &rv.BookID, &rv.UserID, &rv.Rating, &rv.Text, &rv.HelpfulCount, &rv.CreatedAt, &rv.UpdatedAt, &total); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		list = append(list, rv)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(list) > 0 || lq.offset == 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	}
+	render.Render(w, r, http.StatusOK, list)
+}
+
+// @Summary Оставить отзыв
+// @Description Один отзыв от пользователя на книгу; рейтинг книги пересчитывается сразу
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param review body reviewInput true "Оценка 1–5 и текст"
+// @Success 201 {object} Review
+// @Failure 409 {string} string "отзыв уже есть"
+// @Router /api/v1/books/{id}/reviews [post]
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+	var in reviewInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	bookID, found, err := lockBook(ctx, tx, chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !found {
+		http.Error(w, "book not found", 404)
+		return
+	}
+	rv, err := scanReview(tx.QueryRow(ctx, `INSERT INTO reviews (book_id, user_id, rating, text) VALUES ($1, $2, $3, $4)
+		RETURNING `+reviewColumns, bookID, user, in.Rating, in.Text))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, "you have already reviewed this book, update your review instead", 409)
+			return
+		}
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := refreshRating(ctx, tx, bookID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "create", auditEntity, &rv.ID, nil, rv); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("created review: %d book=%d", rv.ID, bookID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusCreated, rv)
+}
+
+// ownReview блокирует книгу и отзыв и проверяет, что отзыв принадлежит
+// вызывающему или у него есть право модерации; false — ответ уже отправлен
+func ownReview(ctx context.Context, w http.ResponseWriter, r *http.Request, tx db.TxDB, user string) (Review, bool) {
+	bookID, found, err := lockBook(ctx, tx, chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return Review{}, false
+	}
+	if !found {
+		http.Error(w, "book not found", 404)
+		return Review{}, false
+	}
+	rv, err := scanReview(tx.QueryRow(ctx, "SELECT "+reviewColumns+" FROM reviews WHERE id=$1 AND book_id=$2 FOR UPDATE",
+		chi.URLParam(r, "review_id"), bookID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "review not found", 404)
+		return Review{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return Review{}, false
+	}
+	if rv.UserID != user && !middleware.Can(r, middleware.PermReviewsModerate) {
+		render.WriteProblem(w, r, http.StatusForbidden, "only the author of the review can change it")
+		return Review{}, false
+	}
+	return rv, true
+}
+
+// @Summary Изменить свой отзыв
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param review_id path int true "ID отзыва"
+// @Param review body reviewInput true "Оценка 1–5 и текст"
+// @Success 200 {object} Review
+// @Failure 403 {object} render.Problem "чужой отзыв"
+// @Router /api/v1/books/{id}/reviews/{review_id} [put]
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+	var in reviewInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := in.validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	before, ok := ownReview(ctx, w, r, tx, user)
+	if !ok {
+		return
+	}
+	after, err := scanReview(tx.QueryRow(ctx, "UPDATE reviews SET rating=$1, text=$2, updated_at=now() WHERE id=$3 RETURNING "+reviewColumns,
+		in.Rating, in.Text, before.ID))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := refreshRating(ctx, tx, before.BookID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "update", auditEntity, &before.ID, before, after); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("updated review: %d", after.ID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, after)
+}
+
+// @Summary Удалить свой отзыв
+// @Tags reviews
+// @Param id path int true "ID книги"
+// @Param review_id path int true "ID отзыва"
+// @Success 204 {string} string "Отзыв удалён"
+// @Failure 403 {object} render.Problem "чужой отзыв"
+// @Router /api/v1/books/{id}/reviews/{review_id} [delete]
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	before, ok := ownReview(ctx, w, r, tx, user)
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM reviews WHERE id=$1", before.ID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := refreshRating(ctx, tx, before.BookID); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := audit.Record(ctx, tx, audit.FromRequest(r), "delete", auditEntity, &before.ID, before, nil); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := notify(ctx, tx, fmt.Sprintf("deleted review: %d", before.ID)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// @Summary Отметить отзыв полезным
+// @Description Повторный голос того же пользователя ничего не меняет; свой отзыв отметить нельзя
+// @Tags reviews
+// @Produce json
+// @Param id path int true "ID книги"
+// @Param review_id path int true "ID отзыва"
+// @Success 200 {object} Review
+// @Router /api/v1/books/{id}/reviews/{review_id}/helpful [post]
+func MarkHelpful(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, ok := reviewer(w, r)
+	if !ok {
+		return
+	}
+	tx, rollback, err := beginTx(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rollback()
+	rv, err := scanReview(tx.QueryRow(ctx, "SELECT "+reviewColumns+" FROM reviews WHERE id=$1 AND book_id=$2 FOR UPDATE",
+		chi.URLParam(r, "review_id"), chi.URLParam(r, "id")))
+	if errors.Is(err, pgx.ErrNoRows) {
+		http.Error(w, "review not found", 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if rv.UserID == user {
+		http.Error(w, "you cannot vote for your own review", 400)
+		return
+	}
+	tag, err := tx.Exec(ctx, "INSERT INTO review_votes (review_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", rv.ID, user)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, "UPDATE reviews SET helpful_count = helpful_count + 1 WHERE id=$1", rv.ID); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		rv.HelpfulCount++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	render.Render(w, r, http.StatusOK, rv)
+}
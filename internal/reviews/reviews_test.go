@@ -0,0 +1,175 @@
+package reviews
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"books-api/internal/books"
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+	"books-api/internal/testutil"
+)
+
+// reviewRow — отзыв 1 пользователя alice о книге 1
+type reviewRow struct{ total bool }
+
+func (r reviewRow) Scan(dest ...any) error {
+	*dest[0].(*int) = 1
+	*dest[1].(*int) = 1
+	*dest[2].(*string) = "alice"
+	*dest[3].(*int) = 4
+	*dest[4].(*string) = "Good"
+	*dest[6].(*time.Time) = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if r.total {
+		*dest[8].(*int) = 1
+	}
+	return nil
+}
+
+type reviewRows struct{ idx int }
+
+func (r *reviewRows) Next() bool             { r.idx++; return r.idx == 1 }
+func (r *reviewRows) Scan(dest ...any) error { return reviewRow{total: true}.Scan(dest...) }
+func (r *reviewRows) Close()                 {}
+func (r *reviewRows) Err() error             { return nil }
+
+// mockDB: книга 1 есть, отзыв 1 написала alice
+type mockDB struct {
+	testutil.Tx
+	testutil.Statements
+	noBook    bool
+	duplicate bool
+	queries   []string
+}
+
+func (m *mockDB) Query(ctx context.Context, sql string, args ...any) (db.Rows, error) {
+	m.queries = append(m.queries, sql)
+	return &reviewRows{}, nil
+}
+func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...any) db.Row {
+	switch {
+	case strings.HasPrefix(sql, "SELECT id FROM books"):
+		if m.noBook {
+			return testutil.Row{Err: pgx.ErrNoRows}
+		}
+		return testutil.Row{Values: []any{1}}
+	case strings.HasPrefix(sql, "INSERT INTO reviews") && m.duplicate:
+		return testutil.Row{Err: &pgconn.PgError{Code: "23505"}}
+	case strings.HasPrefix(sql, "INSERT INTO audit_log"):
+		return testutil.Row{Values: []any{1}}
+	}
+	return reviewRow{}
+}
+func (m *mockDB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	m.Record(sql, args)
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+func (m *mockDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (db.TxDB, error) {
+	return m, nil
+}
+
+var serve = testutil.Server(books.RegisterRoutes, RegisterRoutes)
+
+func TestCreateReview(t *testing.T) {
+	database := &mockDB{}
+	SetReviewDB(database)
+	events := &testutil.Producer{}
+	SetProducer(events)
+
+	if w := serve(http.MethodPost, "/books/1/reviews", `{"rating":4,"text":"Good"}`); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a user, got %d", w.Code)
+	}
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	w := serve(http.MethodPost, "/books/1/reviews", `{"rating":4,"text":"Good"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if database.Count("UPDATE books SET rating_count") == 0 {
+		t.Fatalf("rating was not refreshed: %v", database.SQL)
+	}
+	if len(events.Msgs) != 1 || events.Msgs[0] != "created review: 1 book=1" {
+		t.Fatalf("unexpected events %v", events.Msgs)
+	}
+
+	for _, body := range []string{`{"rating":0}`, `{"rating":6,"text":"x"}`} {
+		if w := serve(http.MethodPost, "/books/1/reviews", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	database.duplicate = true
+	if w := serve(http.MethodPost, "/books/1/reviews", `{"rating":5}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a second review, got %d", w.Code)
+	}
+	database.noBook = true
+	if w := serve(http.MethodPost, "/books/1/reviews", `{"rating":5}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing book, got %d", w.Code)
+	}
+}
+
+func TestUpdateAndDeleteOwnReview(t *testing.T) {
+	database := &mockDB{}
+	SetReviewDB(database)
+	SetProducer(&testutil.Producer{})
+
+	testutil.AsUser(t, "bob", middleware.RoleReader)
+	if w := serve(http.MethodPut, "/books/1/reviews/1", `{"rating":1}`); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for someone else's review, got %d", w.Code)
+	}
+	if w := serve(http.MethodDelete, "/books/1/reviews/1", ``); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for someone else's review, got %d", w.Code)
+	}
+
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve(http.MethodPut, "/books/1/reviews/1", `{"rating":5,"text":"Great"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodDelete, "/books/1/reviews/1", ``); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if database.Count("DELETE FROM reviews") == 0 || database.Count("UPDATE books SET rating_count") == 0 {
+		t.Fatalf("unexpected statements %v", database.SQL)
+	}
+
+	testutil.AsUser(t, "carol", middleware.RoleAdmin)
+	if w := serve(http.MethodDelete, "/books/1/reviews/1", ``); w.Code != http.StatusNoContent {
+		t.Fatalf("expected moderator to delete, got %d", w.Code)
+	}
+}
+
+func TestListReviews(t *testing.T) {
+	database := &mockDB{}
+	SetReviewDB(database)
+	w := serve(http.MethodGet, "/books/1/reviews?sort=helpful&limit=5", ``)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Total-Count") != "1" || !strings.Contains(w.Body.String(), `"user_id":"alice"`) {
+		t.Fatalf("unexpected response %v %s", w.Header(), w.Body.String())
+	}
+	if len(database.queries) != 1 || !strings.Contains(database.queries[0], "ORDER BY helpful_count DESC") {
+		t.Fatalf("unexpected query %v", database.queries)
+	}
+	if w := serve(http.MethodGet, "/books/1/reviews?sort=rating", ``); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown sort, got %d", w.Code)
+	}
+}
+
+func TestMarkHelpful(t *testing.T) {
+	database := &mockDB{}
+	SetReviewDB(database)
+	testutil.AsUser(t, "alice", middleware.RoleReader)
+	if w := serve(http.MethodPost, "/books/1/reviews/1/helpful", ``); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for own review, got %d", w.Code)
+	}
+	testutil.AsUser(t, "bob", middleware.RoleReader)
+	w := serve(http.MethodPost, "/books/1/reviews/1/helpful", ``)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"helpful_count":1`) {
+		t.Fatalf("expected the vote to count, got %d: %s", w.Code, w.Body.String())
+	}
+}
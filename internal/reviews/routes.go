@@ -0,0 +1,18 @@
+package reviews
+
+import (
+	"github.com/go-chi/chi/v5"
+
+	"books-api/internal/middleware"
+)
+
+// RegisterRoutes регистрирует роуты отзывов о книгах
+func RegisterRoutes(r chi.Router) {
+	r.Route("/books/{id}/reviews", func(r chi.Router) {
+		r.With(middleware.Require(middleware.PermRead)).Get("/", ListReviews)
+		r.With(middleware.Require(middleware.PermReviewsWrite)).Post("/", CreateReview)
+		r.With(middleware.Require(middleware.PermReviewsWrite)).Put("/{review_id}", UpdateReview)
+		r.With(middleware.Require(middleware.PermReviewsWrite)).Delete("/{review_id}", DeleteReview)
+		r.With(middleware.Require(middleware.PermReviewsWrite)).Post("/{review_id}/helpful", MarkHelpful)
+	})
+}
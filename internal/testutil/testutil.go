@@ -0,0 +1,118 @@
+// Package testutil — общие заготовки тестов обработчиков: строки без БД,
+// фиксация транзакции, запись запросов и событий, запрос к роутеру и
+// вызывающий пользователь. Импортируется только из _test.go.
+package testutil
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/segmentio/kafka-go"
+
+	"books-api/internal/db"
+	"books-api/internal/middleware"
+)
+
+// Row раскладывает Values по указателям Scan по порядку; nil оставляет
+// назначение как есть. С Err вместо этого возвращает ошибку.
+type Row struct {
+	Values []any
+	Err    error
+}
+
+func (r Row) Scan(dest ...any) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for i, v := range r.Values {
+		if v != nil {
+			reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+		}
+	}
+	return nil
+}
+
+// Rows отдаёт строки Values по очереди, как Row
+type Rows struct {
+	Values [][]any
+	idx    int
+}
+
+func (r *Rows) Next() bool             { r.idx++; return r.idx <= len(r.Values) }
+func (r *Rows) Scan(dest ...any) error { return Row{Values: r.Values[r.idx-1]}.Scan(dest...) }
+func (r *Rows) Close()                 {}
+func (r *Rows) Err() error             { return nil }
+
+// Tx — откат и фиксация для моков db.TxDB; Commits считает фиксации
+type Tx struct {
+	Commits int
+}
+
+func (t *Tx) Rollback(ctx context.Context) error { return nil }
+func (t *Tx) Commit(ctx context.Context) error   { t.Commits++; return nil }
+
+// Statements запоминает запросы с аргументами. Пробелы в SQL схлопываются,
+// чтобы многострочные запросы можно было сравнивать по префиксу.
+type Statements struct {
+	SQL  []string
+	Args [][]any
+}
+
+func (s *Statements) Record(sql string, args []any) {
+	s.SQL = append(s.SQL, strings.Join(strings.Fields(sql), " "))
+	s.Args = append(s.Args, args)
+}
+
+// Count — сколько запомненных запросов начинается с prefix
+func (s *Statements) Count(prefix string) int {
+	n := 0
+	for _, sql := range s.SQL {
+		if strings.HasPrefix(sql, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// Producer запоминает тексты записанных событий; с Err вместо этого
+// возвращает ошибку, как прерванная вставка в outbox
+type Producer struct {
+	Msgs []string
+	Err  error
+}
+
+func (p *Producer) Write(ctx context.Context, tx db.TxDB, msgs ...kafka.Message) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	for _, m := range msgs {
+		p.Msgs = append(p.Msgs, string(m.Value))
+	}
+	return nil
+}
+func (p *Producer) Close() error { return nil }
+
+// Server собирает роутер из register и возвращает функцию запроса к нему
+func Server(register ...func(chi.Router)) func(method, target, body string) *httptest.ResponseRecorder {
+	return func(method, target, body string) *httptest.ResponseRecorder {
+		r := chi.NewRouter()
+		for _, reg := range register {
+			reg(r)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+}
+
+// AsUser выполняет запросы теста от имени subject с ролями roles
+// (права ролей — middleware.DefaultRoles)
+func AsUser(t *testing.T, subject string, roles ...string) {
+	t.Helper()
+	middleware.SetPolicy(&middleware.Policy{Extract: middleware.StubIdentity(middleware.Identity{Subject: subject, Roles: roles})})
+	t.Cleanup(func() { middleware.SetPolicy(nil) })
+}
@@ -1,7 +1,7 @@
-CREATE TABLE books (
+CREATE TABLE IF NOT EXISTS books (
     id SERIAL PRIMARY KEY,
     title TEXT NOT NULL,
     author TEXT NOT NULL,
     published_at DATE,
     created_at TIMESTAMP DEFAULT NOW()
-); 
\ No newline at end of file
+);
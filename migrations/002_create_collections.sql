@@ -1,12 +1,12 @@
-CREATE TABLE collections (
+CREATE TABLE IF NOT EXISTS collections (
     id SERIAL PRIMARY KEY,
     name TEXT NOT NULL,
     description TEXT,
     created_at TIMESTAMP DEFAULT NOW()
 );
 
-CREATE TABLE collection_books (
+CREATE TABLE IF NOT EXISTS collection_books (
     collection_id INT REFERENCES collections(id) ON DELETE CASCADE,
     book_id INT REFERENCES books(id) ON DELETE CASCADE,
     PRIMARY KEY (collection_id, book_id)
-); 
\ No newline at end of file
+);
@@ -0,0 +1,4 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS isbn TEXT;
+
+-- ISBN хранится нормализованным в ISBN-13, NULL допускается многократно
+CREATE UNIQUE INDEX IF NOT EXISTS books_isbn_key ON books (isbn);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS authors (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    bio TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id INT REFERENCES books(id) ON DELETE CASCADE,
+    author_id INT REFERENCES authors(id) ON DELETE RESTRICT,
+    role TEXT NOT NULL DEFAULT 'author' CHECK (role IN ('author', 'editor', 'translator', 'illustrator')),
+    position INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS book_authors_author_idx ON book_authors (author_id);
+
+-- Разбиваем существующие строки books.author на отдельных авторов только по
+-- ; и &: запятая, and и и встречаются внутри одного имени.
+-- Колонка books.author остаётся денормализованной строкой для отображения.
+WITH parts AS (
+    SELECT b.id AS book_id, btrim(p.name) AS name, p.ord
+    FROM books b
+    CROSS JOIN LATERAL regexp_split_to_table(b.author, '\s*[;&]\s*') WITH ORDINALITY AS p(name, ord)
+    WHERE btrim(p.name) <> ''
+      AND NOT EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = b.id)
+), ins AS (
+    INSERT INTO authors (name)
+    SELECT DISTINCT name FROM parts
+    ON CONFLICT (name) DO NOTHING
+    RETURNING id, name
+)
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT parts.book_id, COALESCE(ins.id, a.id), 'author', parts.ord - 1
+FROM parts
+LEFT JOIN ins ON ins.name = parts.name
+LEFT JOIN authors a ON a.name = parts.name
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    slug TEXT NOT NULL UNIQUE,
+    parent_id INT REFERENCES genres(id) ON DELETE RESTRICT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    CHECK (parent_id IS NULL OR parent_id <> id)
+);
+
+CREATE INDEX IF NOT EXISTS genres_parent_idx ON genres (parent_id);
+
+CREATE TABLE IF NOT EXISTS book_genres (
+    book_id INT REFERENCES books(id) ON DELETE CASCADE,
+    genre_id INT REFERENCES genres(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS book_genres_genre_idx ON book_genres (genre_id);
@@ -0,0 +1,4 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS language TEXT CHECK (language ~ '^[a-z]{2,3}$');
+
+CREATE INDEX IF NOT EXISTS books_language_idx ON books (language);
+CREATE INDEX IF NOT EXISTS books_published_year_idx ON books ((extract(year FROM published_at)));
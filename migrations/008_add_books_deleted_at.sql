@@ -0,0 +1,5 @@
+-- книги больше не удаляются сразу: строка и её связи с подборками
+-- остаются до очистки корзины
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS book_revisions (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    rev INT NOT NULL,
+    at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor TEXT NOT NULL,
+    request_id TEXT,
+    action TEXT NOT NULL,
+    data JSONB NOT NULL,
+    PRIMARY KEY (book_id, rev)
+);
+
+-- у уже существующих книг первая ревизия — текущее состояние
+INSERT INTO book_revisions (book_id, rev, actor, action, data)
+SELECT books.id, 1, 'system:migrate', 'create', jsonb_build_object(
+    'id', books.id,
+    'title', books.title,
+    'author', books.author,
+    'published_at', books.published_at::text,
+    'isbn', books.isbn,
+    'language', books.language,
+    'deleted_at', books.deleted_at,
+    'authors', COALESCE((SELECT json_agg(json_build_object('id', a.id, 'name', a.name, 'role', ba.role, 'position', ba.position) ORDER BY ba.position, a.id)
+        FROM book_authors ba JOIN authors a ON a.id = ba.author_id WHERE ba.book_id = books.id), '[]'::json),
+    'genres', COALESCE((SELECT json_agg(json_build_object('id', g.id, 'name', g.name, 'slug', g.slug) ORDER BY g.name)
+        FROM book_genres bg JOIN genres g ON g.id = bg.genre_id WHERE bg.book_id = books.id), '[]'::json))
+FROM books
+ON CONFLICT DO NOTHING;
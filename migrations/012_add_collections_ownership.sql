@@ -0,0 +1,17 @@
+-- владелец — subject вызывающего (из JWT, X-User или ключа API); у старых
+-- подборок владельца нет, они остаются публичными и общими
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS owner_id TEXT;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('private', 'unlisted', 'public'));
+
+CREATE INDEX IF NOT EXISTS collections_owner_idx ON collections (owner_id);
+
+CREATE TABLE IF NOT EXISTS collection_members (
+    collection_id INT REFERENCES collections(id) ON DELETE CASCADE,
+    member_id TEXT NOT NULL,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor')),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (collection_id, member_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_members_member_idx ON collection_members (member_id);
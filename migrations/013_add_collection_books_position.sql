@@ -0,0 +1,16 @@
+-- порядок книг в подборке: позиции 1..n без пропусков. Ограничение
+-- проверяется в конце оператора, чтобы сдвиг соседей одним UPDATE не
+-- упирался в промежуточные дубликаты
+ALTER TABLE collection_books ADD COLUMN IF NOT EXISTS position INT;
+
+UPDATE collection_books cb SET position = o.pos
+FROM (
+    SELECT collection_id, book_id, row_number() OVER (PARTITION BY collection_id ORDER BY book_id) AS pos
+    FROM collection_books
+) o
+WHERE cb.collection_id = o.collection_id AND cb.book_id = o.book_id AND cb.position IS NULL;
+
+ALTER TABLE collection_books ALTER COLUMN position SET NOT NULL;
+ALTER TABLE collection_books ADD CONSTRAINT collection_books_position_key
+    UNIQUE (collection_id, position) DEFERRABLE INITIALLY IMMEDIATE;
+ALTER TABLE collection_books ADD CONSTRAINT collection_books_position_check CHECK (position > 0);
@@ -0,0 +1,13 @@
+-- умные подборки: состав задаётся правилом (JSON) над полями книг и
+-- считается при чтении; materialized — состав хранится в
+-- collection_rule_books и пересчитывается по событиям книг
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS rule JSONB;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS materialized BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS rule_refreshed_at TIMESTAMPTZ;
+ALTER TABLE collections ADD CONSTRAINT collections_materialized_rule_check CHECK (NOT materialized OR rule IS NOT NULL);
+
+CREATE TABLE IF NOT EXISTS collection_rule_books (
+    collection_id INT REFERENCES collections(id) ON DELETE CASCADE,
+    book_id INT REFERENCES books(id) ON DELETE CASCADE,
+    PRIMARY KEY (collection_id, book_id)
+);
@@ -0,0 +1,6 @@
+-- вложенные подборки: полка содержит другие подборки. Циклы отсекает
+-- API; при удалении родителя дочерние подборки становятся корневыми
+ALTER TABLE collections ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES collections(id) ON DELETE SET NULL;
+ALTER TABLE collections ADD CONSTRAINT collections_parent_check CHECK (parent_id <> id);
+
+CREATE INDEX IF NOT EXISTS collections_parent_idx ON collections (parent_id);
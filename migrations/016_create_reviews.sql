@@ -0,0 +1,26 @@
+-- отзывы читателей: одна оценка 1–5 с текстом от пользователя на книгу;
+-- rating_avg и rating_count книги пересчитываются в той же транзакции
+CREATE TABLE IF NOT EXISTS reviews (
+    id SERIAL PRIMARY KEY,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    text TEXT NOT NULL DEFAULT '',
+    helpful_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (book_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_book_created_idx ON reviews (book_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS reviews_book_helpful_idx ON reviews (book_id, helpful_count DESC, id DESC);
+
+-- голоса «отзыв полезен», по одному от пользователя
+CREATE TABLE IF NOT EXISTS review_votes (
+    review_id INT REFERENCES reviews(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (review_id, user_id)
+);
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_avg NUMERIC(3, 2);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_count INT NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+-- остатки книг по складам: quantity — всего на складе, reserved — из них
+-- отложено под заказы; доступно quantity - reserved
+CREATE TABLE IF NOT EXISTS inventory (
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    location TEXT NOT NULL,
+    quantity INT NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+    reserved INT NOT NULL DEFAULT 0 CHECK (reserved >= 0 AND reserved <= quantity),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (book_id, location)
+);
+
+CREATE INDEX IF NOT EXISTS inventory_location_idx ON inventory (location);
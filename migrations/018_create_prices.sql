@@ -0,0 +1,16 @@
+-- цены книг по валютам: amount — в минимальных единицах валюты (центы),
+-- цена действует с valid_from до valid_to (NULL — бессрочно). Периоды
+-- одной книги и валюты не пересекаются — это держит приложение.
+CREATE TABLE IF NOT EXISTS prices (
+    id BIGSERIAL PRIMARY KEY,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    currency CHAR(3) NOT NULL CHECK (currency ~ '^[A-Z]{3}$'),
+    amount BIGINT NOT NULL CHECK (amount >= 0),
+    valid_from TIMESTAMPTZ NOT NULL,
+    valid_to TIMESTAMPTZ,
+    created_by TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (valid_to IS NULL OR valid_to > valid_from)
+);
+
+CREATE INDEX IF NOT EXISTS prices_book_currency_idx ON prices (book_id, currency, valid_from);
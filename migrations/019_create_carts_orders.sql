@@ -0,0 +1,52 @@
+-- корзины покупателей: цены в них не хранятся, берутся действующие
+CREATE TABLE IF NOT EXISTS carts (
+    id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS carts_user_idx ON carts (user_id);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    cart_id INT NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+    book_id INT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (cart_id, book_id)
+);
+
+-- заказы: позиции хранят название и цену на момент оформления,
+-- поэтому book_id без внешнего ключа — книгу могут удалить
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid', 'shipped', 'cancelled')),
+    currency CHAR(3) NOT NULL,
+    total BIGINT NOT NULL CHECK (total >= 0),
+    payment_ref TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS orders_user_idx ON orders (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS orders_status_idx ON orders (status);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    book_id INT NOT NULL,
+    title TEXT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT NOT NULL CHECK (unit_price >= 0),
+    PRIMARY KEY (order_id, book_id)
+);
+
+-- откуда отложены экземпляры позиции: снимаются при отмене, списываются при отгрузке
+CREATE TABLE IF NOT EXISTS order_reservations (
+    order_id INT NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    book_id INT NOT NULL,
+    location TEXT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (order_id, book_id, location)
+);